@@ -0,0 +1,785 @@
+package main
+
+import (
+	"context"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Accounts are created by an admin and log in with a password and, once
+// enrolled, a TOTP code or a recovery code. A login opens a session whose
+// token is sent as the nb_session cookie or as "Authorization: Bearer
+// nbs_...". Requests with a session act as the account instead of the
+// X-Board-User header.
+//
+// Organizations listed in NOTE_BOARD_TOTP_REQUIRED_ORGS require two-factor
+// authentication: a login to an account that has not enrolled opens a
+// pending session that can only be used to enroll.
+
+const (
+	sessionCookie      = "nb_session"
+	sessionTokenPrefix = "nbs_"
+
+	passwordIterations = 600000
+	minPasswordLen     = 10
+)
+
+type account struct {
+	Name string
+	Org  string
+
+	passwordSalt []byte
+	passwordHash []byte
+
+	totpSecret    []byte
+	pendingSecret []byte
+	totpLastStep  int64
+	recoveryCodes map[string]bool
+
+	keys       []publicKey
+	recipients []ageRecipient
+}
+
+func (acc *account) totpEnabled() bool {
+	return acc.totpSecret != nil
+}
+
+type session struct {
+	ID       string    `json:"id"`
+	Account  string    `json:"account"`
+	Org      string    `json:"-"`
+	Device   string    `json:"device"`
+	IP       string    `json:"ip"`
+	Created  time.Time `json:"created"`
+	LastSeen time.Time `json:"lastSeen"`
+	Expires  time.Time `json:"expires"`
+	// Pending sessions belong to accounts that must enroll in two-factor
+	// authentication before they can do anything else.
+	Pending bool `json:"pending,omitempty"`
+
+	tokenHash string
+}
+
+type Accounts struct {
+	mu       sync.Mutex
+	accounts map[string]*account
+	sessions map[string]*session
+
+	sessionTTL   time.Duration
+	totpRequired map[string]bool
+	throttle     *loginThrottle
+}
+
+func NewAccounts(sessionTTL time.Duration, totpRequiredOrgs []string) *Accounts {
+	a := &Accounts{
+		accounts:     make(map[string]*account),
+		sessions:     make(map[string]*session),
+		sessionTTL:   sessionTTL,
+		totpRequired: make(map[string]bool),
+		throttle:     newLoginThrottle(),
+	}
+	for _, org := range totpRequiredOrgs {
+		if org = strings.TrimSpace(org); org != "" {
+			a.totpRequired[org] = true
+		}
+	}
+
+	go a.sweep(10 * time.Minute)
+
+	return a
+}
+
+// sweep drops expired sessions and forgotten login failures every
+// interval.
+func (a *Accounts) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		a.mu.Lock()
+		for hash, s := range a.sessions {
+			if now.After(s.Expires) {
+				delete(a.sessions, hash)
+			}
+		}
+		a.mu.Unlock()
+
+		a.throttle.sweep(now)
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func hashPassword(password string, salt []byte) []byte {
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, 32)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func checkAccountName(name string) error {
+	if name == "" || len(name) > 64 {
+		return errors.New("name must be 1 to 64 bytes")
+	}
+	if strings.IndexFunc(name, func(r rune) bool { return unicode.IsControl(r) || unicode.IsSpace(r) }) >= 0 {
+		return errors.New("name must not contain spaces or control characters")
+	}
+	return nil
+}
+
+func (a *Accounts) Register(name, org, password string) error {
+	if err := checkAccountName(name); err != nil {
+		return err
+	}
+	if len(password) < minPasswordLen {
+		return errors.New("password is too short")
+	}
+
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	hash := hashPassword(password, salt)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, exists := a.accounts[name]; exists {
+		return errors.New("account already exists")
+	}
+	a.accounts[name] = &account{Name: name, Org: org, passwordSalt: salt, passwordHash: hash}
+	return nil
+}
+
+var (
+	errInvalidCredentials = errors.New("invalid name or password")
+	errTOTPRequired       = errors.New("two-factor code required")
+	errInvalidTOTP        = errors.New("invalid two-factor code")
+)
+
+// Login checks the credentials and opens a session. code is a TOTP or
+// recovery code and is only needed once the account has enrolled. Failed
+// attempts are throttled by account and by client address.
+func (a *Accounts) Login(name, password, code string, r *http.Request) (string, *session, error) {
+	now := time.Now()
+	keys := []string{"account:" + name, "addr:" + clientIP(r)}
+	if err := a.throttle.check(now, keys...); err != nil {
+		return "", nil, err
+	}
+
+	a.mu.Lock()
+	acc, ok := a.accounts[name]
+	a.mu.Unlock()
+
+	// Hash even for unknown accounts so that timing does not reveal them.
+	salt := make([]byte, 16)
+	if ok {
+		salt = acc.passwordSalt
+	}
+	hash := hashPassword(password, salt)
+	if !ok || subtle.ConstantTimeCompare(hash, acc.passwordHash) != 1 {
+		// The wait runs from now, not from before the slow hash.
+		a.throttle.failed(time.Now(), keys...)
+		return "", nil, errInvalidCredentials
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if acc.totpEnabled() {
+		if code == "" {
+			return "", nil, errTOTPRequired
+		}
+		if !a.checkSecondFactor(acc, code) {
+			a.throttle.failed(time.Now(), keys...)
+			return "", nil, errInvalidTOTP
+		}
+	}
+	a.throttle.succeeded(keys[0])
+
+	token := sessionTokenPrefix + randomHex(32)
+	s := &session{
+		ID:        randomHex(8),
+		Account:   acc.Name,
+		Org:       acc.Org,
+		Device:    r.UserAgent(),
+		IP:        clientIP(r),
+		Created:   now,
+		LastSeen:  now,
+		Expires:   now.Add(a.sessionTTL),
+		Pending:   a.totpRequired[acc.Org] && !acc.totpEnabled(),
+		tokenHash: hashToken(token),
+	}
+	a.sessions[s.tokenHash] = s
+
+	copied := *s
+	return token, &copied, nil
+}
+
+// checkSecondFactor accepts a TOTP code or consumes a recovery code. Callers
+// hold a.mu.
+func (a *Accounts) checkSecondFactor(acc *account, code string) bool {
+	if step, ok := verifyTOTP(acc.totpSecret, code, time.Now(), acc.totpLastStep); ok {
+		acc.totpLastStep = step
+		return true
+	}
+
+	h := hashRecoveryCode(code)
+	if acc.recoveryCodes[h] {
+		delete(acc.recoveryCodes, h)
+		return true
+	}
+	return false
+}
+
+// session returns a copy of the live session with the given token and marks
+// it as used from r.
+func (a *Accounts) session(token string, r *http.Request) (*session, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s, ok := a.sessions[hashToken(token)]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(s.Expires) {
+		delete(a.sessions, s.tokenHash)
+		return nil, false
+	}
+
+	s.LastSeen = time.Now()
+	s.IP = clientIP(r)
+	if ua := r.UserAgent(); ua != "" {
+		s.Device = ua
+	}
+	copied := *s
+	return &copied, true
+}
+
+func (a *Accounts) Sessions(name string) []session {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	sessions := []session{}
+	now := time.Now()
+	for _, s := range a.sessions {
+		if s.Account == name && now.Before(s.Expires) {
+			sessions = append(sessions, *s)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeen.After(sessions[j].LastSeen) })
+	return sessions
+}
+
+// Revoke ends the session of account name with the given id.
+func (a *Accounts) Revoke(name, id string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for hash, s := range a.sessions {
+		if s.Account == name && s.ID == id {
+			delete(a.sessions, hash)
+			return true
+		}
+	}
+	return false
+}
+
+// StartEnrollment generates a new TOTP secret for name. It only takes effect
+// once ConfirmEnrollment sees a code generated from it.
+func (a *Accounts) StartEnrollment(name string) ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	acc, ok := a.accounts[name]
+	if !ok {
+		return nil, errors.New("no such account")
+	}
+	if acc.totpEnabled() {
+		return nil, errors.New("two-factor authentication is already enabled")
+	}
+	acc.pendingSecret = newTOTPSecret()
+	return acc.pendingSecret, nil
+}
+
+// ConfirmEnrollment enables TOTP for name, returns its recovery codes and
+// turns the account's pending sessions into full ones.
+func (a *Accounts) ConfirmEnrollment(name, code string) ([]string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	acc, ok := a.accounts[name]
+	if !ok {
+		return nil, errors.New("no such account")
+	}
+	if acc.pendingSecret == nil {
+		return nil, errors.New("no enrollment in progress")
+	}
+	step, ok := verifyTOTP(acc.pendingSecret, code, time.Now(), 0)
+	if !ok {
+		return nil, errInvalidTOTP
+	}
+
+	codes, hashes := newRecoveryCodes()
+	acc.totpSecret, acc.pendingSecret = acc.pendingSecret, nil
+	acc.totpLastStep = step
+	acc.recoveryCodes = hashes
+
+	for _, s := range a.sessions {
+		if s.Account == name {
+			s.Pending = false
+		}
+	}
+	return codes, nil
+}
+
+// DisableTOTP turns two-factor authentication off, which organizations that
+// require it do not allow.
+func (a *Accounts) DisableTOTP(name, code string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	acc, ok := a.accounts[name]
+	if !ok {
+		return errors.New("no such account")
+	}
+	if !acc.totpEnabled() {
+		return errors.New("two-factor authentication is not enabled")
+	}
+	if a.totpRequired[acc.Org] {
+		return errors.New("two-factor authentication is required by the organization")
+	}
+	now, key := time.Now(), "account:"+name
+	if err := a.throttle.check(now, key); err != nil {
+		return err
+	}
+	if !a.checkSecondFactor(acc, code) {
+		a.throttle.failed(now, key)
+		return errInvalidTOTP
+	}
+	a.throttle.succeeded(key)
+
+	acc.totpSecret, acc.recoveryCodes = nil, nil
+	return nil
+}
+
+type sessionKey struct{}
+
+// sessionFrom returns the session r was made with, if any.
+func sessionFrom(r *http.Request) *session {
+	s, _ := r.Context().Value(sessionKey{}).(*session)
+	return s
+}
+
+// sessionUser returns the account of a full session of r.
+func sessionUser(r *http.Request) (string, bool) {
+	if s := sessionFrom(r); s != nil && !s.Pending {
+		return s.Account, true
+	}
+	return "", false
+}
+
+func sessionToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && strings.HasPrefix(token, sessionTokenPrefix) {
+		return token
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// accountExport is the data held about an account, for privacy exports.
+// Secrets such as the password hash and the TOTP secret are left out.
+type accountExport struct {
+	Name        string         `json:"name"`
+	Org         string         `json:"org,omitempty"`
+	TOTPEnabled bool           `json:"totpEnabled"`
+	Keys        []publicKey    `json:"signingKeys"`
+	Recipients  []ageRecipient `json:"recipients"`
+	Sessions    []session      `json:"sessions"`
+}
+
+// export returns the data held about the account name, or nil when there is
+// no such account.
+func (a *Accounts) export(name string) *accountExport {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	acc, ok := a.accounts[name]
+	if !ok {
+		return nil
+	}
+	ex := &accountExport{
+		Name:        acc.Name,
+		Org:         acc.Org,
+		TOTPEnabled: acc.totpEnabled(),
+		Keys:        append([]publicKey{}, acc.keys...),
+		Recipients:  append([]ageRecipient{}, acc.recipients...),
+		Sessions:    []session{},
+	}
+	for _, s := range a.sessions {
+		if s.Account == name {
+			ex.Sessions = append(ex.Sessions, *s)
+		}
+	}
+	sort.Slice(ex.Sessions, func(i, j int) bool { return ex.Sessions[i].Created.Before(ex.Sessions[j].Created) })
+	return ex
+}
+
+// accountErasure counts what erase removed.
+type accountErasure struct {
+	Account    bool `json:"accountDeleted"`
+	Sessions   int  `json:"sessionsDeleted"`
+	Keys       int  `json:"signingKeysDeleted"`
+	Recipients int  `json:"recipientsDeleted"`
+
+	// addrs holds the addresses the deleted sessions were opened from.
+	addrs map[string]bool
+}
+
+// erase deletes the account name with its sessions, signing keys and age
+// recipients, and forgets its failed logins.
+func (a *Accounts) erase(name string) accountErasure {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	e := accountErasure{addrs: make(map[string]bool)}
+	if acc, ok := a.accounts[name]; ok {
+		e.Account, e.Keys, e.Recipients = true, len(acc.keys), len(acc.recipients)
+		delete(a.accounts, name)
+	}
+	for hash, s := range a.sessions {
+		if s.Account == name {
+			delete(a.sessions, hash)
+			e.Sessions++
+			e.addrs[s.IP] = true
+		}
+	}
+	a.throttle.succeeded("account:" + name)
+	return e
+}
+
+func (a *Accounts) exists(name string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, ok := a.accounts[name]
+	return ok
+}
+
+// Middleware attaches the session of each request to its context. A bearer
+// session token that is not valid is rejected; a stale cookie is ignored.
+// Pending sessions are only accepted by the account endpoints.
+//
+// The X-Board-User header cannot name an account: it is dropped from
+// requests without a session of that account, so that clips, exports and
+// erasures keyed by the user cannot be claimed for someone else.
+func (a *Accounts) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user := r.Header.Get("X-Board-User"); user != "" && a.exists(user) {
+			r.Header.Del("X-Board-User")
+		}
+
+		token := sessionToken(r)
+		if token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		s, ok := a.session(token, r)
+		if !ok {
+			if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer "+sessionTokenPrefix) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="note-board"`)
+				http.Error(w, "invalid or expired session", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if s.Pending && !strings.HasPrefix(r.URL.Path, "/account/") {
+			http.Error(w, "two-factor enrollment required", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionKey{}, s)))
+	})
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(v); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// requireSession returns the session of r, writing a 401 when there is none
+// or when it is pending and allowPending is false.
+func requireSession(w http.ResponseWriter, r *http.Request, allowPending bool) (*session, bool) {
+	s := sessionFrom(r)
+	if s == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="note-board"`)
+		http.Error(w, "login required", http.StatusUnauthorized)
+		return nil, false
+	}
+	if s.Pending && !allowPending {
+		http.Error(w, "two-factor enrollment required", http.StatusForbidden)
+		return nil, false
+	}
+	return s, true
+}
+
+func requirePost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+// registerHandler creates an account: POST {"name", "org", "password"}.
+func (a *Accounts) registerHandler() http.HandlerFunc {
+	return requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		if !requirePost(w, r) {
+			return
+		}
+
+		var req struct {
+			Name     string `json:"name"`
+			Org      string `json:"org"`
+			Password string `json:"password"`
+		}
+		if !decodeBody(w, r, &req) {
+			return
+		}
+		if err := a.Register(req.Name, req.Org, req.Password); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+}
+
+// loginHandler opens a session: POST {"name", "password", "code"}. The token
+// is returned in the body and set as a cookie.
+func (a *Accounts) loginHandler(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+
+	var req struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+
+	token, s, err := a.Login(req.Name, req.Password, req.Code, r)
+	switch {
+	case writeThrottled(w, err):
+		return
+	case errors.Is(err, errTOTPRequired):
+		writeProblem(w, problem{
+			Type:   "/problems/totp-required",
+			Title:  "Two-factor code required",
+			Status: http.StatusUnauthorized,
+			Detail: "send the current code from the authenticator app, or a recovery code, as \"code\"",
+		})
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  s.Expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"token":              token,
+		"session":            s,
+		"enrollmentRequired": s.Pending,
+	})
+}
+
+func (a *Accounts) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	s, ok := requireSession(w, r, true)
+	if !ok {
+		return
+	}
+
+	a.Revoke(s.Account, s.ID)
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// totpHandler serves /account/totp/enroll, /account/totp/confirm {"code"}
+// and /account/totp/disable {"code"}.
+func (a *Accounts) totpHandler(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	s, ok := requireSession(w, r, true)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if r.URL.Path != "/account/totp/enroll" && !decodeBody(w, r, &req) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch r.URL.Path {
+	case "/account/totp/enroll":
+		secret, err := a.StartEnrollment(s.Account)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"secret": totpEncoding.EncodeToString(secret),
+			"uri":    totpURI(s.Account, secret),
+		})
+
+	case "/account/totp/confirm":
+		codes, err := a.ConfirmEnrollment(s.Account, req.Code)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"recoveryCodes": codes})
+
+	case "/account/totp/disable":
+		err := a.DisableTOTP(s.Account, req.Code)
+		if writeThrottled(w, err) {
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+var sessionsTemplate = template.Must(template.New("sessions").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>note-board sessions</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; }
+td, th { padding: 0.2em 1em; text-align: left; }
+.current { font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>Sessions of {{.Account}}</h1>
+<table>
+<tr><th>Device</th><th>IP</th><th>Signed in</th><th>Last seen</th><th></th></tr>
+{{range .Sessions}}<tr{{if eq .ID $.Current}} class="current"{{end}}>
+<td>{{if .Device}}{{.Device}}{{else}}(unknown){{end}}</td><td>{{.IP}}</td>
+<td>{{.Created.Format "2006-01-02 15:04"}}</td><td>{{.LastSeen.Format "2006-01-02 15:04"}}</td>
+<td>{{if eq .ID $.Current}}this session{{else}}<form method="post"><input type="hidden" name="revoke" value="{{.ID}}"><button>Revoke</button></form>{{end}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// sessionsHandler lists the sessions of the caller's account as a page, or
+// as JSON with ?format=json. DELETE ?id= revokes one; the page revokes with
+// a form POST of "revoke".
+func (a *Accounts) sessionsHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := requireSession(w, r, false)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		sessions := a.Sessions(s.Account)
+		if r.URL.Query().Get("format") == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(sessions)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := sessionsTemplate.Execute(w, map[string]any{
+			"Account":  s.Account,
+			"Current":  s.ID,
+			"Sessions": sessions,
+		})
+		if err != nil {
+			log.Printf("accounts: render sessions: %v", err)
+		}
+
+	case http.MethodDelete:
+		if !a.Revoke(s.Account, r.URL.Query().Get("id")) {
+			http.Error(w, "session not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodPost:
+		a.Revoke(s.Account, r.FormValue("revoke"))
+		http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func accountsFromEnv() *Accounts {
+	ttl := envDuration("NOTE_BOARD_SESSION_TTL", 30*24*time.Hour)
+
+	var orgs []string
+	if v := os.Getenv("NOTE_BOARD_TOTP_REQUIRED_ORGS"); v != "" {
+		orgs = strings.Split(v, ",")
+	}
+	return NewAccounts(ttl, orgs)
+}
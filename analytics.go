@@ -0,0 +1,396 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io/fs"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type statsBucket struct {
+	Start        time.Time       `json:"start"`
+	ClipsCreated int             `json:"clipsCreated"`
+	Reads        int             `json:"reads"`
+	BytesStored  int64           `json:"bytesStored"`
+	Users        map[string]bool `json:"users"`
+	Namespaces   map[string]int  `json:"namespaces"`
+}
+
+func newStatsBucket(start time.Time) *statsBucket {
+	return &statsBucket{
+		Start:      start,
+		Users:      make(map[string]bool),
+		Namespaces: make(map[string]int),
+	}
+}
+
+// Stats keeps a rolling window of usage buckets. When path is set the
+// window is loaded on start and written back periodically.
+type Stats struct {
+	mu         sync.Mutex
+	bucketSize time.Duration
+	retention  int
+	buckets    []*statsBucket
+	path       string
+}
+
+func NewStats(bucketSize time.Duration, retention int, path string) *Stats {
+	s := &Stats{
+		bucketSize: bucketSize,
+		retention:  retention,
+		path:       path,
+	}
+
+	if path != "" {
+		if err := s.load(); err != nil {
+			log.Printf("stats: could not load %s: %v", path, err)
+		}
+		go s.startPersistRoutine(1 * time.Minute)
+	}
+
+	return s
+}
+
+// current returns the bucket for now, rolling the window forward if needed.
+// Callers must hold s.mu.
+func (s *Stats) current() *statsBucket {
+	start := time.Now().Truncate(s.bucketSize)
+
+	if n := len(s.buckets); n > 0 && s.buckets[n-1].Start.Equal(start) {
+		return s.buckets[n-1]
+	}
+
+	b := newStatsBucket(start)
+	if n := len(s.buckets); n > 0 {
+		b.BytesStored = s.buckets[n-1].BytesStored
+	}
+	s.buckets = append(s.buckets, b)
+
+	if len(s.buckets) > s.retention {
+		s.buckets = s.buckets[len(s.buckets)-s.retention:]
+	}
+
+	return b
+}
+
+func (s *Stats) RecordWrite(user, id string, bytesStored int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.current()
+	b.ClipsCreated++
+	b.BytesStored = bytesStored
+	b.Users[user] = true
+	b.Namespaces[namespaceOf(id)]++
+}
+
+func (s *Stats) RecordRead(user, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.current()
+	b.Reads++
+	b.Users[user] = true
+	b.Namespaces[namespaceOf(id)]++
+}
+
+// References returns the start of every bucket that counts user as active.
+func (s *Stats) References(user string) []time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var refs []time.Time
+	for _, b := range s.buckets {
+		if b.Users[user] {
+			refs = append(refs, b.Start)
+		}
+	}
+	return refs
+}
+
+// Forget replaces user with an anonymous placeholder in every bucket, so the
+// active user counts stay the same, and reports how many buckets changed.
+func (s *Stats) Forget(user string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	anon := anonymousUser()
+	for _, b := range s.buckets {
+		if b.Users[user] {
+			delete(b.Users, user)
+			b.Users[anon] = true
+			n++
+		}
+	}
+	return n
+}
+
+type namespaceCount struct {
+	Namespace string `json:"namespace"`
+	Count     int    `json:"count"`
+}
+
+type statsSummary struct {
+	BucketSize    string           `json:"bucketSize"`
+	Buckets       []statsBucket    `json:"buckets"`
+	ClipsCreated  int              `json:"clipsCreated"`
+	Reads         int              `json:"reads"`
+	BytesStored   int64            `json:"bytesStored"`
+	ActiveUsers   int              `json:"activeUsers"`
+	TopNamespaces []namespaceCount `json:"topNamespaces"`
+}
+
+func (s *Stats) Summary(bytesStored int64) statsSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sum := statsSummary{
+		BucketSize:  s.bucketSize.String(),
+		BytesStored: bytesStored,
+	}
+	users := make(map[string]bool)
+	namespaces := make(map[string]int)
+
+	for _, b := range s.buckets {
+		// The maps of the buckets keep changing once s.mu is released, so
+		// the summary gets copies.
+		c := *b
+		c.Users, c.Namespaces = maps.Clone(b.Users), maps.Clone(b.Namespaces)
+		sum.Buckets = append(sum.Buckets, c)
+		sum.ClipsCreated += b.ClipsCreated
+		sum.Reads += b.Reads
+		for u := range b.Users {
+			users[u] = true
+		}
+		for ns, n := range b.Namespaces {
+			namespaces[ns] += n
+		}
+	}
+	sum.ActiveUsers = len(users)
+
+	for ns, n := range namespaces {
+		sum.TopNamespaces = append(sum.TopNamespaces, namespaceCount{ns, n})
+	}
+	sort.Slice(sum.TopNamespaces, func(i, j int) bool {
+		a, b := sum.TopNamespaces[i], sum.TopNamespaces[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Namespace < b.Namespace
+	})
+	if len(sum.TopNamespaces) > 10 {
+		sum.TopNamespaces = sum.TopNamespaces[:10]
+	}
+
+	return sum
+}
+
+func (s *Stats) load() error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var buckets []*statsBucket
+	if err := json.Unmarshal(data, &buckets); err != nil {
+		return err
+	}
+	for _, b := range buckets {
+		if b.Users == nil {
+			b.Users = make(map[string]bool)
+		}
+		if b.Namespaces == nil {
+			b.Namespaces = make(map[string]int)
+		}
+	}
+
+	s.mu.Lock()
+	s.buckets = buckets
+	if len(s.buckets) > s.retention {
+		s.buckets = s.buckets[len(s.buckets)-s.retention:]
+	}
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *Stats) save() error {
+	s.mu.Lock()
+	data, err := json.Marshal(s.buckets)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *Stats) startPersistRoutine(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := s.save(); err != nil {
+			log.Printf("stats: could not save %s: %v", s.path, err)
+		}
+	}
+}
+
+// namespaceOf returns the part of id before the first "/", which is how
+// clips are grouped on the dashboard.
+func namespaceOf(id string) string {
+	if i := strings.IndexByte(id, '/'); i > 0 {
+		return id[:i]
+	}
+	return ""
+}
+
+// requireAdmin guards the admin pages with NOTE_BOARD_ADMIN_TOKEN. Without
+// a token they are turned off, as they expose and erase every user's data.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	token := os.Getenv("NOTE_BOARD_ADMIN_TOKEN")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.Error(w, "admin routes are disabled; set NOTE_BOARD_ADMIN_TOKEN", http.StatusForbidden)
+			return
+		}
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="note-board admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+type chartBar struct {
+	X, Y, Width, Height int
+	Label               string
+	Value               int64
+}
+
+type chart struct {
+	Title  string
+	Width  int
+	Height int
+	Bars   []chartBar
+}
+
+// newChart lays out a bar chart as SVG rectangles so the dashboard needs no
+// client-side script or external assets.
+func newChart(title string, buckets []statsBucket, value func(statsBucket) int64) chart {
+	c := chart{Title: title, Width: 720, Height: 160}
+	if len(buckets) == 0 {
+		return c
+	}
+
+	var top int64 = 1
+	for _, b := range buckets {
+		top = max(top, value(b))
+	}
+
+	slot := c.Width / len(buckets)
+	for i, b := range buckets {
+		v := value(b)
+		h := int(v * int64(c.Height-20) / top)
+		c.Bars = append(c.Bars, chartBar{
+			X:      i*slot + 1,
+			Y:      c.Height - h,
+			Width:  max(slot-2, 1),
+			Height: h,
+			Label:  b.Start.Format("Jan 2 15:04"),
+			Value:  v,
+		})
+	}
+
+	return c
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>note-board usage</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+.totals { display: flex; gap: 2em; }
+.totals div { font-size: 1.5em; }
+.totals small { display: block; font-size: 0.5em; color: #666; }
+svg { background: #f6f6f6; margin-bottom: 1em; }
+rect { fill: #4a7bd0; }
+table { border-collapse: collapse; }
+td, th { padding: 0.2em 1em; text-align: left; }
+</style>
+</head>
+<body>
+<h1>Usage</h1>
+<p>Last {{len .Summary.Buckets}} buckets of {{.Summary.BucketSize}}.</p>
+<div class="totals">
+<div>{{.Summary.ClipsCreated}}<small>clips created</small></div>
+<div>{{.Summary.Reads}}<small>reads</small></div>
+<div>{{.Summary.BytesStored}}<small>bytes stored</small></div>
+<div>{{.Summary.ActiveUsers}}<small>active users</small></div>
+</div>
+{{range .Charts}}
+<h2>{{.Title}}</h2>
+<svg width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}">
+{{range .Bars}}<rect x="{{.X}}" y="{{.Y}}" width="{{.Width}}" height="{{.Height}}"><title>{{.Label}}: {{.Value}}</title></rect>
+{{end}}</svg>
+{{end}}
+<h2>Top namespaces</h2>
+<table>
+<tr><th>Namespace</th><th>Requests</th></tr>
+{{range .Summary.TopNamespaces}}<tr><td>{{if .Namespace}}{{.Namespace}}{{else}}(none){{end}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func statsHandler(stats *Stats, store *ValueStore) http.HandlerFunc {
+	return requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		summary := stats.Summary(store.Size())
+
+		if r.URL.Query().Get("format") == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(summary)
+			return
+		}
+
+		data := struct {
+			Summary statsSummary
+			Charts  []chart
+		}{
+			Summary: summary,
+			Charts: []chart{
+				newChart("Clips created", summary.Buckets, func(b statsBucket) int64 { return int64(b.ClipsCreated) }),
+				newChart("Reads", summary.Buckets, func(b statsBucket) int64 { return int64(b.Reads) }),
+				newChart("Active users", summary.Buckets, func(b statsBucket) int64 { return int64(len(b.Users)) }),
+				newChart("Bytes stored", summary.Buckets, func(b statsBucket) int64 { return b.BytesStored }),
+			},
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := dashboardTemplate.Execute(w, data); err != nil {
+			log.Printf("stats: render dashboard: %v", err)
+		}
+	})
+}
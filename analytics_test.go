@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStatsSummary(t *testing.T) {
+	s := NewStats(time.Hour, 24, "")
+	s.RecordWrite("alice", "team/a", 10)
+	s.RecordWrite("bob", "team/b", 20)
+	s.RecordRead("alice", "ops/c")
+	s.RecordRead("carol", "d")
+
+	sum := s.Summary(20)
+	if sum.ClipsCreated != 2 || sum.Reads != 2 || sum.ActiveUsers != 3 || sum.BytesStored != 20 {
+		t.Errorf("got %+v", sum)
+	}
+	want := []namespaceCount{{"team", 2}, {"", 1}, {"ops", 1}}
+	if len(sum.TopNamespaces) != len(want) {
+		t.Fatalf("top namespaces %v, want %v", sum.TopNamespaces, want)
+	}
+	for i := range want {
+		if sum.TopNamespaces[i] != want[i] {
+			t.Errorf("top namespace %d: %v, want %v", i, sum.TopNamespaces[i], want[i])
+		}
+	}
+
+	// The summary is a copy: later writes do not change it.
+	s.RecordWrite("dave", "team/e", 30)
+	if n := len(sum.Buckets[0].Users); n != 3 {
+		t.Errorf("summary bucket has %d users after a later write, want 3", n)
+	}
+}
+
+// TestStatsSummaryRace reads summaries while writes go on. Run it with
+// -race.
+func TestStatsSummaryRace(t *testing.T) {
+	s := NewStats(time.Hour, 24, "")
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; ; n++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				user := string(rune('a' + n%26))
+				s.RecordWrite(user, user+"/x", int64(n))
+				s.RecordRead(user, user+"/x")
+			}
+		}()
+	}
+
+	for deadline := time.Now().Add(200 * time.Millisecond); time.Now().Before(deadline); {
+		sum := s.Summary(0)
+		if _, err := json.Marshal(sum); err != nil {
+			t.Fatal(err)
+		}
+		for _, b := range sum.Buckets {
+			_ = len(b.Users)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
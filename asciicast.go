@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Clips of type "asciicast" hold terminal recordings in the asciicast v2
+// format: a JSON header line followed by one [time, code, data] event per
+// line. They are validated on write and labelled with the cast.* metadata
+// of the recording, so that recordings can be selected and indexed like
+// other clips. /play?id= plays them back in the browser.
+
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Duration  float64           `json:"duration"`
+	Title     string            `json:"title"`
+	Command   string            `json:"command"`
+	Env       map[string]string `json:"env"`
+}
+
+// castInfo describes a validated recording.
+type castInfo struct {
+	castHeader
+	// Length is the time of the last event, in seconds.
+	Length float64
+	Events int
+}
+
+func parseCast(value string) (*castInfo, error) {
+	sc := bufio.NewScanner(strings.NewReader(value))
+	sc.Buffer(nil, maxValueSize)
+
+	if !sc.Scan() {
+		return nil, errors.New("asciicast: missing header")
+	}
+	var info castInfo
+	if err := json.Unmarshal(sc.Bytes(), &info.castHeader); err != nil {
+		return nil, errors.New("asciicast: invalid header: " + err.Error())
+	}
+	if info.Version != 2 {
+		return nil, fmt.Errorf("asciicast: unsupported version %d, only v2 is accepted", info.Version)
+	}
+	if info.Width <= 0 || info.Height <= 0 {
+		return nil, errors.New("asciicast: header needs a positive width and height")
+	}
+
+	for line := 2; sc.Scan(); line++ {
+		if len(strings.TrimSpace(sc.Text())) == 0 {
+			continue
+		}
+
+		var event []json.RawMessage
+		if err := json.Unmarshal(sc.Bytes(), &event); err != nil || len(event) != 3 {
+			return nil, fmt.Errorf("asciicast: line %d: an event is [time, code, data]", line)
+		}
+		var t float64
+		var code, data string
+		if json.Unmarshal(event[0], &t) != nil || json.Unmarshal(event[1], &code) != nil || json.Unmarshal(event[2], &data) != nil {
+			return nil, fmt.Errorf("asciicast: line %d: an event is [time, code, data]", line)
+		}
+		if t < info.Length {
+			return nil, fmt.Errorf("asciicast: line %d: event time goes backwards", line)
+		}
+		switch code {
+		case "o", "i", "m":
+		case "r":
+			var w, h int
+			if _, err := fmt.Sscanf(data, "%dx%d", &w, &h); err != nil || w <= 0 || h <= 0 {
+				return nil, fmt.Errorf("asciicast: line %d: resize is COLSxROWS", line)
+			}
+		default:
+			return nil, fmt.Errorf("asciicast: line %d: unknown event code %q", line, code)
+		}
+		info.Length = t
+		info.Events++
+	}
+	if err := sc.Err(); err != nil {
+		return nil, errors.New("asciicast: " + err.Error())
+	}
+	return &info, nil
+}
+
+// castMeta adds the cast.* labels of a recording to meta without replacing
+// labels set by the writer.
+func castMeta(meta map[string]string, info *castInfo) map[string]string {
+	if meta == nil {
+		meta = make(map[string]string)
+	}
+	labels := map[string]string{
+		"cast.width":    strconv.Itoa(info.Width),
+		"cast.height":   strconv.Itoa(info.Height),
+		"cast.duration": strconv.FormatFloat(info.Length, 'f', 1, 64),
+		"cast.events":   strconv.Itoa(info.Events),
+	}
+	if info.Title != "" {
+		labels["cast.title"] = labelValue(info.Title)
+	}
+	if info.Command != "" {
+		labels["cast.command"] = labelValue(info.Command)
+	}
+	for k, v := range labels {
+		if _, set := meta[k]; !set && len(meta) < maxMetaLabels {
+			meta[k] = v
+		}
+	}
+	return meta
+}
+
+// labelValue fits s into a metadata label value.
+func labelValue(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, s)
+	if len(s) > maxMetaValueLen {
+		s = strings.ToValidUTF8(s[:maxMetaValueLen-3], "") + "..."
+	}
+	return s
+}
+
+var playTemplate = template.Must(template.New("play").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} - note-board</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+.info { color: #666; }
+#screen { background: #1d1f21; color: #e0e0e0; font: 14px/1.2 monospace; padding: 0.5em; margin: 0; display: inline-block; white-space: pre; }
+#controls { margin: 0.5em 0; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="info">{{.Width}}×{{.Height}}, {{.Duration}}s{{if .Command}}, <code>{{.Command}}</code>{{end}}</p>
+<div id="controls">
+<button id="play">Play</button>
+<button id="restart">Restart</button>
+<label>Speed <select id="speed"><option>0.5</option><option selected>1</option><option>2</option><option>4</option></select></label>
+<span id="clock">0.0s</span>
+</div>
+<pre id="screen"></pre>
+<script>
+const lines = {{.Cast}}.split("\n").filter((l) => l.trim() !== "");
+const header = JSON.parse(lines[0]);
+const events = lines.slice(1).map((l) => JSON.parse(l)).filter((e) => e[1] === "o" || e[1] === "r");
+
+// A small terminal: printable text, CR, LF, BS, TAB and the CSI sequences
+// for cursor movement and erasing. Colors and other sequences are ignored.
+class Terminal {
+  constructor(cols, rows) { this.x = 0; this.y = 0; this.pending = ""; this.resize(cols, rows); }
+  resize(cols, rows) {
+    this.cols = cols; this.rows = rows;
+    this.grid = Array.from({length: rows}, () => Array(cols).fill(" "));
+    this.clamp();
+  }
+  clamp() { this.x = Math.max(0, Math.min(this.cols - 1, this.x)); this.y = Math.max(0, Math.min(this.rows - 1, this.y)); }
+  lineFeed() {
+    if (++this.y >= this.rows) { this.grid.shift(); this.grid.push(Array(this.cols).fill(" ")); this.y = this.rows - 1; }
+  }
+  put(ch) {
+    if (this.x >= this.cols) { this.x = 0; this.lineFeed(); }
+    this.grid[this.y][this.x++] = ch;
+  }
+  erase(row, from, to) { for (let i = from; i < to; i++) this.grid[row][i] = " "; }
+  csi(params, final) {
+    const n = params.split(";").map((p) => parseInt(p.replace(/^\?/, ""), 10));
+    const a = isNaN(n[0]) ? 1 : n[0];
+    switch (final) {
+    case "A": this.y -= a; break;
+    case "B": this.y += a; break;
+    case "C": this.x += a; break;
+    case "D": this.x -= a; break;
+    case "G": this.x = a - 1; break;
+    case "d": this.y = a - 1; break;
+    case "H": case "f": this.y = (n[0] || 1) - 1; this.x = (n[1] || 1) - 1; break;
+    case "J": {
+      const mode = n[0] || 0;
+      if (mode === 0) { this.erase(this.y, this.x, this.cols); for (let r = this.y + 1; r < this.rows; r++) this.erase(r, 0, this.cols); }
+      if (mode === 1) { this.erase(this.y, 0, this.x + 1); for (let r = 0; r < this.y; r++) this.erase(r, 0, this.cols); }
+      if (mode >= 2) for (let r = 0; r < this.rows; r++) this.erase(r, 0, this.cols);
+      break;
+    }
+    case "K": {
+      const mode = n[0] || 0;
+      if (mode === 0) this.erase(this.y, this.x, this.cols);
+      if (mode === 1) this.erase(this.y, 0, this.x + 1);
+      if (mode === 2) this.erase(this.y, 0, this.cols);
+      break;
+    }
+    }
+    this.clamp();
+  }
+  write(data) {
+    let s = this.pending + data;
+    this.pending = "";
+    for (let i = 0; i < s.length; i++) {
+      const ch = s[i];
+      if (ch === "\x1b") {
+        const rest = s.slice(i);
+        let m = /^\x1b\[([0-9;?]*)[ -\/]*([@-~])/.exec(rest);
+        if (m) { this.csi(m[1], m[2]); i += m[0].length - 1; continue; }
+        m = /^\x1b\][^\x07\x1b]*(\x07|\x1b\\)/.exec(rest);
+        if (m) { i += m[0].length - 1; continue; }
+        if (/^\x1b(\[[0-9;?]*[ -\/]*|\][^\x07\x1b]*\x1b?)?$/.test(rest)) { this.pending = rest; return; }
+        i++;
+        continue;
+      }
+      if (ch === "\r") this.x = 0;
+      else if (ch === "\n") this.lineFeed();
+      else if (ch === "\b") this.x = Math.max(0, this.x - 1);
+      else if (ch === "\t") this.x = Math.min(this.cols - 1, (this.x + 8) & ~7);
+      else if (ch >= " ") this.put(ch);
+    }
+  }
+  text() { return this.grid.map((r) => r.join("").trimEnd()).join("\n"); }
+}
+
+const screen = document.getElementById("screen");
+const clock = document.getElementById("clock");
+const playButton = document.getElementById("play");
+let term, next, position, timer = null, startedAt;
+
+function reset() {
+  term = new Terminal(header.width, header.height);
+  next = 0; position = 0;
+  screen.textContent = term.text();
+  clock.textContent = "0.0s";
+}
+
+function speed() { return parseFloat(document.getElementById("speed").value); }
+
+function tick() {
+  position = (performance.now() - startedAt) / 1000 * speed();
+  while (next < events.length && events[next][0] <= position) {
+    const [, code, data] = events[next++];
+    if (code === "o") term.write(data);
+    else { const [c, r] = data.split("x").map(Number); term.resize(c, r); }
+  }
+  screen.textContent = term.text();
+  clock.textContent = position.toFixed(1) + "s";
+  if (next < events.length) timer = requestAnimationFrame(tick);
+  else { timer = null; playButton.textContent = "Play"; }
+}
+
+function play() {
+  if (next >= events.length) reset();
+  startedAt = performance.now() - position * 1000 / speed();
+  playButton.textContent = "Pause";
+  timer = requestAnimationFrame(tick);
+}
+
+function pause() {
+  cancelAnimationFrame(timer);
+  timer = null;
+  playButton.textContent = "Play";
+}
+
+playButton.onclick = () => timer === null ? play() : pause();
+document.getElementById("restart").onclick = () => { pause(); reset(); play(); };
+document.getElementById("speed").onchange = () => { if (timer !== null) { pause(); play(); } };
+reset();
+</script>
+</body>
+</html>
+`))
+
+// playCast serves a page that plays back the asciicast clip ?id=.
+func (b *board) playCast(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := b.clipID(w, r)
+	if !ok {
+		return
+	}
+
+	hc := &HookContext{Op: HookGet, ID: id, User: requestUser(r)}
+	if !runHooksBefore(w, hc, b.idRules) {
+		return
+	}
+	if !b.recheck(w, r, id, hc.ID) {
+		return
+	}
+	id = hc.ID
+
+	e, exists := b.store.Lookup(id)
+	if !exists {
+		http.Error(w, "not found or expired", http.StatusNotFound)
+		return
+	}
+	if !canRead(w, r, e.Value) {
+		return
+	}
+	if e.Value.kind != clipCast {
+		http.Error(w, id+" is not a terminal recording", http.StatusConflict)
+		return
+	}
+	if e.Value.burn {
+		http.Error(w, "burn-after-read recordings cannot be played here", http.StatusConflict)
+		return
+	}
+	info, err := parseCast(e.Value.value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	b.stats.RecordRead(hc.User, id)
+
+	title := info.Title
+	if title == "" {
+		title = id
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = playTemplate.Execute(w, map[string]any{
+		"Title":    title,
+		"Width":    info.Width,
+		"Height":   info.Height,
+		"Duration": strconv.FormatFloat(info.Length, 'f', 1, 64),
+		"Command":  info.Command,
+		"Cast":     e.Value.value,
+	})
+	if err != nil {
+		log.Printf("play: render %s: %v", id, err)
+	}
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Request bins record every request to /bin/{id}/... into the JSON clip
+// bin/{id}, a list of the latest captures that expires like any other clip.
+// /bins/{id} shows the captures and /bins/{id}/events streams new ones as
+// server-sent events.
+
+const binPrefix = "bin/"
+
+// maxCaptureBody bounds the body kept of each captured request.
+const maxCaptureBody = 64 << 10
+
+type binCapture struct {
+	Seq     uint64      `json:"seq"`
+	Time    time.Time   `json:"time"`
+	IP      string      `json:"ip"`
+	Method  string      `json:"method"`
+	Path    string      `json:"path"`
+	Query   url.Values  `json:"query,omitempty"`
+	Headers http.Header `json:"headers"`
+	Body    string      `json:"body,omitempty"`
+	// BodyEncoding is "base64" for bodies that are not UTF-8 text.
+	BodyEncoding string `json:"bodyEncoding,omitempty"`
+	Size         int64  `json:"size"`
+	Truncated    bool   `json:"truncated,omitempty"`
+}
+
+// binHub fans new captures out to the event streams watching each bin.
+type binHub struct {
+	size int
+
+	mu   sync.Mutex
+	subs map[string]map[chan binCapture]struct{}
+}
+
+func newBinHub(size int) *binHub {
+	return &binHub{size: size, subs: make(map[string]map[chan binCapture]struct{})}
+}
+
+func (h *binHub) subscribe(id string) chan binCapture {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan binCapture, 16)
+	if h.subs[id] == nil {
+		h.subs[id] = make(map[chan binCapture]struct{})
+	}
+	h.subs[id][ch] = struct{}{}
+	return ch
+}
+
+func (h *binHub) unsubscribe(id string, ch chan binCapture) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.subs[id], ch)
+	if len(h.subs[id]) == 0 {
+		delete(h.subs, id)
+	}
+}
+
+// publish sends c to the streams of bin id. Streams that fall behind miss
+// captures rather than holding up the request.
+func (h *binHub) publish(id string, c binCapture) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subs[id] {
+		select {
+		case ch <- c:
+		default:
+		}
+	}
+}
+
+var errNotBin = errors.New("clip is not a request bin")
+
+func decodeCaptures(val storedValue) ([]binCapture, error) {
+	var captures []binCapture
+	if val.kind != clipJSON || json.Unmarshal([]byte(val.value), &captures) != nil {
+		return nil, errNotBin
+	}
+	return captures, nil
+}
+
+// binID returns the normalized clip id of the bin named in the path.
+func (b *board) binID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id, err := b.idRules.Normalize(binPrefix + r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return id, b.reachable(w, r, id)
+}
+
+// captureRequest records r in its bin. Captures are authorized as anonymous
+// since the credentials of third-party senders mean nothing to the board.
+func (b *board) captureRequest(w http.ResponseWriter, r *http.Request) {
+	id, ok := b.binID(w, r)
+	if !ok {
+		return
+	}
+	ip := clientIP(r)
+	if !b.authorizeAs(w, PolicySubject{Name: ip}, "capture", id) {
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxCaptureBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rest, _ := io.Copy(io.Discard, r.Body)
+
+	c := binCapture{
+		Time:      time.Now(),
+		IP:        ip,
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Query:     r.URL.Query(),
+		Headers:   captureHeaders(r),
+		Size:      int64(len(body)) + rest,
+		Truncated: rest > 0,
+	}
+	if utf8.Valid(body) {
+		c.Body = string(body)
+	} else {
+		c.Body, c.BodyEncoding = base64.StdEncoding.EncodeToString(body), "base64"
+	}
+
+	err = b.store.Update(id, func(cur storedValue, exists bool) (storedValue, error) {
+		var captures []binCapture
+		if exists {
+			var err error
+			if captures, err = decodeCaptures(cur); err != nil {
+				return cur, err
+			}
+		} else {
+			cur = storedValue{kind: clipJSON}
+		}
+
+		c.Seq = 1
+		if n := len(captures); n > 0 {
+			c.Seq = captures[n-1].Seq + 1
+		}
+		captures = append(captures, c)
+		if len(captures) > b.bins.size {
+			captures = captures[len(captures)-b.bins.size:]
+		}
+
+		data, err := json.Marshal(captures)
+		for err == nil && len(data) > maxValueSize && len(captures) > 1 {
+			captures = captures[1:]
+			data, err = json.Marshal(captures)
+		}
+		if err != nil {
+			return cur, err
+		}
+		if err := b.schemas.validateUpdate(id, string(data)); err != nil {
+			return cur, err
+		}
+		cur.value, cur.sig = string(data), nil
+		return cur, nil
+	})
+	var invalid *schemaError
+	if errors.As(err, &invalid) {
+		writeSchemaProblem(w, id, invalid.violations)
+		return
+	}
+	if errors.Is(err, errNotBin) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	b.bins.publish(id, c)
+	b.stats.RecordWrite(ip, id, b.store.Size())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"id": id, "seq": c.Seq})
+}
+
+// redactedHeaders carry credentials, for the board or for whatever the
+// sender meant to reach: the admin token and session tokens in
+// Authorization, and the X-Board-User name the policy trusts.
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "X-Board-User"}
+
+const redacted = "[redacted]"
+
+// captureHeaders returns the headers of r with the values of credentials
+// masked, since they would otherwise be readable by anyone with access to
+// the bin. The authorization scheme and cookie names are kept.
+func captureHeaders(r *http.Request) http.Header {
+	h := r.Header.Clone()
+	for _, key := range redactedHeaders {
+		for i, v := range h.Values(key) {
+			if scheme, _, ok := strings.Cut(v, " "); ok && key != "X-Board-User" {
+				h[key][i] = scheme + " " + redacted
+			} else {
+				h[key][i] = redacted
+			}
+		}
+	}
+
+	var cookies []string
+	for _, c := range r.Cookies() {
+		cookies = append(cookies, c.Name+"="+redacted)
+	}
+	h.Del("Cookie")
+	if len(cookies) > 0 {
+		h.Set("Cookie", strings.Join(cookies, "; "))
+	}
+	return h
+}
+
+// exportedCapture is a capture from one of the addresses of a privacy
+// export, with the bin it was caught in.
+type exportedCapture struct {
+	Bin string `json:"bin"`
+	binCapture
+}
+
+// capturesFrom returns the captures of requests sent from any of ips.
+func capturesFrom(snap *clipSnapshot, ips map[string]bool) []exportedCapture {
+	found := []exportedCapture{}
+	snap.Range(func(e clipEntry) bool {
+		if !strings.HasPrefix(e.Key, binPrefix) {
+			return true
+		}
+		captures, err := decodeCaptures(e.Value)
+		if err != nil {
+			return true
+		}
+		for _, c := range captures {
+			if ips[c.IP] {
+				found = append(found, exportedCapture{Bin: e.Key, binCapture: c})
+			}
+		}
+		return true
+	})
+	return found
+}
+
+// eraseCaptures removes the captures of requests sent from any of ips from
+// every bin, along with the earlier values of the bins that held them, and
+// returns how many were removed.
+func eraseCaptures(vs *ValueStore, ips map[string]bool) int {
+	var bins []string
+	vs.Range(func(e clipEntry) bool {
+		if strings.HasPrefix(e.Key, binPrefix) {
+			bins = append(bins, e.Key)
+		}
+		return true
+	})
+
+	erased := 0
+	for _, id := range bins {
+		n := 0
+		err := vs.Update(id, func(cur storedValue, exists bool) (storedValue, error) {
+			captures, err := decodeCaptures(cur)
+			if !exists || err != nil {
+				return cur, errNotBin
+			}
+			kept := captures[:0]
+			for _, c := range captures {
+				if !ips[c.IP] {
+					kept = append(kept, c)
+				}
+			}
+			if n = len(captures) - len(kept); n == 0 {
+				return cur, errNotBin
+			}
+			data, err := json.Marshal(kept)
+			if err != nil {
+				return cur, err
+			}
+			cur.value, cur.sig = string(data), nil
+			return cur, nil
+		})
+		if err == nil {
+			vs.history.drop(id)
+			erased += n
+		}
+	}
+	return erased
+}
+
+// binCaptures returns the captures of bin id, writing the error response
+// when r may not read them. A bin that has not caught anything yet is
+// empty.
+func (b *board) binCaptures(w http.ResponseWriter, r *http.Request, id string) ([]binCapture, bool) {
+	if !b.authorize(w, r, id) {
+		return nil, false
+	}
+	e, exists := b.store.Lookup(id)
+	if !exists {
+		return []binCapture{}, true
+	}
+	if !canRead(w, r, e.Value) {
+		return nil, false
+	}
+	captures, err := decodeCaptures(e.Value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return nil, false
+	}
+	return captures, true
+}
+
+var binTemplate = template.Must(template.New("bin").Funcs(template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.MarshalIndent(v, "", "  ")
+		return string(data), err
+	},
+}).Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>note-board bin {{.Name}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+.capture { border-top: 1px solid #ccc; padding: 0.5em 0; }
+.line { font-weight: bold; font-family: monospace; }
+.when { color: #666; }
+pre { background: #f6f6f6; padding: 0.5em; overflow-x: auto; }
+</style>
+</head>
+<body>
+<h1>Request bin {{.Name}}</h1>
+<p>Send requests to <code>{{.URL}}</code>. New captures appear below as they arrive.</p>
+<div id="captures">
+{{range .Captures}}<div class="capture" data-seq="{{.Seq}}">
+<div class="line">#{{.Seq}} {{.Method}} {{.Path}}</div>
+<div class="when">{{.Time.Format "2006-01-02 15:04:05"}} from {{.IP}}, {{.Size}} bytes{{if .Truncated}} (truncated){{end}}</div>
+<pre>{{json .Headers}}</pre>
+{{if .Body}}<pre>{{.Body}}</pre>{{end}}
+</div>
+{{else}}<p id="empty">Nothing captured yet.</p>
+{{end}}</div>
+<script>
+const list = document.getElementById("captures");
+const events = new EventSource({{.Events}});
+events.addEventListener("capture", (ev) => {
+  const c = JSON.parse(ev.data);
+  if (list.querySelector('[data-seq="' + c.seq + '"]')) return;
+  document.getElementById("empty")?.remove();
+  const div = document.createElement("div");
+  div.className = "capture";
+  div.dataset.seq = c.seq;
+  const add = (tag, cls, text) => {
+    const el = document.createElement(tag);
+    if (cls) el.className = cls;
+    el.textContent = text;
+    div.appendChild(el);
+  };
+  add("div", "line", "#" + c.seq + " " + c.method + " " + c.path);
+  add("div", "when", new Date(c.time).toLocaleString() + " from " + c.ip + ", " + c.size + " bytes" + (c.truncated ? " (truncated)" : ""));
+  add("pre", "", JSON.stringify(c.headers, null, 2));
+  if (c.body) add("pre", "", c.body);
+  list.prepend(div);
+});
+</script>
+</body>
+</html>
+`))
+
+// inspectBin shows the captures of a bin, newest first, as a page that
+// follows new captures, or as JSON with ?format=json.
+func (b *board) inspectBin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := b.binID(w, r)
+	if !ok {
+		return
+	}
+	captures, ok := b.binCaptures(w, r, id)
+	if !ok {
+		return
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(captures)
+		return
+	}
+
+	newest := make([]binCapture, len(captures))
+	for i, c := range captures {
+		newest[len(captures)-1-i] = c
+	}
+	name := strings.TrimPrefix(id, binPrefix)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := binTemplate.Execute(w, map[string]any{
+		"Name":     name,
+		"URL":      "/bin/" + name,
+		"Events":   "/bins/" + name + "/events",
+		"Captures": newest,
+	})
+	if err != nil {
+		log.Printf("bins: render %s: %v", id, err)
+	}
+}
+
+// binEvents streams the captures of a bin as server-sent "capture" events
+// whose ids are the capture sequence numbers. A client reconnecting with
+// Last-Event-ID first receives the captures it missed.
+func (b *board) binEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := b.binID(w, r)
+	if !ok {
+		return
+	}
+
+	// Subscribe before reading the stored captures so that none are lost
+	// in between; the sequence numbers weed out duplicates.
+	ch := b.bins.subscribe(id)
+	defer b.bins.unsubscribe(id, ch)
+
+	captures, ok := b.binCaptures(w, r, id)
+	if !ok {
+		return
+	}
+
+	var last uint64
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		last, _ = strconv.ParseUint(v, 10, 64)
+	} else if n := len(captures); n > 0 {
+		last = captures[n-1].Seq
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(c binCapture) error {
+		if c.Seq <= last {
+			return nil
+		}
+		last = c.Seq
+		data, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: capture\ndata: %s\n\n", c.Seq, data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	for _, c := range captures {
+		if send(c) != nil {
+			return
+		}
+	}
+	rc.Flush()
+
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case c := <-ch:
+			if send(c) != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
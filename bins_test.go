@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCaptureRedactsCredentials(t *testing.T) {
+	b, h := newTestBoard(t)
+
+	w := serve(h, "POST", "/bin/hooks", "ping", http.Header{
+		"Authorization":       {"Bearer admin-secret"},
+		"Proxy-Authorization": {"Basic dXNlcjpwYXNz"},
+		"X-Board-User":        {"alice"},
+		"Cookie":              {"nb_session=nbs_abc; theme=dark"},
+		"X-Request-Id":        {"42"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("capture: %d %s", w.Code, w.Body)
+	}
+
+	e, _ := b.store.Lookup("bin/hooks")
+	captures, err := decodeCaptures(e.Value)
+	if err != nil || len(captures) != 1 {
+		t.Fatalf("captures = %+v, %v", captures, err)
+	}
+	got := captures[0].Headers
+	for key, want := range map[string]string{
+		"Authorization":       "Bearer [redacted]",
+		"Proxy-Authorization": "Basic [redacted]",
+		"X-Board-User":        "[redacted]",
+		"Cookie":              "nb_session=[redacted]; theme=[redacted]",
+		"X-Request-Id":        "42",
+	} {
+		if got.Get(key) != want {
+			t.Errorf("%s = %q, want %q", key, got.Get(key), want)
+		}
+	}
+}
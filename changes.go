@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The change feed publishes every write, extension, deletion and expiry of
+// a clip. /changes?prefix= streams it as server-sent events so that clients
+// can keep a listing up to date without polling. A client that reconnects
+// with Last-Event-ID gets the changes it missed, or a "reset" event when
+// they are no longer kept and it must list the clips again.
+
+// changeBacklog is the number of recent changes kept for reconnects.
+const changeBacklog = 1024
+
+type clipChange struct {
+	Seq uint64 `json:"seq"`
+	// Op is set, extend, delete or expire.
+	Op      string    `json:"op"`
+	ID      string    `json:"id"`
+	Type    string    `json:"type,omitempty"`
+	Version uint64    `json:"version,omitempty"`
+	Size    int       `json:"size,omitempty"`
+	Time    time.Time `json:"time"`
+
+	// meta and readers are those of the clip, to decide who may see the
+	// change.
+	meta    map[string]string
+	readers []string
+}
+
+type changeFeed struct {
+	mu     sync.Mutex
+	seq    uint64
+	recent []clipChange
+	subs   map[chan clipChange]struct{}
+}
+
+func newChangeFeed() *changeFeed {
+	return &changeFeed{subs: make(map[chan clipChange]struct{})}
+}
+
+func (f *changeFeed) publish(c clipChange) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.seq++
+	c.Seq = f.seq
+	c.Time = time.Now().UTC()
+	if len(f.recent) == changeBacklog {
+		f.recent = append(f.recent[:0], f.recent[1:]...)
+	}
+	f.recent = append(f.recent, c)
+
+	// A stream that falls behind is ended rather than left with a gap; its
+	// client reconnects and catches up from the backlog.
+	for ch := range f.subs {
+		select {
+		case ch <- c:
+		default:
+			delete(f.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe returns a channel of the changes after seq, starting with those
+// in the backlog. ok is false when changes after seq were already dropped
+// from the backlog, or seq is from before the server restarted; the channel
+// then starts after the latest change, returned as start.
+func (f *changeFeed) subscribe(seq uint64) (ch chan clipChange, backlog []clipChange, start uint64, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ch = make(chan clipChange, 64)
+	f.subs[ch] = struct{}{}
+	switch {
+	case seq == f.seq:
+		return ch, nil, seq, true
+	case seq > f.seq || len(f.recent) == 0 || f.recent[0].Seq > seq+1:
+		return ch, nil, f.seq, false
+	}
+	i := int(seq + 1 - f.recent[0].Seq)
+	return ch, append([]clipChange(nil), f.recent[i:]...), seq, true
+}
+
+func (f *changeFeed) unsubscribe(ch chan clipChange) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.subs[ch]; ok {
+		delete(f.subs, ch)
+		close(ch)
+	}
+}
+
+func (f *changeFeed) last() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.seq
+}
+
+// streamChanges streams the changes to the clips below ?prefix=, normalized
+// like ids, that the client may read. The policy is checked against the clip
+// as it was written, deleted or expired.
+func (b *board) streamChanges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	prefix := b.idRules.Prefix(r.URL.Query().Get("prefix"))
+	readable, ok := b.readable(w, r)
+	if !ok {
+		return
+	}
+
+	from := b.store.changes.last()
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		seq, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		from = seq
+	}
+	ch, backlog, from, ok := b.store.changes.subscribe(from)
+	defer b.store.changes.unsubscribe(ch)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	if !ok {
+		fmt.Fprintf(w, "id: %d\nevent: reset\ndata: {}\n\n", from)
+	}
+	// The first event tells the client where the stream starts.
+	fmt.Fprintf(w, "id: %d\nevent: ready\ndata: {}\n\n", from)
+
+	send := func(c clipChange) error {
+		if !strings.HasPrefix(c.ID, prefix) || !readable(c.ID, c.Type, c.meta, c.readers) {
+			return nil
+		}
+		data, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: change\ndata: %s\n\n", c.Seq, data)
+		return err
+	}
+
+	for _, c := range backlog {
+		if send(c) != nil {
+			return
+		}
+	}
+	if rc.Flush() != nil {
+		return
+	}
+
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case c, open := <-ch:
+			if !open || send(c) != nil || rc.Flush() != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,733 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"note-board/internal/dotenv"
+)
+
+const (
+	clipText = "text"
+	clipJSON = "json"
+	// clipAge values are ASCII-armored age files encrypted by the client.
+	clipAge = "age"
+	// clipMock values define the responses of a mock endpoint.
+	clipMock = "mock"
+	// clipEnv values are environment variable sets in the .env format.
+	clipEnv = "env"
+	// clipLog values only grow, by appends.
+	clipLog = "log"
+	// clipCast values are asciicast v2 terminal recordings.
+	clipCast = "asciicast"
+)
+
+const ageArmorHeader = "-----BEGIN AGE ENCRYPTED FILE-----"
+
+const maxValueSize = 1 << 20
+
+// board serves the clip endpoints on "/".
+type board struct {
+	store    *ValueStore
+	stats    *Stats
+	scripts  *ScriptEngine
+	schemas  *SchemaRegistry
+	idRules  IDRules
+	policy   *PolicyEngine
+	ipAccess *IPAccess
+	accounts *Accounts
+	bins     *binHub
+	mocks    *mockCalls
+	logs     *logHub
+	idem     *idempotency
+}
+
+func (b *board) handleClip(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		b.getClip(w, r)
+	case http.MethodPost:
+		if r.URL.Query().Has("append") {
+			b.idem.once(w, r, b.appendClip)
+			return
+		}
+		if r.URL.Query().Has("extend") {
+			b.idem.once(w, r, b.extendClip)
+			return
+		}
+		b.idem.once(w, r, b.setClip)
+	case http.MethodPatch:
+		b.idem.once(w, r, b.patchClip)
+	case http.MethodDelete:
+		b.idem.once(w, r, b.deleteClip)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST, PATCH, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// clipID returns the normalized ?id= of r, writing the error response when
+// it is missing, invalid or the policy does not allow the request.
+func (b *board) clipID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "missing ?id parameter", http.StatusBadRequest)
+		return "", false
+	}
+
+	id, err := b.idRules.Normalize(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	if !b.authorize(w, r, id) {
+		return "", false
+	}
+	return id, true
+}
+
+func (b *board) getClip(w http.ResponseWriter, r *http.Request) {
+	id, ok := b.clipID(w, r)
+	if !ok {
+		return
+	}
+
+	hc := &HookContext{Op: HookGet, ID: id, User: requestUser(r)}
+	if !runHooksBefore(w, hc, b.idRules) {
+		return
+	}
+	if !b.recheck(w, r, id, hc.ID) {
+		return
+	}
+	id = hc.ID
+
+	e, exists := b.store.Lookup(id)
+	if !exists {
+		http.Error(w, "not found or expired", http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Clipboard not found",
+		})
+		return
+	}
+	if !canRead(w, r, e.Value) {
+		return
+	}
+	follow, err := queryBool(r, "follow")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if follow {
+		if e.Value.kind != clipLog {
+			http.Error(w, "only log clips can be followed", http.StatusBadRequest)
+			return
+		}
+		b.stats.RecordRead(hc.User, id)
+		b.followLog(w, r, id)
+		return
+	}
+
+	hc.Value = e.Value.value
+	runAfterHooks(hc)
+	writeAnnotations(w, hc)
+	writeMetaHeaders(w, e.Value.meta)
+	w.Header().Set("X-Board-Type", e.Value.kind)
+	w.Header().Set("ETag", etag(e.Version))
+	sig := b.writeSignature(w, id, e.Value)
+
+	resp := map[string]any{"id": id, "type": e.Value.kind, "value": hc.Value, "signature": sig, "version": e.Version}
+	if len(e.Value.meta) > 0 {
+		resp["meta"] = e.Value.meta
+	}
+	if len(e.Value.recipients) > 0 {
+		resp["recipients"] = e.Value.recipients
+	}
+	if len(e.Value.readers) > 0 {
+		resp["readers"] = e.Value.readers
+	}
+	if e.Value.kind == clipLog {
+		resp["closed"] = e.Value.closed
+	}
+
+	pointer, path := r.URL.Query().Get("pointer"), r.URL.Query().Get("path")
+	if pointer != "" || path != "" {
+		if e.Value.kind != clipJSON {
+			http.Error(w, "`pointer` and `path` only apply to JSON clips", http.StatusBadRequest)
+			return
+		}
+
+		selected, err := selectJSON(hc.Value, pointer, path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		resp["value"] = selected
+	}
+
+	if r.Method == http.MethodGet {
+		// Of concurrent reads of a burn-after-read clip only the one that
+		// deletes it gets the value.
+		if e.Value.burn {
+			if !b.store.Delete(id) {
+				http.Error(w, "not found or expired", http.StatusNotFound)
+				return
+			}
+			b.scripts.Notify("delete", id, "", hc.User)
+			resp["burned"] = true
+		}
+		b.stats.RecordRead(hc.User, id)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
+
+// canRead reports whether the caller may read a clip with readers, writing
+// the error response when not. Readers must be logged in.
+func canRead(w http.ResponseWriter, r *http.Request, val storedValue) bool {
+	if len(val.readers) == 0 {
+		return true
+	}
+	user, ok := sessionUser(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="note-board"`)
+		http.Error(w, "clip is restricted to its readers, log in", http.StatusUnauthorized)
+		return false
+	}
+	if !slices.Contains(val.readers, user) {
+		http.Error(w, "clip is restricted to its readers", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func selectJSON(value, pointer, path string) (any, error) {
+	doc, err := decodeJSON([]byte(value))
+	if err != nil {
+		return nil, err
+	}
+
+	if pointer != "" {
+		tokens, err := parseJSONPointer(pointer)
+		if err != nil {
+			return nil, err
+		}
+		return pointerGet(doc, tokens)
+	}
+	return jsonPathQuery(doc, path)
+}
+
+// clipEnvelopeType is the Content-Type of a write whose body is a JSON object
+// holding the value together with its type and metadata.
+const clipEnvelopeType = "application/vnd.note-board.clip+json"
+
+type clipEnvelope struct {
+	Value      string            `json:"value"`
+	Type       string            `json:"type"`
+	Meta       map[string]string `json:"meta"`
+	Recipients []string          `json:"recipients"`
+	Readers    []string          `json:"readers"`
+	Burn       bool              `json:"burnAfterRead"`
+}
+
+// readClip returns the value, type, metadata, recipients and read rules of a
+// write. They come from ?value=, ?type=, ?burn=, X-Board-Meta-*,
+// X-Board-Recipients and X-Board-Readers headers, with the body used as the
+// value when ?value= is not set, or from a clipEnvelopeType body.
+func readClip(r *http.Request) (clipEnvelope, error) {
+	c := clipEnvelope{
+		Value:      r.URL.Query().Get("value"),
+		Type:       r.URL.Query().Get("type"),
+		Meta:       metaFromHeaders(r.Header),
+		Recipients: headerList(r.Header, "X-Board-Recipients"),
+		Readers:    headerList(r.Header, "X-Board-Readers"),
+	}
+	if v := r.URL.Query().Get("burn"); v != "" {
+		burn, err := strconv.ParseBool(v)
+		if err != nil {
+			return c, errors.New("`burn` must be true or false")
+		}
+		c.Burn = burn
+	}
+
+	if c.Value == "" {
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxValueSize+1))
+		if err != nil {
+			return c, err
+		}
+		if len(data) > maxValueSize {
+			return c, errors.New("value is too large")
+		}
+
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == clipEnvelopeType {
+			var env clipEnvelope
+			if err := json.Unmarshal(data, &env); err != nil {
+				return c, errors.New("invalid clip envelope: " + err.Error())
+			}
+			c.Value = env.Value
+			if env.Type != "" {
+				c.Type = env.Type
+			}
+			c.Recipients = append(c.Recipients, env.Recipients...)
+			c.Readers = append(c.Readers, env.Readers...)
+			c.Burn = c.Burn || env.Burn
+			if len(env.Meta) > 0 {
+				if c.Meta == nil {
+					c.Meta = make(map[string]string)
+				}
+				for k, v := range env.Meta {
+					c.Meta[k] = v
+				}
+			}
+		} else {
+			c.Value = string(data)
+		}
+	}
+
+	if c.Type == "" {
+		c.Type = clipText
+	}
+	if len(c.Recipients) > 0 && c.Type != clipAge {
+		return c, errors.New("recipients only apply to age clips")
+	}
+	return c, checkMeta(c.Meta)
+}
+
+// headerList splits a comma separated header into its non-empty items.
+func headerList(h http.Header, key string) []string {
+	var items []string
+	for _, item := range strings.Split(h.Get(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// checkValue validates a value for the given clip type.
+func checkValue(kind, value string) error {
+	switch kind {
+	case clipText:
+		return nil
+	case clipJSON:
+		if _, err := decodeJSON([]byte(value)); err != nil {
+			return errors.New("value is not valid JSON: " + err.Error())
+		}
+		return nil
+	case clipAge:
+		if !strings.HasPrefix(value, ageArmorHeader) {
+			return errors.New("value is not an ASCII-armored age file")
+		}
+		return nil
+	case clipMock:
+		_, err := parseMock(value)
+		return err
+	case clipLog:
+		return nil
+	case clipCast:
+		_, err := parseCast(value)
+		return err
+	case clipEnv:
+		if _, err := dotenv.Parse(value); err != nil {
+			return errors.New("value is not a valid .env file: " + err.Error())
+		}
+		return nil
+	default:
+		return errors.New("unknown clip type " + kind)
+	}
+}
+
+func (b *board) setClip(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	c, err := readClip(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cond, err := writeCondition(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	val := c.Value
+
+	if id == "" || val == "" {
+		http.Error(w, "`id` and `value` required", http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Sorry something went wrong",
+		})
+		return
+	}
+	id, ok := b.clipID(w, r)
+	if !ok {
+		return
+	}
+
+	hc := &HookContext{Op: HookSet, ID: id, Value: val, User: requestUser(r)}
+	if !runHooksBefore(w, hc, b.idRules) {
+		return
+	}
+	if !b.recheck(w, r, id, hc.ID) {
+		return
+	}
+	id = hc.ID
+
+	val, err = normalizeValue(hc.Value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkValue(c.Type, val); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if v := b.schemas.Validate(id, val); v != nil {
+		writeSchemaProblem(w, id, v)
+		return
+	}
+	if c.Type == clipCast {
+		info, _ := parseCast(val)
+		c.Meta = castMeta(c.Meta, info)
+	}
+
+	ttl := b.store.ttl
+	if v := r.URL.Query().Get("ttl"); v != "" {
+		ttl, err = time.ParseDuration(v)
+		if err != nil || ttl <= 0 {
+			http.Error(w, "`ttl` must be a positive duration such as 30m", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if e, exists := b.store.Lookup(id); exists && e.Value.kind == clipLog {
+		http.Error(w, "log clips are append-only, use ?append=true", http.StatusConflict)
+		return
+	}
+
+	sig, ok := b.readSignature(w, r, id, c.Type, val)
+	if !ok {
+		return
+	}
+
+	version, stored := b.store.PutIf(id, storedValue{
+		value:      val,
+		kind:       c.Type,
+		meta:       c.Meta,
+		owner:      clipOwner(r),
+		sig:        sig,
+		recipients: c.Recipients,
+		readers:    c.Readers,
+		burn:       c.Burn,
+	}, ttl, cond)
+	if !stored {
+		preconditionFailed(w, b.store, id)
+		return
+	}
+	b.clipWritten(w, hc, val, version)
+}
+
+// clipWritten records a successful write and sends the response, with the
+// version of the clip when it is known.
+func (b *board) clipWritten(w http.ResponseWriter, hc *HookContext, val string, version uint64) {
+	b.stats.RecordWrite(hc.User, hc.ID, b.store.Size())
+
+	hc.Value = val
+	runAfterHooks(hc)
+	writeAnnotations(w, hc)
+	b.scripts.Notify("set", hc.ID, val, hc.User)
+
+	resp := map[string]any{
+		"message": "Clip board recorded successfully",
+		"id":      hc.ID,
+		"value":   val,
+	}
+	if version > 0 {
+		w.Header().Set("ETag", etag(version))
+		resp["version"] = version
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func etag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// writeCondition returns the condition the If-Match or If-None-Match header
+// of a write puts on the current version of the clip. If-Match takes "*" or
+// a list of ETags, If-None-Match only "*" for a clip that must not exist.
+func writeCondition(r *http.Request) (func(cur clipEntry, exists bool) bool, error) {
+	if v := r.Header.Get("If-None-Match"); v != "" {
+		if strings.TrimSpace(v) != "*" {
+			return nil, errors.New("If-None-Match only supports * on writes")
+		}
+		return func(_ clipEntry, exists bool) bool { return !exists }, nil
+	}
+
+	v := r.Header.Get("If-Match")
+	if v == "" {
+		return nil, nil
+	}
+	if strings.TrimSpace(v) == "*" {
+		return func(_ clipEntry, exists bool) bool { return exists }, nil
+	}
+	var versions []uint64
+	for _, tag := range strings.Split(v, ",") {
+		tag = strings.TrimSpace(tag)
+		n, err := strconv.ParseUint(strings.Trim(tag, `"`), 10, 64)
+		if err != nil || len(tag) < 3 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			return nil, errors.New("If-Match takes * or clip ETags such as \"42\"")
+		}
+		versions = append(versions, n)
+	}
+	return func(cur clipEntry, exists bool) bool {
+		return exists && slices.Contains(versions, cur.Version)
+	}, nil
+}
+
+// preconditionFailed reports a conditional write that found the clip at
+// another version.
+func preconditionFailed(w http.ResponseWriter, vs *ValueStore, id string) {
+	msg := id + " does not exist"
+	if e, exists := vs.Lookup(id); exists {
+		w.Header().Set("ETag", etag(e.Version))
+		msg = fmt.Sprintf("%s has changed, it is at version %d", id, e.Version)
+	}
+	http.Error(w, msg, http.StatusPreconditionFailed)
+}
+
+var errClipChanged = errors.New("clip changed while it was being patched")
+
+// patchClip updates a JSON clip with an RFC 6902 JSON Patch or an RFC 7396
+// merge patch, chosen by the request Content-Type. The patch is applied to
+// the current value and stored only if the clip has not been written in the
+// meantime, retrying a few times otherwise. Like other writes it honours
+// If-Match and If-None-Match and returns the new version as the ETag.
+func (b *board) patchClip(w http.ResponseWriter, r *http.Request) {
+	id, ok := b.clipID(w, r)
+	if !ok {
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var apply func(doc string, patch []byte) (string, error)
+	switch mediaType {
+	case "application/json-patch+json":
+		apply = applyJSONPatch
+	case "application/merge-patch+json":
+		apply = applyMergePatch
+	default:
+		w.Header().Set("Accept-Patch", "application/json-patch+json, application/merge-patch+json")
+		http.Error(w, "unsupported patch format", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	patch, err := io.ReadAll(io.LimitReader(r.Body, maxValueSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cond, err := writeCondition(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		e, exists := b.store.Lookup(id)
+		if !exists {
+			http.Error(w, "not found or expired", http.StatusNotFound)
+			return
+		}
+		if e.Value.kind != clipJSON {
+			http.Error(w, "only JSON clips can be patched", http.StatusConflict)
+			return
+		}
+		if cond != nil && !cond(e, true) {
+			preconditionFailed(w, b.store, id)
+			return
+		}
+
+		patched, err := apply(e.Value.value, patch)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		hc := &HookContext{Op: HookSet, ID: id, Value: patched, User: requestUser(r)}
+		if !runHooksBefore(w, hc, b.idRules) {
+			return
+		}
+		if hc.ID != id {
+			http.Error(w, "hooks cannot rename a clip that is being patched", http.StatusUnprocessableEntity)
+			return
+		}
+		val, err := normalizeValue(hc.Value)
+		if err == nil {
+			err = checkValue(clipJSON, val)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if v := b.schemas.Validate(id, val); v != nil {
+			writeSchemaProblem(w, id, v)
+			return
+		}
+
+		// The patch only applies to the version it was made against, which
+		// also keeps the clip's expiry. When the clip changed meanwhile it
+		// is patched again, and rechecked against the request's condition.
+		patchedVal := e.Value
+		patchedVal.value = val
+		patchedVal.sig = nil
+		version, stored := b.store.PutIf(id, patchedVal, time.Until(e.Expires), func(cur clipEntry, exists bool) bool {
+			return exists && cur.Version == e.Version
+		})
+		if !stored {
+			continue
+		}
+
+		b.clipWritten(w, hc, val, version)
+		return
+	}
+
+	http.Error(w, errClipChanged.Error(), http.StatusConflict)
+}
+
+func (b *board) deleteClip(w http.ResponseWriter, r *http.Request) {
+	id, ok := b.clipID(w, r)
+	if !ok {
+		return
+	}
+
+	hc := &HookContext{Op: HookDelete, ID: id, User: requestUser(r)}
+	if !runHooksBefore(w, hc, b.idRules) {
+		return
+	}
+	if !b.recheck(w, r, id, hc.ID) {
+		return
+	}
+	id = hc.ID
+
+	if !b.store.Delete(id) {
+		http.Error(w, "not found or expired", http.StatusNotFound)
+		return
+	}
+
+	runAfterHooks(hc)
+	writeAnnotations(w, hc)
+	b.scripts.Notify("delete", id, "", hc.User)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// extendClip makes the clip expire ?extend= from now, keeping its value.
+// It takes If-Match like other writes.
+func (b *board) extendClip(w http.ResponseWriter, r *http.Request) {
+	id, ok := b.clipID(w, r)
+	if !ok {
+		return
+	}
+	ttl, err := time.ParseDuration(r.URL.Query().Get("extend"))
+	if err != nil || ttl <= 0 {
+		http.Error(w, "`extend` must be a positive duration such as 2h", http.StatusBadRequest)
+		return
+	}
+	cond, err := writeCondition(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	e, ok := b.store.Extend(id, ttl, func(cur clipEntry) bool {
+		return cond == nil || cond(cur, true)
+	})
+	if !ok {
+		if _, exists := b.store.Lookup(id); !exists {
+			http.Error(w, "not found or expired", http.StatusNotFound)
+			return
+		}
+		preconditionFailed(w, b.store, id)
+		return
+	}
+
+	w.Header().Set("ETag", etag(e.Version))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"id":      id,
+		"version": e.Version,
+		"expires": e.Expires,
+	})
+}
+
+type clipSummary struct {
+	ID      string            `json:"id"`
+	Type    string            `json:"type"`
+	Size    int               `json:"size"`
+	Meta    map[string]string `json:"meta,omitempty"`
+	Readers []string          `json:"readers,omitempty"`
+	Burn    bool              `json:"burnAfterRead,omitempty"`
+	Version uint64            `json:"version"`
+	Expires time.Time         `json:"expires"`
+}
+
+// listClips lists the clips whose id starts with ?prefix=, normalized like
+// ids, and whose metadata matches the label ?selector=, leaving out those
+// the client may not read. The listing is read from a snapshot whose
+// sequence is returned in X-Board-Sequence.
+func (b *board) listClips(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	prefix := b.idRules.Prefix(r.URL.Query().Get("prefix"))
+	sel, err := parseLabelSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	readable, ok := b.readable(w, r)
+	if !ok {
+		return
+	}
+
+	snap := b.store.Snapshot()
+	defer snap.Close()
+
+	clips := []clipSummary{}
+	snap.Range(func(e clipEntry) bool {
+		if strings.HasPrefix(e.Key, prefix) && sel.Matches(e.Value.meta) &&
+			readable(e.Key, e.Value.kind, e.Value.meta, e.Value.readers) {
+			clips = append(clips, clipSummary{
+				ID:      e.Key,
+				Type:    e.Value.kind,
+				Size:    len(e.Value.value),
+				Meta:    e.Value.meta,
+				Readers: e.Value.readers,
+				Burn:    e.Value.burn,
+				Version: e.Version,
+				Expires: e.Expires,
+			})
+		}
+		return true
+	})
+	sort.Slice(clips, func(i, j int) bool { return clips[i].ID < clips[j].ID })
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Board-Sequence", strconv.FormatUint(snap.Seq(), 10))
+	json.NewEncoder(w).Encode(clips)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestListClipsNormalizesPrefix(t *testing.T) {
+	b, h := newTestBoard(t)
+	b.idRules.CaseFold = true
+	b.store.Put("notes/café/a", storedValue{kind: clipText, value: "x"}, time.Hour)
+	b.store.Put("notes/other", storedValue{kind: clipText, value: "x"}, time.Hour)
+
+	// An upper case, decomposed prefix.
+	w := serve(h, "GET", "/clips?prefix=NOTES/CAFE%CC%81", "", nil)
+	var clips []clipSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &clips); err != nil {
+		t.Fatalf("%d %s: %v", w.Code, w.Body, err)
+	}
+	if len(clips) != 1 || clips[0].ID != "notes/café/a" {
+		t.Errorf("clips = %+v, want notes/café/a", clips)
+	}
+}
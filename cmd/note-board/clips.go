@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"note-board/internal/provenance"
+)
+
+const clipEnvelopeType = "application/vnd.note-board.clip+json"
+
+type clip struct {
+	ID         string            `json:"id"`
+	Type       string            `json:"type"`
+	Value      string            `json:"value"`
+	Meta       map[string]string `json:"meta"`
+	Recipients []string          `json:"recipients"`
+	Version    uint64            `json:"version"`
+	Signature  struct {
+		Status    string `json:"status"`
+		Signer    string `json:"signer"`
+		Key       string `json:"key"`
+		Signature string `json:"signature"`
+	} `json:"signature"`
+}
+
+func runPush(c *client, args []string) error {
+	fs := flag.NewFlagSet("push", flag.ExitOnError)
+	kind := fs.String("type", "text", "clip type: text, json, env, mock or asciicast")
+	ttl := fs.String("ttl", "", "time to live, such as 30m")
+	noSign := fs.Bool("no-sign", false, "do not sign the clip")
+	burn := fs.Bool("burn", false, "delete the clip when it is first read")
+	meta := metaFlag{}
+	fs.Var(meta, "meta", "metadata label `key=value`, repeatable")
+	var to, readers stringsFlag
+	fs.Var(&to, "to", "encrypt to the keys of `user`, repeatable")
+	fs.Var(&readers, "reader", "only let `user` read the clip, repeatable")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		return errors.New("missing clip id")
+	}
+	id := fs.Arg(0)
+	value, err := readInput(fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+
+	opts := pushOptions{meta: meta, readers: readers, ttl: *ttl, burn: *burn, sign: !*noSign}
+	if len(to) > 0 {
+		recipients, err := c.recipientsOf(to)
+		if err != nil {
+			return err
+		}
+		armored, err := encrypt(string(value), recipients)
+		if err != nil {
+			return err
+		}
+		opts.recipients = to
+		return c.push(id, "age", armored, opts)
+	}
+	return c.push(id, *kind, string(value), opts)
+}
+
+type pushOptions struct {
+	meta map[string]string
+	// recipients lists the users an age clip is encrypted to.
+	recipients []string
+	readers    []string
+	ttl        string
+	burn       bool
+	sign       bool
+}
+
+// push stores a clip, signing it with the local key when opts.sign is set
+// and a key exists. When the server cannot be reached the push is queued,
+// to be sent by the next push or by queue push.
+func (c *client) push(id, kind, value string, opts pushOptions) error {
+	p := &queuedPush{
+		Key:        newIdempotencyKey(),
+		Server:     c.base,
+		ID:         id,
+		Kind:       kind,
+		Value:      value,
+		Meta:       opts.meta,
+		Recipients: opts.recipients,
+		Readers:    opts.readers,
+		TTL:        opts.ttl,
+		Burn:       opts.burn,
+	}
+
+	signed := ""
+	if opts.sign {
+		key, err := loadKey()
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			fmt.Fprintln(os.Stderr, "note-board: no signing key, pushing unsigned (see note-board keygen)")
+		case err != nil:
+			return err
+		default:
+			// The clip is signed when it is sent, once the server has
+			// said which id it will be stored under.
+			p.SigningKey = provenance.Fingerprint(key.Public().(ed25519.PublicKey))
+			signed = " signed with " + p.SigningKey
+		}
+	}
+
+	q, err := loadQueue()
+	if err != nil {
+		return err
+	}
+	// Pushes queued earlier go first, so that the clip ends up with the
+	// latest value.
+	if q.pending(c.base) > 0 {
+		if err := c.replay(q, false); err != nil {
+			return err
+		}
+	}
+	reason := "earlier pushes are still queued"
+	if q.pending(c.base) == 0 {
+		reason = c.base + " is unreachable"
+		version, err := c.send(p)
+		if !unreachable(err) {
+			if err != nil {
+				return err
+			}
+			q.setVersion(c.base, id, version)
+			if err := q.save(); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "stored %s%s\n", id, signed)
+			return nil
+		}
+	}
+
+	p.Base = q.version(c.base, id)
+	p.Status = statusPending
+	p.Queued = time.Now().UTC()
+	q.Entries = append(q.Entries, p)
+	if err := q.save(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s, queued %s%s as %s\n", reason, id, signed, p.Key)
+	return nil
+}
+
+// send makes the request of a push and returns the version of the stored
+// clip. Queued pushes that know the version they were based on only apply
+// to that version.
+func (c *client) send(p *queuedPush) (uint64, error) {
+	body, err := json.Marshal(map[string]any{
+		"value":         p.Value,
+		"type":          p.Kind,
+		"meta":          p.Meta,
+		"recipients":    p.Recipients,
+		"readers":       p.Readers,
+		"burnAfterRead": p.Burn,
+	})
+	if err != nil {
+		return 0, err
+	}
+	header := http.Header{
+		"Content-Type":    {clipEnvelopeType},
+		"Idempotency-Key": {p.Key},
+	}
+	if p.SigningKey != "" {
+		sig, err := c.sign(p)
+		if err != nil {
+			return 0, err
+		}
+		header.Set(provenance.HeaderSignature, sig)
+		header.Set(provenance.HeaderKey, p.SigningKey)
+	}
+	if p.Base > 0 {
+		header.Set("If-Match", fmt.Sprintf("%q", strconv.FormatUint(p.Base, 10)))
+	}
+
+	query := url.Values{"id": {p.ID}}
+	if p.TTL != "" {
+		query.Set("ttl", p.TTL)
+	}
+	resp, err := c.request(http.MethodPost, "/", query, bytes.NewReader(body), header)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var stored struct {
+		Version uint64 `json:"version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&stored); err != nil {
+		return 0, fmt.Errorf("push %s: reading the response: %w", p.ID, err)
+	}
+	return stored.Version, nil
+}
+
+// sign signs a push with the local key. Signatures cover the id the clip is
+// stored under, which the server normalizes, so sign asks the server for it
+// rather than signing the id as typed.
+func (c *client) sign(p *queuedPush) (string, error) {
+	key, err := loadKey()
+	if err != nil {
+		return "", err
+	}
+	if fp := provenance.Fingerprint(key.Public().(ed25519.PublicKey)); fp != p.SigningKey {
+		return "", fmt.Errorf("push %s was to be signed with %s, but the local key is now %s", p.Key, p.SigningKey, fp)
+	}
+
+	var canonical struct {
+		ID string `json:"id"`
+	}
+	if err := c.getJSON("/ids", url.Values{"id": {p.ID}}, &canonical); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(provenance.Sign(key, canonical.ID, p.Kind, p.Value)), nil
+}
+
+func runPull(c *client, args []string) error {
+	fs := flag.NewFlagSet("pull", flag.ExitOnError)
+	allowUnsigned := fs.Bool("allow-unsigned", false, "accept clips without a signature")
+	signer := fs.String("signer", "", "require the clip to be signed by `user`")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("expected one clip id")
+	}
+
+	cl, err := c.pull(fs.Arg(0), *allowUnsigned, *signer)
+	if err != nil {
+		return err
+	}
+	if err := c.seen(cl); err != nil {
+		return err
+	}
+
+	value := cl.Value
+	if cl.Type == "age" {
+		if value, err = decrypt(value); err != nil {
+			return err
+		}
+	}
+	_, err = os.Stdout.WriteString(value)
+	return err
+}
+
+// pull fetches a clip and checks its signature locally against the signer's
+// registered keys, refusing unsigned clips unless allowUnsigned is set.
+func (c *client) pull(id string, allowUnsigned bool, signer string) (*clip, error) {
+	var cl clip
+	if err := c.getJSON("/", url.Values{"id": {id}}, &cl); err != nil {
+		return nil, err
+	}
+
+	switch cl.Signature.Status {
+	case provenance.StatusUnsigned:
+		if allowUnsigned && signer == "" {
+			return &cl, nil
+		}
+		return nil, fmt.Errorf("%s is not signed", id)
+	case provenance.StatusVerified:
+	default:
+		return nil, fmt.Errorf("%s has a signature that does not verify", id)
+	}
+
+	if signer != "" && cl.Signature.Signer != signer {
+		return nil, fmt.Errorf("%s is signed by %s, not %s", id, cl.Signature.Signer, signer)
+	}
+	if err := c.verify(&cl); err != nil {
+		return nil, fmt.Errorf("%s: %w", id, err)
+	}
+	return &cl, nil
+}
+
+// verify checks the signature of cl with the signer's key rather than
+// relying on the status reported by the server.
+func (c *client) verify(cl *clip) error {
+	var keys []struct {
+		ID        string `json:"id"`
+		PublicKey string `json:"publicKey"`
+	}
+	if err := c.getJSON("/keys", url.Values{"user": {cl.Signature.Signer}}, &keys); err != nil {
+		return err
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(cl.Signature.Signature)
+	if err != nil {
+		return errors.New("signature is not valid base64")
+	}
+	for _, k := range keys {
+		if k.ID != cl.Signature.Key {
+			continue
+		}
+		pub, err := provenance.ParsePublicKey(k.PublicKey)
+		if err != nil {
+			return err
+		}
+		if provenance.Fingerprint(pub) != k.ID || !provenance.Verify(pub, cl.ID, cl.Type, cl.Value, sig) {
+			return errors.New("signature does not match the content")
+		}
+		return nil
+	}
+	return fmt.Errorf("signing key %s is not registered to %s", cl.Signature.Key, cl.Signature.Signer)
+}
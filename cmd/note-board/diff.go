@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffLine is a line of a line-based diff: ' ' for context, '-' for a line
+// only in the old text and '+' for one only in the new.
+type diffLine struct {
+	Op   byte
+	Text string
+}
+
+// maxDiffEdits bounds the work of diffLines; texts further apart are shown
+// as replaced wholesale.
+const maxDiffEdits = 2000
+
+// diffLines computes a shortest edit script from a to b with Myers'
+// algorithm.
+func diffLines(a, b []string) []diffLine {
+	n, m := len(a), len(b)
+	off := n + m
+	v := make([]int, 2*off+2)
+	// trace[d] holds the diagonals -d..d of v as they were before step d.
+	var trace [][]int
+
+	for d := 0; d <= off; d++ {
+		if d > maxDiffEdits {
+			return replaced(a, b)
+		}
+		trace = append(trace, append([]int(nil), v[off-d:off+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b, d)
+			}
+		}
+	}
+	return nil
+}
+
+func replaced(a, b []string) []diffLine {
+	var out []diffLine
+	for _, l := range a {
+		out = append(out, diffLine{'-', l})
+	}
+	for _, l := range b {
+		out = append(out, diffLine{'+', l})
+	}
+	return out
+}
+
+func backtrack(trace [][]int, a, b []string, d int) []diffLine {
+	var out []diffLine
+	x, y := len(a), len(b)
+	for ; d > 0; d-- {
+		v := func(k int) int { return trace[d][k+d] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v(k-1) < v(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			out = append(out, diffLine{' ', a[x]})
+		}
+		if x == prevX {
+			y--
+			out = append(out, diffLine{'+', b[y]})
+		} else {
+			x--
+			out = append(out, diffLine{'-', a[x]})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		out = append(out, diffLine{' ', a[x]})
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+// unifiedDiff returns the diff of old and new in the unified format with
+// context lines around each change, or nil when they are equal.
+func unifiedDiff(old, new string, context int) []string {
+	lines := diffLines(splitLines(old), splitLines(new))
+
+	var out []string
+	for i := 0; i < len(lines); {
+		if lines[i].Op == ' ' {
+			i++
+			continue
+		}
+		// Grow the hunk while the next change is within twice the context.
+		start := max(0, i-context)
+		end := i
+		for end < len(lines) {
+			if lines[end].Op != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(lines) && lines[next].Op == ' ' {
+				next++
+			}
+			if next == len(lines) || next-end > 2*context {
+				break
+			}
+			end = next
+		}
+		end = min(len(lines), end+context)
+
+		oldStart, newStart := 1, 1
+		for _, l := range lines[:start] {
+			if l.Op != '+' {
+				oldStart++
+			}
+			if l.Op != '-' {
+				newStart++
+			}
+		}
+		oldLen, newLen := 0, 0
+		for _, l := range lines[start:end] {
+			if l.Op != '+' {
+				oldLen++
+			}
+			if l.Op != '-' {
+				newLen++
+			}
+		}
+		// An empty range is numbered after the line it follows.
+		if oldLen == 0 {
+			oldStart--
+		}
+		if newLen == 0 {
+			newStart--
+		}
+		out = append(out, fmt.Sprintf("@@ -%d,%d +%d,%d @@", oldStart, oldLen, newStart, newLen))
+		for _, l := range lines[start:end] {
+			out = append(out, string(l.Op)+l.Text)
+		}
+		i = end
+	}
+	return out
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+func identityPath() (string, error) {
+	if p := os.Getenv("NOTE_BOARD_IDENTITY"); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "note-board", "age.key"), nil
+}
+
+// loadIdentities reads the age identity file, which uses the same format as
+// the age command.
+func loadIdentities() ([]age.Identity, error) {
+	path, err := identityPath()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ids, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ids, nil
+}
+
+// recipientsOf looks up the age recipients the users registered.
+func (c *client) recipientsOf(users []string) ([]age.Recipient, error) {
+	var recipients []age.Recipient
+	for _, user := range users {
+		var registered []struct {
+			Recipient string `json:"recipient"`
+		}
+		if err := c.getJSON("/recipients", url.Values{"user": {user}}, &registered); err != nil {
+			return nil, err
+		}
+		if len(registered) == 0 {
+			return nil, fmt.Errorf("%s has not registered an encryption key", user)
+		}
+
+		for _, r := range registered {
+			rcpt, err := age.ParseX25519Recipient(r.Recipient)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", user, err)
+			}
+			recipients = append(recipients, rcpt)
+		}
+	}
+	return recipients, nil
+}
+
+// encrypt returns value as an ASCII-armored age file for the recipients.
+func encrypt(value string, recipients []age.Recipient) (string, error) {
+	var buf bytes.Buffer
+	aw := armor.NewWriter(&buf)
+	w, err := age.Encrypt(aw, recipients...)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.WriteString(w, value); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	if err := aw.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func decrypt(armored string) (string, error) {
+	ids, err := loadIdentities()
+	if errors.Is(err, os.ErrNotExist) {
+		return "", errors.New("clip is encrypted and there is no identity to decrypt it (see note-board keygen)")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	r, err := age.Decrypt(armor.NewReader(strings.NewReader(armored)), ids...)
+	var noMatch *age.NoIdentityMatchError
+	if errors.As(err, &noMatch) {
+		return "", errors.New("clip is not encrypted to any of your keys")
+	}
+	if err != nil {
+		return "", err
+	}
+	plain, err := io.ReadAll(r)
+	return string(plain), err
+}
+
+// stringsFlag collects a repeated flag.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string { return strings.Join(*s, ",") }
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"note-board/internal/dotenv"
+)
+
+// runExec runs a command with the variables of an env clip added to its
+// environment. The variables only ever live in memory and in the
+// environment of the command.
+func runExec(c *client, args []string) error {
+	fs := flag.NewFlagSet("exec", flag.ExitOnError)
+	allowUnsigned := fs.Bool("allow-unsigned", false, "accept clips without a signature")
+	signer := fs.String("signer", "", "require the clip to be signed by `user`")
+	fs.Parse(args)
+
+	args = fs.Args()
+	if len(args) > 1 && args[1] == "--" {
+		args = append(args[:1:1], args[2:]...)
+	}
+	if len(args) < 2 {
+		return errors.New("expected a clip id and a command")
+	}
+	id, argv := args[0], args[1:]
+
+	cl, err := c.pull(id, *allowUnsigned, *signer)
+	if err != nil {
+		return err
+	}
+	value := cl.Value
+	switch cl.Type {
+	case "env":
+	case "age":
+		if value, err = decrypt(value); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%s is a %s clip, not an env clip", id, cl.Type)
+	}
+	vars, err := dotenv.Parse(value)
+	if err != nil {
+		return fmt.Errorf("%s: %w", id, err)
+	}
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = os.Environ()
+	for _, v := range vars {
+		cmd.Env = append(cmd.Env, v.Name+"="+v.Value)
+	}
+
+	// The terminal delivers interrupts to the command as well, so they are
+	// only kept from stopping note-board before the command exits. SIGTERM
+	// is passed on.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		for sig := range sigs {
+			if sig == syscall.SIGTERM {
+				cmd.Process.Signal(sig)
+			}
+		}
+	}()
+
+	err = cmd.Wait()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		os.Exit(max(exit.ExitCode(), 1))
+	}
+	return err
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Terminal styles, as SGR escape sequences.
+const (
+	styleNone    = ""
+	styleBold    = "\x1b[1m"
+	styleReverse = "\x1b[7m"
+	styleRed     = "\x1b[31m"
+	styleGreen   = "\x1b[32m"
+	styleYellow  = "\x1b[33m"
+	styleBlue    = "\x1b[34m"
+	styleMagenta = "\x1b[35m"
+	styleCyan    = "\x1b[36m"
+	styleGray    = "\x1b[90m"
+	styleReset   = "\x1b[0m"
+)
+
+// span is text in one style; a styledLine is a line of them.
+type span struct {
+	style string
+	text  string
+}
+
+type styledLine []span
+
+func plainLines(s string) []styledLine {
+	var lines []styledLine
+	for _, l := range splitLines(s) {
+		lines = append(lines, styledLine{{styleNone, l}})
+	}
+	return lines
+}
+
+// language picks the highlighting of a clip from its type, a "lang" label
+// or the extension of its id.
+func language(kind, id string, meta map[string]string) string {
+	switch kind {
+	case "json", "mock":
+		return "json"
+	case "env", "log", "asciicast", "age":
+		return kind
+	}
+	if lang := meta["lang"]; lang != "" {
+		return strings.ToLower(lang)
+	}
+	switch ext := strings.TrimPrefix(path.Ext(id), "."); ext {
+	case "yml":
+		return "yaml"
+	case "js", "ts", "jsx", "tsx", "mjs":
+		return "js"
+	case "sh", "bash", "zsh":
+		return "sh"
+	case "py", "go", "rs", "c", "h", "java", "sql", "rb", "yaml", "json", "env":
+		return ext
+	}
+	return "text"
+}
+
+// highlight splits value into styled lines for display.
+func highlight(lang, value string) []styledLine {
+	switch lang {
+	case "json":
+		var buf bytes.Buffer
+		if json.Indent(&buf, []byte(value), "", "  ") == nil {
+			value = buf.String()
+		}
+		return mapLines(value, highlightJSON)
+	case "asciicast":
+		return mapLines(value, highlightJSON)
+	case "env":
+		return mapLines(value, highlightEnv)
+	case "log":
+		return mapLines(value, highlightLog)
+	case "yaml":
+		return mapLines(value, highlightYAML)
+	case "age":
+		return mapLines(value, func(l string) styledLine { return styledLine{{styleGray, l}} })
+	}
+	if kw, ok := keywords[lang]; ok {
+		comment := "//"
+		switch lang {
+		case "py", "sh", "rb":
+			comment = "#"
+		case "sql":
+			comment = "--"
+		}
+		return mapLines(value, func(l string) styledLine { return highlightCode(l, kw, comment) })
+	}
+	return plainLines(value)
+}
+
+func mapLines(s string, fn func(string) styledLine) []styledLine {
+	var lines []styledLine
+	for _, l := range splitLines(s) {
+		lines = append(lines, fn(l))
+	}
+	return lines
+}
+
+// highlightJSON colors the tokens of a line of JSON. Strings followed by a
+// colon are keys.
+func highlightJSON(l string) styledLine {
+	var line styledLine
+	for i := 0; i < len(l); {
+		switch c := l[i]; {
+		case c == '"':
+			j := stringEnd(l, i)
+			style := styleGreen
+			if rest := strings.TrimLeft(l[j:], " \t"); strings.HasPrefix(rest, ":") {
+				style = styleCyan
+			}
+			line = append(line, span{style, l[i:j]})
+			i = j
+		case c == '-' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(l) && strings.IndexByte("0123456789.eE+-", l[j]) >= 0 {
+				j++
+			}
+			line = append(line, span{styleYellow, l[i:j]})
+			i = j
+		case strings.HasPrefix(l[i:], "true") || strings.HasPrefix(l[i:], "false") || strings.HasPrefix(l[i:], "null"):
+			j := i + 4
+			if l[i] == 'f' {
+				j++
+			}
+			line = append(line, span{styleMagenta, l[i:j]})
+			i = j
+		default:
+			j := i + 1
+			for j < len(l) && strings.IndexByte("\"-0123456789tfn", l[j]) < 0 {
+				j++
+			}
+			line = append(line, span{styleNone, l[i:j]})
+			i = j
+		}
+	}
+	return line
+}
+
+// stringEnd returns the index after the string literal starting with the
+// quote at l[i], or len(l) when it does not end on the line.
+func stringEnd(l string, i int) int {
+	quote := l[i]
+	for j := i + 1; j < len(l); j++ {
+		switch l[j] {
+		case '\\':
+			j++
+		case quote:
+			return j + 1
+		}
+	}
+	return len(l)
+}
+
+func highlightEnv(l string) styledLine {
+	trimmed := strings.TrimSpace(l)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return styledLine{{styleGray, l}}
+	}
+	var line styledLine
+	if rest, ok := strings.CutPrefix(l, "export "); ok {
+		line = append(line, span{styleMagenta, "export "})
+		l = rest
+	}
+	name, value, ok := strings.Cut(l, "=")
+	if !ok {
+		return append(line, span{styleNone, l})
+	}
+	return append(line, span{styleCyan, name}, span{styleNone, "="}, span{styleGreen, value})
+}
+
+var (
+	logTime  = regexp.MustCompile(`^\[?\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}\S*|^\[?\d{2}:\d{2}:\d{2}\S*`)
+	logLevel = regexp.MustCompile(`\b(?:ERROR|ERR|FATAL|PANIC|WARN(?:ING)?|INFO|DEBUG|TRACE)\b`)
+)
+
+func highlightLog(l string) styledLine {
+	var line styledLine
+	if loc := logTime.FindStringIndex(l); loc != nil {
+		line = append(line, span{styleGray, l[:loc[1]]})
+		l = l[loc[1]:]
+	}
+	loc := logLevel.FindStringIndex(l)
+	if loc == nil {
+		return append(line, span{styleNone, l})
+	}
+	style := styleGray
+	switch level := l[loc[0]:loc[1]]; {
+	case strings.HasPrefix(level, "WARN"):
+		style = styleYellow
+	case level == "INFO":
+		style = styleGreen
+	case level != "DEBUG" && level != "TRACE":
+		style = styleRed + styleBold
+	}
+	return append(line, span{styleNone, l[:loc[0]]}, span{style, l[loc[0]:loc[1]]}, span{styleNone, l[loc[1]:]})
+}
+
+func highlightYAML(l string) styledLine {
+	if i := strings.Index(l, "#"); i == 0 || (i > 0 && l[i-1] == ' ') {
+		return append(highlightYAML(l[:i]), span{styleGray, l[i:]})
+	}
+	key, value, ok := strings.Cut(l, ":")
+	if !ok || strings.ContainsAny(key, `"'`) {
+		return styledLine{{styleGreen, l}}
+	}
+	return styledLine{{styleCyan, key}, {styleNone, ":"}, {styleGreen, value}}
+}
+
+var keywords = map[string]map[string]bool{
+	"go":   wordSet("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var nil true false"),
+	"js":   wordSet("async await break case catch class const continue default delete do else export extends false finally for from function if import in instanceof let new null return static super switch this throw true try typeof undefined var void while yield"),
+	"py":   wordSet("and as assert async await break class continue def del elif else except False finally for from global if import in is lambda None nonlocal not or pass raise return True try while with yield"),
+	"sh":   wordSet("case do done elif else esac export fi for function if in local return then until while"),
+	"rs":   wordSet("as async await break const continue crate else enum extern false fn for if impl in let loop match mod move mut pub ref return self Self static struct super trait true type unsafe use where while"),
+	"c":    wordSet("auto break case char const continue default do double else enum extern float for goto if int long return short signed sizeof static struct switch typedef union unsigned void volatile while"),
+	"h":    wordSet("auto break case char const continue default do double else enum extern float for goto if int long return short signed sizeof static struct switch typedef union unsigned void volatile while"),
+	"java": wordSet("abstract boolean break case catch class continue default do double else extends false final finally for if implements import instanceof int interface long new null package private protected public return static super switch this throw throws true try void while"),
+	"rb":   wordSet("begin class def do else elsif end ensure false for if module next nil not or rescue return self then true unless until when while yield"),
+	"sql":  wordSet("select from where and or not insert into values update set delete create table drop alter index join left right inner outer on group by order having limit as null is in like distinct union"),
+}
+
+func wordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
+
+// highlightCode colors a line of source code: comments starting with
+// comment, string literals, numbers and keywords.
+func highlightCode(l string, kw map[string]bool, comment string) styledLine {
+	var line styledLine
+	plain := 0
+	flush := func(i int) {
+		if i > plain {
+			line = append(line, span{styleNone, l[plain:i]})
+		}
+	}
+	for i := 0; i < len(l); {
+		c := l[i]
+		switch {
+		case strings.HasPrefix(l[i:], comment):
+			flush(i)
+			return append(line, span{styleGray, l[i:]})
+		case c == '"' || c == '\'' || c == '`':
+			flush(i)
+			j := stringEnd(l, i)
+			line = append(line, span{styleGreen, l[i:j]})
+			i, plain = j, j
+		case isWordStart(rune(c)):
+			number := c >= '0' && c <= '9'
+			j := i + 1
+			for j < len(l) && (isWordPart(rune(l[j])) || number && l[j] == '.') {
+				j++
+			}
+			word := l[i:j]
+			if kw[word] || kw[strings.ToLower(word)] && comment == "--" {
+				flush(i)
+				line = append(line, span{styleMagenta, word})
+				plain = j
+			} else if number {
+				flush(i)
+				line = append(line, span{styleYellow, word})
+				plain = j
+			}
+			i = j
+		default:
+			i++
+		}
+	}
+	flush(len(l))
+	return line
+}
+
+func isWordStart(r rune) bool {
+	return r == '_' || r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+func isWordPart(r rune) bool {
+	return isWordStart(r)
+}
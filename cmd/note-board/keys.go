@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"filippo.io/age"
+
+	"note-board/internal/provenance"
+)
+
+func keyPath() (string, error) {
+	if p := os.Getenv("NOTE_BOARD_KEY"); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "note-board", "signing.key"), nil
+}
+
+func loadKey() (ed25519.PrivateKey, error) {
+	path, err := keyPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s: not a PEM private key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+	}
+	return edKey, nil
+}
+
+// writeSecret writes a private key file that only the user can read.
+func writeSecret(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// runKeygen creates the Ed25519 signing key and the age identity clips are
+// encrypted to, keeping existing ones unless -force is given.
+func runKeygen(c *client, args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	force := fs.Bool("force", false, "replace existing keys")
+	fs.Parse(args)
+
+	path, err := keyPath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil && !*force {
+		fmt.Printf("keeping %s\n", path)
+	} else {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return err
+		}
+		if err := writeSecret(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})); err != nil {
+			return err
+		}
+		fmt.Printf("wrote %s\n", path)
+		fmt.Printf("  signing key: %s\n", provenance.Fingerprint(pub))
+	}
+
+	path, err = identityPath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil && !*force {
+		fmt.Printf("keeping %s\n", path)
+	} else {
+		id, err := age.GenerateX25519Identity()
+		if err != nil {
+			return err
+		}
+		data := fmt.Sprintf("# public key: %s\n%s\n", id.Recipient(), id)
+		if err := writeSecret(path, []byte(data)); err != nil {
+			return err
+		}
+		fmt.Printf("wrote %s\n", path)
+		fmt.Printf("  recipient: %s\n", id.Recipient())
+	}
+
+	fmt.Println("register them with: note-board register-key")
+	return nil
+}
+
+// runRegisterKey registers the public halves of the signing key and the age
+// identity with the caller's account.
+func runRegisterKey(c *client, args []string) error {
+	fs := flag.NewFlagSet("register-key", flag.ExitOnError)
+	host, _ := os.Hostname()
+	name := fs.String("name", host, "label for the keys")
+	fs.Parse(args)
+
+	key, err := loadKey()
+	if errors.Is(err, os.ErrNotExist) {
+		return errors.New("no signing key; run note-board keygen first")
+	}
+	if err != nil {
+		return err
+	}
+
+	pub := key.Public().(ed25519.PublicKey)
+	resp, err := c.postJSON("/account/keys", map[string]string{
+		"name":      *name,
+		"publicKey": provenance.EncodePublicKey(pub),
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	fmt.Printf("registered signing key %s\n", provenance.Fingerprint(pub))
+
+	ids, err := loadIdentities()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		x, ok := id.(*age.X25519Identity)
+		if !ok {
+			continue
+		}
+		resp, err := c.postJSON("/account/recipients", map[string]string{
+			"name":      *name,
+			"recipient": x.Recipient().String(),
+		})
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		fmt.Printf("registered recipient %s\n", x.Recipient())
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// appendInterval is how long append gathers input before sending it, so
+// that chatty producers do not make a request per line.
+const appendInterval = 500 * time.Millisecond
+
+// runAppend appends its input to a log clip as it arrives.
+func runAppend(c *client, args []string) error {
+	fs := flag.NewFlagSet("append", flag.ExitOnError)
+	closeLog := fs.Bool("close", false, "close the log at the end of the input")
+	ttl := fs.String("ttl", "", "time to live of a new log, such as 30m")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		return errors.New("missing clip id")
+	}
+	id := fs.Arg(0)
+	in := os.Stdin
+	if fs.NArg() > 1 && fs.Arg(1) != "-" {
+		f, err := os.Open(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	} else if fs.NArg() > 2 {
+		return errors.New("too many arguments")
+	}
+
+	send := func(chunk []byte, last bool) error {
+		query := url.Values{"id": {id}, "append": {"true"}}
+		if *ttl != "" {
+			query.Set("ttl", *ttl)
+		}
+		if last && *closeLog {
+			query.Set("close", "true")
+		}
+		resp, err := c.request(http.MethodPost, "/", query, bytes.NewReader(chunk), http.Header{"Content-Type": {"text/plain; charset=utf-8"}})
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	// A reader goroutine feeds the input so that what has arrived can be
+	// sent on a timer while the producer is quiet.
+	chunks := make(chan []byte)
+	readErr := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 32<<10)
+		for {
+			n, err := in.Read(buf)
+			if n > 0 {
+				chunks <- bytes.Clone(buf[:n])
+			}
+			if err != nil {
+				close(chunks)
+				if err == io.EOF {
+					err = nil
+				}
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	var pending []byte
+	tick := time.NewTicker(appendInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				if err := <-readErr; err != nil {
+					return err
+				}
+				if len(pending) > 0 || *closeLog {
+					return send(pending, true)
+				}
+				return nil
+			}
+			pending = append(pending, chunk...)
+			if len(pending) < 256<<10 {
+				continue
+			}
+		case <-tick.C:
+			if len(pending) == 0 {
+				continue
+			}
+		}
+		if err := send(pending, false); err != nil {
+			return err
+		}
+		pending = pending[:0]
+	}
+}
+
+// runTail prints a clip, or with -f follows a log clip until it is closed.
+func runTail(c *client, args []string) error {
+	fs := flag.NewFlagSet("tail", flag.ExitOnError)
+	follow := fs.Bool("f", false, "keep printing what is appended until the log is closed")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("expected one clip id")
+	}
+	id := fs.Arg(0)
+
+	if !*follow {
+		var cl clip
+		if err := c.getJSON("/", url.Values{"id": {id}}, &cl); err != nil {
+			return err
+		}
+		_, err := os.Stdout.WriteString(cl.Value)
+		return err
+	}
+
+	// Like tail -F, wait for a log that has not been started yet.
+	waiting := false
+	resp, err := c.requestStream(http.MethodGet, "/", url.Values{"id": {id}, "follow": {"1"}}, nil)
+	for isNotFound(err) {
+		if !waiting {
+			fmt.Fprintf(os.Stderr, "note-board: waiting for %s\n", id)
+			waiting = true
+		}
+		time.Sleep(time.Second)
+		resp, err = c.requestStream(http.MethodGet, "/", url.Values{"id": {id}, "follow": {"1"}}, nil)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(os.Stdout, resp.Body); err != nil {
+		return fmt.Errorf("%s: %w", id, err)
+	}
+	return nil
+}
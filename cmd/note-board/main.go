@@ -0,0 +1,217 @@
+// Command note-board is a command line client for a note-board server.
+//
+//	note-board keygen                  create a signing key and an age identity
+//	note-board register-key [-name N]  register the public keys with the server
+//	note-board push [flags] ID [FILE]  store a clip, signed when a key exists
+//	note-board pull [flags] ID         print a clip after checking its signature
+//	note-board split -k K -to USER...  split a secret into shares, one per user
+//	note-board combine FILE...         reconstruct a secret from shares
+//	note-board exec ID -- CMD [ARG]... run a command with an env clip's variables
+//	note-board run ID -- CMD [ARG]...  run a command and store its output
+//	note-board append [-close] ID      append stdin to a log clip as it arrives
+//	note-board tail [-f] ID            print a clip, following a log with -f
+//	note-board record ID               record a shell session for playback
+//	note-board queue ls|push|drop      manage pushes made while offline
+//	note-board tui [-prefix P]         browse the board in the terminal
+//
+// push -to USER encrypts the clip to the age recipients USER registered;
+// pull decrypts such clips with the local identity. Pushes made while the
+// server is unreachable are queued and sent when it is back.
+//
+// The server is NOTE_BOARD_URL (default http://localhost:8080) and requests
+// are authenticated with the session token in NOTE_BOARD_TOKEN. Requests
+// give up after 30 seconds without an answer. The signing
+// key is read from NOTE_BOARD_KEY and the age identity from
+// NOTE_BOARD_IDENTITY, by default signing.key and age.key in the user's
+// note-board config directory.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type command struct {
+	usage string
+	run   func(c *client, args []string) error
+}
+
+var commands = map[string]command{
+	"keygen":       {"keygen", runKeygen},
+	"register-key": {"register-key [-name NAME]", runRegisterKey},
+	"push":         {"push [-type T] [-ttl D] [-meta K=V]... [-to USER]... [-reader USER]... [-burn] [-no-sign] ID [FILE]", runPush},
+	"pull":         {"pull [-allow-unsigned] [-signer USER] ID", runPull},
+	"split":        {"split -k K -to USER... [-ttl D] [-plain] [-no-sign] ID [FILE]", runSplit},
+	"combine":      {"combine [FILE...]", runCombine},
+	"exec":         {"exec [-allow-unsigned] [-signer USER] ID [--] COMMAND [ARG...]", runExec},
+	"append":       {"append [-close] [-ttl D] ID [FILE]", runAppend},
+	"tail":         {"tail [-f] ID", runTail},
+	"record":       {"record [-title T] [-command CMD] [-ttl D] [-o FILE] [-no-sign] ID", runRecord},
+	"queue":        {"queue ls | queue push [-force] | queue drop KEY|ID...", runQueue},
+	"tui":          {"tui [-prefix P]", runTUI},
+	"run":          {"run [-ttl D] [-meta K=V]... [-to USER]... [-no-sign] ID [--] COMMAND [ARG...]", runRun},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: note-board <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintln(os.Stderr, "  note-board "+commands[name].usage)
+	}
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+
+	if err := cmd.run(newClient(), os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "note-board:", err)
+		os.Exit(1)
+	}
+}
+
+// requestTimeout bounds a request and the reading of its response, except
+// for streams, which only have to start responding within it.
+const requestTimeout = 30 * time.Second
+
+type client struct {
+	base  string
+	token string
+	// streams sends the requests made by requestStream.
+	http, streams *http.Client
+}
+
+func newClient() *client {
+	base := os.Getenv("NOTE_BOARD_URL")
+	if base == "" {
+		base = "http://localhost:8080"
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = requestTimeout
+	return &client{
+		base:    strings.TrimSuffix(base, "/"),
+		token:   os.Getenv("NOTE_BOARD_TOKEN"),
+		http:    &http.Client{Transport: transport, Timeout: requestTimeout},
+		streams: &http.Client{Transport: transport},
+	}
+}
+
+// request sends a request to the server and returns the response when it has
+// a 2xx status. Other responses are turned into errors carrying the body.
+func (c *client) request(method, path string, query url.Values, body io.Reader, header http.Header) (*http.Response, error) {
+	return c.do(c.http, method, path, query, body, header)
+}
+
+// requestStream is like request for responses that last as long as the
+// server keeps them open, such as followed logs and the change feed.
+func (c *client) requestStream(method, path string, query url.Values, header http.Header) (*http.Response, error) {
+	return c.do(c.streams, method, path, query, nil, header)
+}
+
+func (c *client) do(hc *http.Client, method, path string, query url.Values, body io.Reader, header http.Header) (*http.Response, error) {
+	u := c.base + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &statusError{resp.StatusCode, resp.Status, strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
+
+// statusError is the error for a response that is not 2xx.
+type statusError struct {
+	Code   int
+	Status string
+	Msg    string
+}
+
+func (e *statusError) Error() string {
+	return e.Status + ": " + e.Msg
+}
+
+func isNotFound(err error) bool {
+	var se *statusError
+	return errors.As(err, &se) && se.Code == http.StatusNotFound
+}
+
+// getJSON decodes the JSON response of a GET into v.
+func (c *client) getJSON(path string, query url.Values, v any) error {
+	resp, err := c.request(http.MethodGet, path, query, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (c *client) postJSON(path string, v any) (*http.Response, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return c.request(http.MethodPost, path, nil, bytes.NewReader(body), http.Header{"Content-Type": {"application/json"}})
+}
+
+// readInput reads the file named by args[0], or stdin when there is none or
+// it is "-".
+func readInput(args []string) ([]byte, error) {
+	if len(args) == 0 || args[0] == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	if len(args) > 1 {
+		return nil, errors.New("too many arguments")
+	}
+	return os.ReadFile(args[0])
+}
+
+// metaFlag collects repeated -meta key=value flags.
+type metaFlag map[string]string
+
+func (m metaFlag) String() string { return "" }
+
+func (m metaFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok {
+		return errors.New("expected key=value")
+	}
+	m[k] = v
+	return nil
+}
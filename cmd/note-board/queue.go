@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Pushes that cannot reach the server wait in a queue in the note-board
+// config directory, or in NOTE_BOARD_QUEUE, and are sent in order by the
+// next push or by queue push. Each is sent with an Idempotency-Key, so a
+// push whose response was lost is not applied twice, and with If-Match the
+// version of the clip last pulled or pushed from this machine, so one that
+// would overwrite a change made meanwhile is held as a conflict instead.
+
+const (
+	statusPending  = "pending"
+	statusConflict = "conflict"
+	statusFailed   = "failed"
+)
+
+type queuedPush struct {
+	Key        string            `json:"key"`
+	Server     string            `json:"server"`
+	ID         string            `json:"id"`
+	Kind       string            `json:"type"`
+	Value      string            `json:"value"`
+	Meta       map[string]string `json:"meta,omitempty"`
+	Recipients []string          `json:"recipients,omitempty"`
+	Readers    []string          `json:"readers,omitempty"`
+	TTL        string            `json:"ttl,omitempty"`
+	Burn       bool              `json:"burn,omitempty"`
+	// SigningKey is the fingerprint of the key the push is signed with
+	// when it is sent, empty for unsigned pushes.
+	SigningKey string `json:"signingKey,omitempty"`
+	// Base is the version of the clip the push replaces, zero when unknown.
+	Base   uint64    `json:"base,omitempty"`
+	Queued time.Time `json:"queued"`
+	Status string    `json:"status"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// queue is the state kept between runs: the queued pushes and the last
+// known version of clips by server.
+type queue struct {
+	Entries  []*queuedPush                `json:"entries"`
+	Versions map[string]map[string]uint64 `json:"versions"`
+
+	path string
+}
+
+func queuePath() (string, error) {
+	if p := os.Getenv("NOTE_BOARD_QUEUE"); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "note-board", "queue.json"), nil
+}
+
+func loadQueue() (*queue, error) {
+	path, err := queuePath()
+	if err != nil {
+		return nil, err
+	}
+	q := &queue{path: path}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, q); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if q.Versions == nil {
+		q.Versions = make(map[string]map[string]uint64)
+	}
+	return q, nil
+}
+
+// save writes the queue with the permissions of a key, as it holds clip
+// values.
+func (q *queue) save() error {
+	data, err := json.MarshalIndent(q, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := q.path + ".tmp"
+	if err := writeSecret(tmp, data); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path)
+}
+
+// pending counts the pushes waiting to be sent to server.
+func (q *queue) pending(server string) int {
+	n := 0
+	for _, p := range q.Entries {
+		if p.Server == server && p.Status == statusPending {
+			n++
+		}
+	}
+	return n
+}
+
+func (q *queue) version(server, id string) uint64 {
+	return q.Versions[server][id]
+}
+
+func (q *queue) setVersion(server, id string, version uint64) {
+	if version == 0 {
+		return
+	}
+	if q.Versions[server] == nil {
+		q.Versions[server] = make(map[string]uint64)
+	}
+	q.Versions[server][id] = version
+}
+
+// seen records the version of a pulled clip as the base of later pushes.
+func (c *client) seen(cl *clip) error {
+	q, err := loadQueue()
+	if err != nil {
+		return err
+	}
+	if cl.Version == q.version(c.base, cl.ID) {
+		return nil
+	}
+	q.setVersion(c.base, cl.ID, cl.Version)
+	return q.save()
+}
+
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// unreachable reports whether err means the push should wait for the server
+// rather than fail: no response, one cut short, or a gateway that could not
+// reach it. Other errors, such as a missing signing key, fail the push.
+func unreachable(err error) bool {
+	var (
+		se *statusError
+		ue *url.Error
+		ne net.Error
+	)
+	switch {
+	case errors.As(err, &se):
+		switch se.Code {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	case errors.As(err, &ue), errors.As(err, &ne):
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// replay sends the pending pushes for c's server in order, stopping at the
+// first that cannot reach it. With force, conflicts are sent again without
+// their base version, overwriting the clip.
+func (c *client) replay(q *queue, force bool) error {
+	// sent maps a clip id to the base and resulting version of the last
+	// push of it sent here, so that later pushes queued on the same base
+	// follow it rather than conflict with it.
+	type result struct{ base, version uint64 }
+	sent := make(map[string]result)
+
+entries:
+	for _, p := range q.Entries {
+		if p.Server != c.base {
+			continue
+		}
+		switch {
+		case p.Status == statusPending:
+		case p.Status == statusConflict && force:
+			p.Base = 0
+			p.Key = newIdempotencyKey()
+		default:
+			continue
+		}
+		if r, ok := sent[p.ID]; ok && p.Base == r.base {
+			p.Base = r.version
+		}
+
+		base := p.Base
+		version, err := c.send(p)
+		if unreachable(err) {
+			p.Error = err.Error()
+			break entries
+		}
+
+		var se *statusError
+		switch {
+		case err == nil:
+			p.Status = ""
+			sent[p.ID] = result{base, version}
+			q.setVersion(c.base, p.ID, version)
+			fmt.Fprintf(os.Stderr, "sent queued push of %s\n", p.ID)
+		case errors.As(err, &se) && se.Code == http.StatusPreconditionFailed:
+			p.Status = statusConflict
+			p.Error = se.Msg
+			fmt.Fprintf(os.Stderr, "note-board: queued push %s of %s conflicts: %s\n", p.Key, p.ID, se.Msg)
+		default:
+			p.Status = statusFailed
+			p.Error = err.Error()
+			fmt.Fprintf(os.Stderr, "note-board: queued push %s of %s failed: %v\n", p.Key, p.ID, err)
+		}
+	}
+
+	kept := q.Entries[:0]
+	for _, p := range q.Entries {
+		if p.Status != "" {
+			kept = append(kept, p)
+		}
+	}
+	q.Entries = kept
+	return q.save()
+}
+
+func runQueue(c *client, args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected ls, push or drop")
+	}
+	q, err := loadQueue()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "ls":
+		fs := flag.NewFlagSet("queue ls", flag.ExitOnError)
+		fs.Parse(args[1:])
+		for _, p := range q.Entries {
+			base := "-"
+			if p.Base > 0 {
+				base = fmt.Sprint(p.Base)
+			}
+			fmt.Printf("%s  %-8s  %s  %s  base %s  %s\n", p.Key, p.Status, p.Queued.Local().Format(time.DateTime), p.ID, base, p.Server)
+			if p.Error != "" {
+				fmt.Printf("    %s\n", p.Error)
+			}
+		}
+		return nil
+
+	case "push":
+		fs := flag.NewFlagSet("queue push", flag.ExitOnError)
+		force := fs.Bool("force", false, "overwrite the clips of conflicting pushes")
+		fs.Parse(args[1:])
+		if err := c.replay(q, *force); err != nil {
+			return err
+		}
+		if n := q.pending(c.base); n > 0 {
+			return fmt.Errorf("%s is unreachable, %d pushes still queued", c.base, n)
+		}
+		return nil
+
+	case "drop":
+		fs := flag.NewFlagSet("queue drop", flag.ExitOnError)
+		fs.Parse(args[1:])
+		if fs.NArg() == 0 {
+			return errors.New("expected queued push keys or clip ids")
+		}
+		drop := make(map[string]bool)
+		for _, arg := range fs.Args() {
+			drop[arg] = true
+		}
+		kept := q.Entries[:0]
+		for _, p := range q.Entries {
+			if drop[p.Key] || drop[p.ID] {
+				fmt.Fprintf(os.Stderr, "dropped %s of %s\n", p.Key, p.ID)
+				continue
+			}
+			kept = append(kept, p)
+		}
+		q.Entries = kept
+		return q.save()
+	}
+	return fmt.Errorf("unknown queue command %q", args[0])
+}
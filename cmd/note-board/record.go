@@ -0,0 +1,181 @@
+//go:build unix
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/creack/pty"
+	"golang.org/x/term"
+)
+
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Duration  float64           `json:"duration"`
+	Title     string            `json:"title,omitempty"`
+	Command   string            `json:"command,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// recording collects the events of an asciicast v2 recording.
+type recording struct {
+	mu     sync.Mutex
+	start  time.Time
+	events []byte
+	// partial holds the start of a UTF-8 sequence split across reads.
+	partial []byte
+}
+
+func (rec *recording) add(code, data string) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	event, _ := json.Marshal([]any{time.Since(rec.start).Seconds(), code, data})
+	rec.events = append(append(rec.events, event...), '\n')
+}
+
+// output records terminal output, keeping an incomplete trailing UTF-8
+// sequence for the next read.
+func (rec *recording) output(p []byte) {
+	data := append(rec.partial, p...)
+	end := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				end = i
+			}
+			break
+		}
+	}
+	rec.partial = append([]byte(nil), data[end:]...)
+	if end > 0 {
+		rec.add("o", string(data[:end]))
+	}
+}
+
+// runRecord records a shell session in the asciicast v2 format and stores
+// it as a clip that the server can play back.
+func runRecord(c *client, args []string) error {
+	fs := flag.NewFlagSet("record", flag.ExitOnError)
+	title := fs.String("title", "", "title of the recording")
+	command := fs.String("command", "", "command to record instead of $SHELL")
+	ttl := fs.String("ttl", "", "time to live, such as 30m")
+	output := fs.String("o", "", "also write the recording to `file`")
+	noSign := fs.Bool("no-sign", false, "do not sign the clip")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("expected one clip id")
+	}
+	id := fs.Arg(0)
+
+	stdin, stdout := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !term.IsTerminal(stdin) || !term.IsTerminal(stdout) {
+		return errors.New("record needs a terminal")
+	}
+	width, height, err := term.GetSize(stdout)
+	if err != nil {
+		return err
+	}
+	if width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+
+	shell := *command
+	if shell == "" {
+		if shell = os.Getenv("SHELL"); shell == "" {
+			shell = "/bin/sh"
+		}
+	}
+	cmd := exec.Command("/bin/sh", "-c", shell)
+	if *command == "" {
+		cmd = exec.Command(shell)
+	}
+	cmd.Env = append(os.Environ(), "NOTE_BOARD_RECORDING="+id)
+
+	ptmx, err := pty.StartWithSize(cmd, &pty.Winsize{Cols: uint16(width), Rows: uint16(height)})
+	if err != nil {
+		return err
+	}
+	defer ptmx.Close()
+
+	fmt.Fprintf(os.Stderr, "note-board: recording %s, exit the shell to finish\n", id)
+	state, err := term.MakeRaw(stdin)
+	if err != nil {
+		return err
+	}
+	restore := sync.OnceFunc(func() { term.Restore(stdin, state) })
+	defer restore()
+
+	rec := &recording{start: time.Now()}
+
+	resized := make(chan os.Signal, 1)
+	signal.Notify(resized, syscall.SIGWINCH)
+	defer signal.Stop(resized)
+	go func() {
+		for range resized {
+			if w, h, err := term.GetSize(stdout); err == nil {
+				pty.Setsize(ptmx, &pty.Winsize{Cols: uint16(w), Rows: uint16(h)})
+				rec.add("r", fmt.Sprintf("%dx%d", w, h))
+			}
+		}
+	}()
+
+	go io.Copy(ptmx, os.Stdin)
+
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := ptmx.Read(buf)
+		if n > 0 {
+			os.Stdout.Write(buf[:n])
+			rec.output(buf[:n])
+		}
+		if err != nil {
+			// Linux reports EIO once the shell has exited.
+			break
+		}
+	}
+	cmd.Wait()
+	restore()
+	duration := time.Since(rec.start)
+
+	header, err := json.Marshal(castHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: rec.start.Unix(),
+		Duration:  duration.Seconds(),
+		Title:     *title,
+		Command:   shell,
+		Env:       map[string]string{"SHELL": os.Getenv("SHELL"), "TERM": os.Getenv("TERM")},
+	})
+	if err != nil {
+		return err
+	}
+	rec.mu.Lock()
+	cast := string(header) + "\n" + strings.ToValidUTF8(string(rec.events), "�")
+	rec.mu.Unlock()
+
+	fmt.Fprintf(os.Stderr, "note-board: recorded %s\n", duration.Round(time.Second))
+	if *output != "" {
+		if err := os.WriteFile(*output, []byte(cast), 0o644); err != nil {
+			return err
+		}
+	}
+	return c.push(id, "asciicast", cast, pushOptions{ttl: *ttl, sign: !*noSign})
+}
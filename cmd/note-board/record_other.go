@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+func runRecord(c *client, args []string) error {
+	return errors.New("record is only supported on Unix systems")
+}
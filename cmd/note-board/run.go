@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+)
+
+// maxOutput is the most output run uploads, leaving room for encryption
+// within the server's clip size limit. Longer output is cut at the start,
+// keeping the end where failures are reported.
+const maxOutput = 512 << 10
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	max       int
+	buf       []byte
+	truncated bool
+}
+
+// Write lets the buffer grow to twice its size before dropping the start,
+// so that long output is not copied on every write.
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > 2*t.max {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-t.max:]...)
+		t.truncated = true
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) Bytes() []byte {
+	if over := len(t.buf) - t.max; over > 0 {
+		t.truncated = true
+		return t.buf[over:]
+	}
+	return t.buf
+}
+
+// runRun runs a command, showing its combined output as it runs, and then
+// stores the output as a clip labelled with the command line, exit code,
+// duration, host and working directory. note-board exits with the exit code
+// of the command.
+func runRun(c *client, args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	ttl := fs.String("ttl", "", "time to live, such as 30m")
+	noSign := fs.Bool("no-sign", false, "do not sign the clip")
+	meta := metaFlag{}
+	fs.Var(meta, "meta", "metadata label `key=value`, repeatable")
+	var to stringsFlag
+	fs.Var(&to, "to", "encrypt the output to the keys of `user`, repeatable")
+	fs.Parse(args)
+
+	args = fs.Args()
+	if len(args) > 1 && args[1] == "--" {
+		args = append(args[:1:1], args[2:]...)
+	}
+	if len(args) < 2 {
+		return errors.New("expected a clip id and a command")
+	}
+	id, argv := args[0], args[1:]
+
+	// Both streams share one writer so that their lines stay in order.
+	out := &tailBuffer{max: maxOutput}
+	combined := io.MultiWriter(os.Stdout, out)
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, combined, combined
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		for sig := range sigs {
+			if sig == syscall.SIGTERM {
+				cmd.Process.Signal(sig)
+			}
+		}
+	}()
+	err := cmd.Wait()
+	duration := time.Since(start)
+
+	code := 0
+	var exit *exec.ExitError
+	switch {
+	case errors.As(err, &exit):
+		code = max(exit.ExitCode(), 1)
+	case err != nil:
+		return err
+	}
+
+	output := out.Bytes()
+	host, _ := os.Hostname()
+	dir, _ := os.Getwd()
+	meta["run.command"] = metaValue(commandLine(argv))
+	meta["run.exit-code"] = strconv.Itoa(code)
+	meta["run.duration"] = duration.Round(time.Millisecond).String()
+	meta["run.started"] = start.UTC().Format(time.RFC3339)
+	meta["run.host"] = metaValue(host)
+	meta["run.dir"] = metaValue(dir)
+	if out.truncated {
+		meta["run.truncated"] = "true"
+	}
+
+	value := strings.ToValidUTF8(string(output), "�")
+	if value == "" {
+		value = "(no output)\n"
+	}
+
+	opts := pushOptions{meta: meta, ttl: *ttl, sign: !*noSign}
+	kind := "text"
+	if len(to) > 0 {
+		recipients, err := c.recipientsOf(to)
+		if err != nil {
+			return err
+		}
+		if value, err = encrypt(value, recipients); err != nil {
+			return err
+		}
+		kind, opts.recipients = "age", to
+	}
+	if err := c.push(id, kind, value, opts); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "exit %d after %s\n", code, meta["run.duration"])
+	if code != 0 {
+		os.Exit(code)
+	}
+	return nil
+}
+
+// commandLine quotes the arguments that need it, for display.
+func commandLine(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		if arg == "" || strings.ContainsFunc(arg, func(r rune) bool {
+			return unicode.IsSpace(r) || strings.ContainsRune(`"'\$`+"`", r)
+		}) {
+			arg = strconv.Quote(arg)
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
+// metaValue fits s into a metadata label, which is at most 256 bytes and
+// free of control characters.
+func metaValue(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, s)
+	if len(s) > 256 {
+		s = strings.ToValidUTF8(s[:253], "") + "..."
+	}
+	return s
+}
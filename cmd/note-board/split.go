@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"note-board/internal/shamir"
+)
+
+// runSplit splits a secret into one share per -to user, any -k of which
+// reconstruct it. Share i is stored as ID/share-i, a burn-after-read clip
+// only its holder can read, encrypted to the holder unless -plain is set.
+func runSplit(c *client, args []string) error {
+	fs := flag.NewFlagSet("split", flag.ExitOnError)
+	k := fs.Int("k", 0, "number of shares needed to reconstruct the secret")
+	ttl := fs.String("ttl", "", "time to live of the shares, such as 72h")
+	plain := fs.Bool("plain", false, "do not encrypt the shares to their holders")
+	noSign := fs.Bool("no-sign", false, "do not sign the shares")
+	var holders stringsFlag
+	fs.Var(&holders, "to", "give a share to `user`, repeatable")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		return errors.New("missing clip id")
+	}
+	id := fs.Arg(0)
+	secret, err := readInput(fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+
+	shares, err := shamir.Split(secret, len(holders), *k)
+	if err != nil {
+		return fmt.Errorf("%d shares with threshold %d: %w", len(holders), *k, err)
+	}
+
+	for i, holder := range holders {
+		share := shares[i]
+		opts := pushOptions{
+			meta: map[string]string{
+				"shamir-set":       share.Set,
+				"shamir-threshold": strconv.Itoa(share.Threshold),
+				"shamir-shares":    strconv.Itoa(len(shares)),
+			},
+			readers: []string{holder},
+			ttl:     *ttl,
+			burn:    true,
+			sign:    !*noSign,
+		}
+
+		kind, value := "text", share.String()+"\n"
+		if !*plain {
+			recipients, err := c.recipientsOf([]string{holder})
+			if err != nil {
+				return err
+			}
+			if value, err = encrypt(value, recipients); err != nil {
+				return err
+			}
+			kind, opts.recipients = "age", []string{holder}
+		}
+
+		if err := c.push(fmt.Sprintf("%s/share-%d", id, share.X), kind, value, opts); err != nil {
+			return fmt.Errorf("share for %s: %w", holder, err)
+		}
+	}
+	return nil
+}
+
+// runCombine reads shares, one per line, from the files named in args or
+// stdin and prints the secret they reconstruct.
+func runCombine(c *client, args []string) error {
+	if len(args) == 0 {
+		args = []string{"-"}
+	}
+
+	var shares []shamir.Share
+	for _, name := range args {
+		f := os.Stdin
+		if name != "-" {
+			var err error
+			if f, err = os.Open(name); err != nil {
+				return err
+			}
+			defer f.Close()
+		}
+
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			line := strings.TrimSpace(sc.Text())
+			if line == "" {
+				continue
+			}
+			share, err := shamir.Parse(line)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			shares = append(shares, share)
+		}
+		if err := sc.Err(); err != nil {
+			return err
+		}
+	}
+
+	secret, err := shamir.Combine(shares)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(secret)
+	return err
+}
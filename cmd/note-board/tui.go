@@ -0,0 +1,1033 @@
+//go:build unix
+
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// The tui command browses the board in the terminal. The clips are shown as
+// a tree of namespaces and boards split on "/", with a preview of the
+// selected clip. The listing follows the server's change stream, so it stays
+// current while other clients write.
+
+const tuiHelp = "↑↓ move  → open  ← up  / search  H history  c copy  y copy id  e extend  D delete  v reveal  r reload  q quit"
+
+type tuiClip struct {
+	ID      string            `json:"id"`
+	Type    string            `json:"type"`
+	Size    int               `json:"size"`
+	Meta    map[string]string `json:"meta"`
+	Readers []string          `json:"readers"`
+	Burn    bool              `json:"burnAfterRead"`
+	Version uint64            `json:"version"`
+	Expires time.Time         `json:"expires"`
+}
+
+// tuiRow is a row of the listing: a clip, or a namespace or board holding
+// count clips when prefix is set.
+type tuiRow struct {
+	name   string
+	prefix string
+	count  int
+	clip   *tuiClip
+}
+
+type tuiVersion struct {
+	Version uint64    `json:"version"`
+	Type    string    `json:"type"`
+	Value   string    `json:"value"`
+	Owner   string    `json:"owner"`
+	Time    time.Time `json:"time"`
+}
+
+type tuiPreview struct {
+	id      string
+	version uint64
+	// value is the clip as read, decrypted for age clips.
+	value  string
+	lang   string
+	info   []styledLine
+	lines  []styledLine
+	loaded bool
+	gone   bool
+	err    string
+	scroll int
+}
+
+type tuiPrompt struct {
+	label  string
+	input  string
+	change func(input string)
+	done   func(input string)
+}
+
+// tuiEvent is an event of the change stream.
+type tuiEvent struct {
+	kind   string
+	change struct {
+		Op string `json:"op"`
+		ID string `json:"id"`
+	}
+	live bool
+}
+
+type tui struct {
+	c      *client
+	root   string
+	out    *os.File
+	width  int
+	height int
+
+	clips  []tuiClip
+	prefix string
+	filter string
+	rows   []tuiRow
+	cursor int
+	top    int
+
+	preview tuiPreview
+	reveal  bool
+
+	// history is shown instead of the preview when not nil.
+	history  []tuiVersion
+	hcursor  int
+	diff     []styledLine
+	dscroll  int
+	prompt   *tuiPrompt
+	status   string
+	live     bool
+	quitting bool
+}
+
+func runTUI(c *client, args []string) error {
+	fs := flag.NewFlagSet("tui", flag.ExitOnError)
+	prefix := fs.String("prefix", "", "only browse the clips below `prefix`")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		return errors.New("tui takes no arguments")
+	}
+
+	stdin, stdout := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !term.IsTerminal(stdin) || !term.IsTerminal(stdout) {
+		return errors.New("tui needs a terminal")
+	}
+
+	t := &tui{c: c, root: *prefix, prefix: *prefix, out: os.Stdout}
+	if err := t.load(); err != nil {
+		return err
+	}
+
+	state, err := term.MakeRaw(stdin)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(stdin, state)
+	t.out.WriteString("\x1b[?1049h\x1b[?25l")
+	defer t.out.WriteString("\x1b[?25h\x1b[?1049l")
+
+	resized := make(chan os.Signal, 1)
+	signal.Notify(resized, syscall.SIGWINCH)
+	defer signal.Stop(resized)
+
+	keys := make(chan string, 16)
+	go readKeys(os.Stdin, keys)
+	events := make(chan tuiEvent, 64)
+	go c.watch(t.root, events)
+
+	// Changes often come in bursts; the listing is reloaded once they
+	// settle.
+	reload := time.NewTimer(0)
+	<-reload.C
+	changed := make(map[string]bool)
+
+	t.resize()
+	t.selectRow()
+	for !t.quitting {
+		t.draw()
+		select {
+		case <-resized:
+			t.resize()
+		case k, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			t.key(k)
+		case ev := <-events:
+			switch ev.kind {
+			case "status":
+				// Changes may have been missed while offline.
+				if ev.live && !t.live {
+					reload.Reset(0)
+				}
+				t.live = ev.live
+			case "reset":
+				reload.Reset(0)
+			case "change":
+				changed[ev.change.ID] = true
+				reload.Reset(150 * time.Millisecond)
+			}
+		case <-reload.C:
+			t.reload()
+			if changed[t.preview.id] {
+				t.refreshPreview()
+			}
+			clear(changed)
+		}
+	}
+	return nil
+}
+
+func (t *tui) resize() {
+	w, h, err := term.GetSize(int(t.out.Fd()))
+	if err != nil || w <= 0 || h <= 0 {
+		w, h = 80, 24
+	}
+	t.width, t.height = w, h
+}
+
+// load fetches the listing of the clips below the root.
+func (t *tui) load() error {
+	var clips []tuiClip
+	if err := t.c.getJSON("/clips", url.Values{"prefix": {t.root}}, &clips); err != nil {
+		return err
+	}
+	t.clips = clips
+	t.buildRows()
+	return nil
+}
+
+func (t *tui) reload() {
+	if err := t.load(); err != nil {
+		t.status = "reload: " + err.Error()
+		return
+	}
+	if row := t.current(); row == nil || row.clip == nil || row.clip.ID != t.preview.id {
+		t.selectRow()
+	}
+}
+
+// buildRows lists the namespaces, boards and clips directly below the
+// current prefix or, while searching, every clip below it that matches.
+func (t *tui) buildRows() {
+	selected := ""
+	if t.cursor < len(t.rows) {
+		selected = t.rows[t.cursor].name
+	}
+
+	t.rows = t.rows[:0]
+	groups := make(map[string]int)
+	filter := strings.ToLower(t.filter)
+	for i := range t.clips {
+		cl := &t.clips[i]
+		rest, ok := strings.CutPrefix(cl.ID, t.prefix)
+		if !ok {
+			continue
+		}
+		if filter != "" {
+			if matchesFilter(cl, filter) {
+				t.rows = append(t.rows, tuiRow{name: rest, clip: cl})
+			}
+			continue
+		}
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			name := rest[:i+1]
+			if _, seen := groups[name]; !seen {
+				groups[name] = len(t.rows)
+				t.rows = append(t.rows, tuiRow{name: name, prefix: t.prefix + name})
+			}
+			t.rows[groups[name]].count++
+			continue
+		}
+		t.rows = append(t.rows, tuiRow{name: rest, clip: cl})
+	}
+	sort.SliceStable(t.rows, func(i, j int) bool {
+		a, b := t.rows[i], t.rows[j]
+		if (a.clip == nil) != (b.clip == nil) {
+			return a.clip == nil
+		}
+		return a.name < b.name
+	})
+
+	for i, row := range t.rows {
+		if row.name == selected {
+			t.cursor = i
+		}
+	}
+	t.clampCursor()
+}
+
+// matchesFilter reports whether the id, type or a label of cl contains the
+// lower case filter.
+func matchesFilter(cl *tuiClip, filter string) bool {
+	if strings.Contains(strings.ToLower(cl.ID), filter) || strings.Contains(cl.Type, filter) {
+		return true
+	}
+	for k, v := range cl.Meta {
+		if strings.Contains(strings.ToLower(k+"="+v), filter) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *tui) clampCursor() {
+	t.cursor = max(0, min(t.cursor, len(t.rows)-1))
+}
+
+func (t *tui) current() *tuiRow {
+	if t.cursor < len(t.rows) {
+		return &t.rows[t.cursor]
+	}
+	return nil
+}
+
+// selectRow updates the preview for the row under the cursor.
+func (t *tui) selectRow() {
+	t.history = nil
+	row := t.current()
+	if row == nil || row.clip == nil {
+		t.preview = tuiPreview{}
+		return
+	}
+	if row.clip.ID != t.preview.id || row.clip.Version != t.preview.version {
+		t.preview = tuiPreview{id: row.clip.ID}
+		if !row.clip.Burn {
+			t.fetchPreview()
+		}
+	}
+}
+
+func (t *tui) refreshPreview() {
+	row := t.current()
+	if row == nil || row.clip == nil || row.clip.ID != t.preview.id {
+		if t.preview.id != "" && t.findClip(t.preview.id) == nil {
+			t.preview.gone = true
+		}
+		return
+	}
+	scroll := t.preview.scroll
+	t.preview = tuiPreview{id: row.clip.ID, scroll: scroll}
+	if !row.clip.Burn {
+		t.fetchPreview()
+	}
+	if t.history != nil {
+		t.openHistory()
+	}
+}
+
+func (t *tui) findClip(id string) *tuiClip {
+	for i := range t.clips {
+		if t.clips[i].ID == id {
+			return &t.clips[i]
+		}
+	}
+	return nil
+}
+
+// fetchPreview reads the previewed clip. Burn-after-read clips are only
+// read on request, as reading deletes them.
+func (t *tui) fetchPreview() {
+	p := &t.preview
+	var cl clip
+	err := t.c.getJSON("/", url.Values{"id": {p.id}}, &cl)
+	if err != nil {
+		p.err = err.Error()
+		if isNotFound(err) {
+			p.gone = true
+		}
+		return
+	}
+
+	p.loaded = true
+	p.version = cl.Version
+	p.value = cl.Value
+	p.lang = language(cl.Type, cl.ID, cl.Meta)
+	if cl.Type == "age" {
+		if plain, err := decrypt(cl.Value); err == nil {
+			p.value = plain
+			p.lang = language("text", cl.ID, cl.Meta)
+		}
+	}
+
+	info := fmt.Sprintf("%s  v%d  %d bytes", cl.Type, cl.Version, len(cl.Value))
+	if meta := t.findClip(cl.ID); meta != nil && !meta.Expires.IsZero() {
+		info += "  expires in " + shortDuration(time.Until(meta.Expires))
+	}
+	sig := styledLine{{styleGray, "unsigned"}}
+	switch cl.Signature.Status {
+	case "verified":
+		sig = styledLine{{styleGreen, "signed by " + cl.Signature.Signer}}
+	case "unsigned", "":
+	default:
+		sig = styledLine{{styleRed, "signature " + cl.Signature.Status}}
+	}
+	p.info = []styledLine{{{styleBold, cl.ID}}, {{styleGray, info + "  "}, sig[0]}}
+	if len(cl.Meta) > 0 {
+		labels := make([]string, 0, len(cl.Meta))
+		for k, v := range cl.Meta {
+			labels = append(labels, k+"="+v)
+		}
+		sort.Strings(labels)
+		p.info = append(p.info, styledLine{{styleCyan, strings.Join(labels, "  ")}})
+	}
+	t.highlightPreview()
+}
+
+func (t *tui) highlightPreview() {
+	p := &t.preview
+	value := p.value
+	if p.lang == "env" && !t.reveal {
+		value = maskEnv(value)
+	}
+	p.lines = highlight(p.lang, value)
+}
+
+// maskEnv hides the values of the assignments in an env clip.
+func maskEnv(value string) string {
+	lines := strings.Split(value, "\n")
+	for i, l := range lines {
+		if strings.HasPrefix(strings.TrimSpace(l), "#") {
+			continue
+		}
+		if name, _, ok := strings.Cut(l, "="); ok {
+			lines[i] = name + "=••••••"
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (t *tui) openHistory() {
+	id := t.preview.id
+	var versions []tuiVersion
+	if err := t.c.getJSON("/history", url.Values{"id": {id}}, &versions); err != nil {
+		t.status = "history: " + err.Error()
+		return
+	}
+	if len(versions) == 0 {
+		t.status = "no history kept for " + id
+		return
+	}
+	t.history = versions
+	t.hcursor = min(t.hcursor, len(versions)-1)
+	t.showDiff()
+}
+
+// showDiff diffs the selected version of the history against the one before.
+func (t *tui) showDiff() {
+	t.dscroll = 0
+	v := t.history[t.hcursor]
+	old := ""
+	if t.hcursor+1 < len(t.history) {
+		old = t.history[t.hcursor+1].Value
+	}
+	t.diff = nil
+	for _, l := range unifiedDiff(old, v.Value, 3) {
+		style := styleNone
+		switch l[0] {
+		case '+':
+			style = styleGreen
+		case '-':
+			style = styleRed
+		case '@':
+			style = styleCyan
+		}
+		t.diff = append(t.diff, styledLine{{style, l}})
+	}
+	if t.diff == nil {
+		t.diff = []styledLine{{{styleGray, "no change to the value"}}}
+	}
+}
+
+func (t *tui) key(k string) {
+	t.status = ""
+	if p := t.prompt; p != nil {
+		switch k {
+		case "esc", "ctrl-c":
+			t.prompt = nil
+			if p.change != nil {
+				p.change("")
+			}
+		case "enter":
+			t.prompt = nil
+			p.done(p.input)
+		case "backspace":
+			if p.input != "" {
+				_, size := utf8.DecodeLastRuneInString(p.input)
+				p.input = p.input[:len(p.input)-size]
+			}
+		default:
+			if utf8.RuneCountInString(k) == 1 && k >= " " {
+				p.input += k
+			}
+		}
+		if p.change != nil && t.prompt != nil {
+			p.change(p.input)
+		}
+		return
+	}
+
+	if t.history != nil && t.historyKey(k) {
+		return
+	}
+
+	body := t.height - 2
+	switch k {
+	case "q", "ctrl-c":
+		t.quitting = true
+	case "up", "k":
+		t.move(-1)
+	case "down", "j":
+		t.move(1)
+	case "home":
+		t.move(-len(t.rows))
+	case "end":
+		t.move(len(t.rows))
+	case "pgup":
+		t.scroll(-body / 2)
+	case "pgdn", " ":
+		t.scroll(body / 2)
+	case "K":
+		t.scroll(-1)
+	case "J":
+		t.scroll(1)
+	case "enter", "right", "l":
+		t.open()
+	case "left", "h", "backspace":
+		t.up()
+	case "esc":
+		if t.filter != "" {
+			t.setFilter("")
+		}
+	case "/":
+		t.search()
+	case "r":
+		t.reload()
+		t.refreshPreview()
+	case "v":
+		t.reveal = !t.reveal
+		t.highlightPreview()
+	case "H":
+		if t.preview.loaded {
+			t.hcursor = 0
+			t.openHistory()
+		}
+	case "c":
+		if !t.preview.loaded {
+			t.status = "nothing to copy"
+			return
+		}
+		t.copy(t.preview.value)
+		t.status = fmt.Sprintf("copied %s to the clipboard", t.preview.id)
+	case "y":
+		if row := t.current(); row != nil {
+			id := row.prefix
+			if row.clip != nil {
+				id = row.clip.ID
+			}
+			t.copy(id)
+			t.status = "copied " + id
+		}
+	case "e":
+		t.extend()
+	case "D", "delete":
+		t.delete()
+	}
+}
+
+// historyKey handles the keys of the history view, reporting whether k was
+// one of them.
+func (t *tui) historyKey(k string) bool {
+	switch k {
+	case "up", "k":
+		if t.hcursor > 0 {
+			t.hcursor--
+			t.showDiff()
+		}
+	case "down", "j":
+		if t.hcursor < len(t.history)-1 {
+			t.hcursor++
+			t.showDiff()
+		}
+	case "pgup":
+		t.dscroll = max(0, t.dscroll-(t.height-2)/2)
+	case "pgdn", " ":
+		t.dscroll = max(0, min(t.dscroll+(t.height-2)/2, len(t.diff)-1))
+	case "esc", "H", "left", "h":
+		t.history = nil
+	default:
+		return false
+	}
+	return true
+}
+
+func (t *tui) move(n int) {
+	t.cursor += n
+	t.clampCursor()
+	t.selectRow()
+}
+
+func (t *tui) scroll(n int) {
+	p := &t.preview
+	p.scroll = max(0, min(p.scroll+n, len(p.lines)-1))
+}
+
+func (t *tui) open() {
+	row := t.current()
+	switch {
+	case row == nil:
+	case row.clip == nil:
+		t.prefix = row.prefix
+		t.cursor, t.top = 0, 0
+		t.buildRows()
+		t.selectRow()
+	case row.clip.Burn && !t.preview.loaded:
+		t.ask("reading "+row.clip.ID+" deletes it, read it? (y/N) ", func(answer string) {
+			if strings.EqualFold(answer, "y") {
+				t.fetchPreview()
+			}
+		})
+	}
+}
+
+func (t *tui) up() {
+	if t.filter != "" {
+		t.setFilter("")
+		return
+	}
+	if t.prefix == t.root {
+		return
+	}
+	parent := strings.TrimSuffix(t.prefix, "/")
+	if i := strings.LastIndexByte(parent, '/'); i >= len(t.root) {
+		parent = parent[:i+1]
+	} else {
+		parent = t.root
+	}
+	name := t.prefix[len(parent):]
+	t.prefix = parent
+	t.buildRows()
+	for i, row := range t.rows {
+		if row.name == name {
+			t.cursor = i
+		}
+	}
+	t.selectRow()
+}
+
+func (t *tui) search() {
+	t.prompt = &tuiPrompt{
+		label:  "/",
+		input:  t.filter,
+		change: t.setFilter,
+		done:   func(string) {},
+	}
+}
+
+func (t *tui) setFilter(filter string) {
+	t.filter = filter
+	t.cursor, t.top = 0, 0
+	t.buildRows()
+	t.selectRow()
+}
+
+func (t *tui) ask(label string, done func(string)) {
+	t.prompt = &tuiPrompt{label: label, done: done}
+}
+
+// copy puts s on the clipboard of the terminal with an OSC 52 sequence,
+// which also works over ssh.
+func (t *tui) copy(s string) {
+	fmt.Fprintf(t.out, "\x1b]52;c;%s\x07", base64.StdEncoding.EncodeToString([]byte(s)))
+}
+
+func (t *tui) extend() {
+	row := t.current()
+	if row == nil || row.clip == nil {
+		return
+	}
+	cl := *row.clip
+	t.ask("extend "+cl.ID+" to expire in (24h): ", func(input string) {
+		if input == "" {
+			input = "24h"
+		}
+		header := http.Header{"If-Match": {strconv.Quote(strconv.FormatUint(cl.Version, 10))}}
+		resp, err := t.c.request(http.MethodPost, "/", url.Values{"id": {cl.ID}, "extend": {input}}, nil, header)
+		if err != nil {
+			t.status = "extend: " + err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		var extended struct {
+			Expires time.Time `json:"expires"`
+		}
+		json.NewDecoder(resp.Body).Decode(&extended)
+		t.status = fmt.Sprintf("%s now expires at %s", cl.ID, extended.Expires.Local().Format(time.DateTime))
+		t.reload()
+	})
+}
+
+func (t *tui) delete() {
+	row := t.current()
+	if row == nil || row.clip == nil {
+		return
+	}
+	id := row.clip.ID
+	t.ask("delete "+id+"? (y/N) ", func(answer string) {
+		if !strings.EqualFold(answer, "y") {
+			return
+		}
+		resp, err := t.c.request(http.MethodDelete, "/", url.Values{"id": {id}}, nil, nil)
+		if err != nil {
+			t.status = "delete: " + err.Error()
+			return
+		}
+		resp.Body.Close()
+		t.status = "deleted " + id
+		t.reload()
+		t.selectRow()
+	})
+}
+
+func (t *tui) draw() {
+	w, h := t.width, t.height
+	if w < 20 || h < 5 {
+		return
+	}
+	body := h - 2
+	left := max(24, min(w*2/5, 60))
+	right := w - left - 1
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+
+	state := styledLine{{styleRed, " offline "}}
+	if t.live {
+		state = styledLine{{styleGreen, " live "}}
+	}
+	where := "/" + t.prefix
+	if t.filter != "" {
+		where += "  search: " + t.filter
+	}
+	header := styledLine{{styleReverse + styleBold, " note-board "}, {styleReverse, " " + t.c.base + "  " + where + " "}}
+	header = append(header, span{styleReverse, strings.Repeat(" ", max(0, w-lineWidth(header)-lineWidth(state)))})
+	b.WriteString(render(append(header, state...), w))
+
+	if t.cursor < t.top {
+		t.top = t.cursor
+	}
+	if t.cursor >= t.top+body {
+		t.top = t.cursor - body + 1
+	}
+	pane := t.previewLines(right, body)
+	for y := 0; y < body; y++ {
+		fmt.Fprintf(&b, "\x1b[%d;1H", y+2)
+		b.WriteString(render(t.rowLine(t.top+y, left), left))
+		b.WriteString(styleGray + "│" + styleReset)
+		var line styledLine
+		if y < len(pane) {
+			line = pane[y]
+		}
+		b.WriteString(render(line, right))
+	}
+
+	fmt.Fprintf(&b, "\x1b[%d;1H", h)
+	switch {
+	case t.prompt != nil:
+		b.WriteString(render(styledLine{{styleBold, t.prompt.label}, {styleNone, t.prompt.input + "█"}}, w))
+	case t.status != "":
+		b.WriteString(render(styledLine{{styleYellow, t.status}}, w))
+	default:
+		b.WriteString(render(styledLine{{styleGray, tuiHelp}}, w))
+	}
+	t.out.WriteString(b.String())
+}
+
+func (t *tui) rowLine(i, width int) styledLine {
+	if i >= len(t.rows) {
+		if i == 0 {
+			return styledLine{{styleGray, " no clips"}}
+		}
+		return nil
+	}
+	row := t.rows[i]
+	var line styledLine
+	if row.clip == nil {
+		kind := "board"
+		if strings.Count(row.prefix, "/") == 1 {
+			kind = "namespace"
+		}
+		line = styledLine{{styleBlue + styleBold, " ▸ " + row.name}, {styleGray, fmt.Sprintf("  %s, %s", kind, clipCount(row.count))}}
+	} else {
+		style := styleNone
+		if row.clip.Burn {
+			style = styleRed
+		}
+		tag := row.clip.Type
+		if len(row.clip.Readers) > 0 {
+			tag += ", readers"
+		}
+		name := "   " + row.name
+		pad := max(1, width-utf8.RuneCountInString(name)-utf8.RuneCountInString(tag)-1)
+		line = styledLine{{style, name}, {styleGray, strings.Repeat(" ", pad) + tag + " "}}
+	}
+	if i == t.cursor {
+		for j := range line {
+			line[j].style = styleReverse + line[j].style
+		}
+		line = append(line, span{styleReverse, strings.Repeat(" ", max(0, width-lineWidth(line)))})
+	}
+	return line
+}
+
+// previewLines returns the content of the right pane.
+func (t *tui) previewLines(width, height int) []styledLine {
+	if t.history != nil {
+		return t.historyLines(width, height)
+	}
+
+	row := t.current()
+	if row == nil {
+		return nil
+	}
+	if row.clip == nil {
+		lines := []styledLine{{{styleBold, row.prefix}}, {{styleGray, clipCount(row.count)}}, nil}
+		for _, cl := range t.clips {
+			if strings.HasPrefix(cl.ID, row.prefix) && len(lines) < height {
+				lines = append(lines, styledLine{{styleNone, "  " + strings.TrimPrefix(cl.ID, row.prefix)}, {styleGray, "  " + cl.Type}})
+			}
+		}
+		return lines
+	}
+
+	p := &t.preview
+	switch {
+	case p.gone:
+		return []styledLine{{{styleBold, p.id}}, {{styleRed, "deleted or expired"}}}
+	case p.err != "":
+		return []styledLine{{{styleBold, p.id}}, {{styleRed, p.err}}}
+	case !p.loaded && row.clip.Burn:
+		return []styledLine{{{styleBold, row.clip.ID}}, {{styleRed, "burn after read: reading it deletes it, press enter to read"}}}
+	case !p.loaded:
+		return nil
+	}
+
+	lines := append([]styledLine(nil), p.info...)
+	lines = append(lines, styledLine{{styleGray, strings.Repeat("─", width)}})
+	for _, l := range p.lines[min(p.scroll, len(p.lines)):] {
+		if len(lines) >= height {
+			break
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+func (t *tui) historyLines(width, height int) []styledLine {
+	lines := []styledLine{{{styleBold, t.preview.id + " history"}}}
+	for i, v := range t.history {
+		text := fmt.Sprintf(" v%-6d %s  %-12s %d bytes", v.Version, v.Time.Local().Format(time.DateTime), v.Owner, len(v.Value))
+		if i == 0 {
+			text += "  current"
+		}
+		style := styleNone
+		if i == t.hcursor {
+			style = styleReverse
+		}
+		lines = append(lines, styledLine{{style, text}})
+		if len(lines) > height/3 {
+			break
+		}
+	}
+	lines = append(lines, styledLine{{styleGray, strings.Repeat("─", width)}})
+	for _, l := range t.diff[min(t.dscroll, len(t.diff)):] {
+		if len(lines) >= height {
+			break
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+func clipCount(n int) string {
+	if n == 1 {
+		return "1 clip"
+	}
+	return fmt.Sprintf("%d clips", n)
+}
+
+// shortDuration formats d to the minute, as in 3h05m.
+func shortDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	if d < time.Hour {
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+	return fmt.Sprintf("%dh%02dm", d/time.Hour, d%time.Hour/time.Minute)
+}
+
+func lineWidth(line styledLine) int {
+	n := 0
+	for _, s := range line {
+		n += utf8.RuneCountInString(s.text)
+	}
+	return n
+}
+
+// render writes line clipped and padded to width columns. Control
+// characters are replaced and tabs expanded so that the layout holds.
+func render(line styledLine, width int) string {
+	var b strings.Builder
+	col := 0
+	for _, s := range line {
+		if col >= width {
+			break
+		}
+		b.WriteString(s.style)
+		for _, r := range s.text {
+			if r == '\t' {
+				n := min(4-col%4, width-col)
+				b.WriteString(strings.Repeat(" ", n))
+				col += n
+			} else {
+				if r < ' ' || r == 0x7f {
+					r = '·'
+				}
+				b.WriteRune(r)
+				col++
+			}
+			if col >= width {
+				break
+			}
+		}
+		if s.style != styleNone {
+			b.WriteString(styleReset)
+		}
+	}
+	b.WriteString(strings.Repeat(" ", max(0, width-col)))
+	return b.String()
+}
+
+// readKeys decodes the keys typed on in, sending the names of special keys
+// and the text of others.
+func readKeys(in *os.File, keys chan<- string) {
+	defer close(keys)
+	names := map[string]string{
+		"\x1b[A": "up", "\x1b[B": "down", "\x1b[C": "right", "\x1b[D": "left",
+		"\x1bOA": "up", "\x1bOB": "down", "\x1bOC": "right", "\x1bOD": "left",
+		"\x1b[H": "home", "\x1b[F": "end", "\x1bOH": "home", "\x1bOF": "end",
+		"\x1b[1~": "home", "\x1b[4~": "end", "\x1b[3~": "delete",
+		"\x1b[5~": "pgup", "\x1b[6~": "pgdn",
+	}
+	buf := make([]byte, 256)
+	for {
+		n, err := in.Read(buf)
+		if err != nil {
+			return
+		}
+		for s := string(buf[:n]); s != ""; {
+			var k string
+			switch c := s[0]; {
+			case c == 0x1b && len(s) == 1:
+				k, s = "esc", ""
+			case c == 0x1b:
+				end := 2
+				if s[1] == '[' || s[1] == 'O' {
+					for end < len(s) && (s[end] < 0x40 || s[end] > 0x7e) {
+						end++
+					}
+					end = min(end+1, len(s))
+				}
+				k, s = names[s[:end]], s[end:]
+			case c == '\r' || c == '\n':
+				k, s = "enter", s[1:]
+			case c == 0x7f || c == 0x08:
+				k, s = "backspace", s[1:]
+			case c == 0x03:
+				k, s = "ctrl-c", s[1:]
+			case c < ' ':
+				s = s[1:]
+			default:
+				_, size := utf8.DecodeRuneInString(s)
+				k, s = s[:size], s[size:]
+			}
+			if k != "" {
+				keys <- k
+			}
+		}
+	}
+}
+
+// watch follows the change stream of the clips below prefix, reconnecting
+// when it drops.
+func (c *client) watch(prefix string, events chan<- tuiEvent) {
+	last := ""
+	for {
+		err := c.stream(prefix, &last, events)
+		events <- tuiEvent{kind: "status", live: false}
+		if err != nil && !unreachable(err) {
+			return
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+func (c *client) stream(prefix string, last *string, events chan<- tuiEvent) error {
+	header := http.Header{"Accept": {"text/event-stream"}}
+	if *last != "" {
+		header.Set("Last-Event-ID", *last)
+	}
+	resp, err := c.requestStream(http.MethodGet, "/changes", url.Values{"prefix": {prefix}}, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	sc := bufio.NewScanner(resp.Body)
+	var kind, data string
+	for sc.Scan() {
+		line := sc.Text()
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			*last = value
+		case "event":
+			kind = value
+		case "data":
+			data = value
+		case "":
+			if line != "" {
+				continue
+			}
+			ev := tuiEvent{kind: kind}
+			switch kind {
+			case "ready":
+				ev = tuiEvent{kind: "status", live: true}
+			case "change":
+				json.Unmarshal([]byte(data), &ev.change)
+			}
+			if kind != "" {
+				events <- ev
+			}
+			kind, data = "", ""
+		}
+	}
+	return sc.Err()
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+func runTUI(c *client, args []string) error {
+	return errors.New("tui is only supported on Unix systems")
+}
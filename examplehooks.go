@@ -0,0 +1,61 @@
+//go:build examplehooks
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// Example hooks compiled in with `go build -tags examplehooks`. They show how
+// an organization can enforce its own rules without patching the handlers.
+
+func init() {
+	if pattern := os.Getenv("NOTE_BOARD_TICKET_PATTERN"); pattern != "" {
+		RegisterHook(&ticketPrefixHook{re: regexp.MustCompile(pattern)})
+	}
+	RegisterHook(jsonFormatHook{})
+}
+
+// ticketPrefixHook rejects writes whose id does not start with a ticket
+// reference such as "OPS-123/".
+type ticketPrefixHook struct {
+	re *regexp.Regexp
+}
+
+func (h *ticketPrefixHook) Name() string { return "ticket-prefix" }
+
+func (h *ticketPrefixHook) Before(hc *HookContext) error {
+	if hc.Op == HookSet && !h.re.MatchString(hc.ID) {
+		return fmt.Errorf("id must match %s", h.re)
+	}
+	return nil
+}
+
+func (h *ticketPrefixHook) After(hc *HookContext) {}
+
+// jsonFormatHook pretty-prints values that are valid JSON.
+type jsonFormatHook struct{}
+
+func (jsonFormatHook) Name() string { return "json-format" }
+
+func (jsonFormatHook) Before(hc *HookContext) error {
+	if hc.Op != HookSet || !json.Valid([]byte(hc.Value)) {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(hc.Value), "", "  "); err != nil {
+		return nil
+	}
+	if buf.String() != hc.Value {
+		hc.Value = buf.String()
+		hc.Annotate("Formatted", "json")
+	}
+	return nil
+}
+
+func (jsonFormatHook) After(hc *HookContext) {}
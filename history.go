@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// The store keeps the last values of each clip, NOTE_BOARD_HISTORY of them,
+// so that /history?id= can show how it changed. The history goes with the
+// clip when it is deleted or expires. Log clips, which only grow, and
+// burn-after-read clips, whose value must not outlive the first read, have
+// none.
+
+type clipVersion struct {
+	Version uint64    `json:"version"`
+	Type    string    `json:"type"`
+	Value   string    `json:"value"`
+	Owner   string    `json:"owner,omitempty"`
+	Time    time.Time `json:"time"`
+
+	readers []string
+}
+
+type clipHistory struct {
+	depth int
+
+	mu       sync.Mutex
+	versions map[string][]clipVersion
+}
+
+func newClipHistory(depth int) *clipHistory {
+	return &clipHistory{depth: depth, versions: make(map[string][]clipVersion)}
+}
+
+func (h *clipHistory) add(id string, version uint64, val storedValue) {
+	if h.depth <= 0 || val.kind == clipLog || val.burn {
+		h.drop(id)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	versions := h.versions[id]
+	if n := len(versions); n > 0 && versions[n-1].Version >= version {
+		return
+	}
+	if len(versions) == h.depth {
+		versions = append(versions[:0], versions[1:]...)
+	}
+	h.versions[id] = append(versions, clipVersion{
+		Version: version,
+		Type:    val.kind,
+		Value:   val.value,
+		Owner:   val.owner,
+		Time:    val.timestamp,
+		readers: val.readers,
+	})
+}
+
+func (h *clipHistory) drop(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.versions, id)
+}
+
+// ownedBy returns the kept versions written by owner, by clip id.
+func (h *clipHistory) ownedBy(owner string) map[string][]clipVersion {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	owned := make(map[string][]clipVersion)
+	for id, versions := range h.versions {
+		for _, v := range versions {
+			if v.Owner == owner {
+				owned[id] = append(owned[id], v)
+			}
+		}
+	}
+	return owned
+}
+
+// forget drops the versions written by owner and returns how many there
+// were.
+func (h *clipHistory) forget(owner string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n := 0
+	for id, versions := range h.versions {
+		kept := slices.DeleteFunc(versions, func(v clipVersion) bool { return v.Owner == owner })
+		n += len(versions) - len(kept)
+		if len(kept) == 0 {
+			delete(h.versions, id)
+		} else {
+			h.versions[id] = kept
+		}
+	}
+	return n
+}
+
+// get returns the history of id, newest first.
+func (h *clipHistory) get(id string) []clipVersion {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	versions := slices.Clone(h.versions[id])
+	slices.Reverse(versions)
+	return versions
+}
+
+// listHistory serves the kept versions of the clip ?id=, newest first,
+// leaving out those restricted to readers the client is not among.
+func (b *board) listHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := b.clipID(w, r)
+	if !ok {
+		return
+	}
+
+	e, exists := b.store.Lookup(id)
+	if !exists {
+		http.Error(w, "not found or expired", http.StatusNotFound)
+		return
+	}
+	if !canRead(w, r, e.Value) {
+		return
+	}
+
+	user, _ := sessionUser(r)
+	versions := []clipVersion{}
+	for _, v := range b.store.history.get(id) {
+		if len(v.readers) == 0 || slices.Contains(v.readers, user) {
+			versions = append(versions, v)
+		}
+	}
+	b.stats.RecordRead(requestUser(r), id)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(e.Version))
+	json.NewEncoder(w).Encode(versions)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+type HookOp string
+
+const (
+	HookSet    HookOp = "set"
+	HookGet    HookOp = "get"
+	HookDelete HookOp = "delete"
+)
+
+// HookContext is the operation a hook sees. Before hooks may change ID and
+// Value to transform the request; After hooks of a get may change Value to
+// transform the response. Annotations are returned to the client as
+// X-Board-Annotation-* headers.
+type HookContext struct {
+	Op          HookOp            `json:"op"`
+	ID          string            `json:"id"`
+	Value       string            `json:"value"`
+	User        string            `json:"user"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+func (hc *HookContext) Annotate(key, value string) {
+	if hc.Annotations == nil {
+		hc.Annotations = make(map[string]string)
+	}
+	hc.Annotations[key] = value
+}
+
+// Hook lets deployments add their own rules around store operations.
+// Returning an error from Before rejects the operation.
+type Hook interface {
+	Name() string
+	Before(hc *HookContext) error
+	After(hc *HookContext)
+}
+
+// HookError is returned when a hook rejects an operation.
+type HookError struct {
+	Hook   string
+	Reason string
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("rejected by %s: %s", e.Hook, e.Reason)
+}
+
+var (
+	hooksMu sync.RWMutex
+	hooks   []Hook
+)
+
+// RegisterHook adds h to the hooks run for every operation. Hooks compiled
+// into the server call it from an init function; they run in registration
+// order.
+func RegisterHook(h Hook) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	hooks = append(hooks, h)
+}
+
+func runBeforeHooks(hc *HookContext) error {
+	hooksMu.RLock()
+	defer hooksMu.RUnlock()
+
+	for _, h := range hooks {
+		if err := h.Before(hc); err != nil {
+			if _, ok := err.(*HookError); !ok {
+				err = &HookError{Hook: h.Name(), Reason: err.Error()}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func runAfterHooks(hc *HookContext) {
+	hooksMu.RLock()
+	defer hooksMu.RUnlock()
+
+	for _, h := range hooks {
+		h.After(hc)
+	}
+}
+
+// runHooksBefore runs the before hooks for hc and writes the error response
+// when they reject it. An id rewritten by a hook is normalized again.
+func runHooksBefore(w http.ResponseWriter, hc *HookContext, idRules IDRules) bool {
+	id := hc.ID
+	if err := runBeforeHooks(hc); err != nil {
+		writeAnnotations(w, hc)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return false
+	}
+
+	if hc.ID != id {
+		normalized, err := idRules.Normalize(hc.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return false
+		}
+		hc.ID = normalized
+	}
+	return true
+}
+
+func writeAnnotations(w http.ResponseWriter, hc *HookContext) {
+	for k, v := range hc.Annotations {
+		w.Header().Set("X-Board-Annotation-"+k, v)
+	}
+}
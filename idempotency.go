@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"note-board/store"
+)
+
+// Writes sent with an Idempotency-Key header are applied once. The response
+// is kept for idempotencyTTL and replayed, with Idempotent-Replayed: true,
+// to retries of the same request under the same key, so that clients can
+// safely resend writes whose outcome they did not see. Keys belong to the
+// user sending them.
+
+const (
+	headerIdempotencyKey = "Idempotency-Key"
+	idempotencyTTL       = 24 * time.Hour
+	maxIdempotencyKeyLen = 255
+)
+
+type idempotentResponse struct {
+	// fingerprint identifies the request the key was first used with.
+	fingerprint [sha256.Size]byte
+	done        bool
+	status      int
+	header      http.Header
+	body        []byte
+}
+
+type idempotency struct {
+	responses *store.Store[string, idempotentResponse]
+}
+
+func newIdempotency() *idempotency {
+	return &idempotency{responses: store.New(
+		store.WithTTL[string, idempotentResponse](idempotencyTTL),
+		store.WithCleanupInterval[string, idempotentResponse](10*time.Minute),
+	)}
+}
+
+// forget drops the responses kept for the requests of user.
+func (idem *idempotency) forget(user string) int {
+	n := 0
+	idem.responses.Range(func(e store.Entry[string, idempotentResponse]) bool {
+		if strings.HasPrefix(e.Key, user+"\x00") && idem.responses.Delete(e.Key) {
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+// recorder tees a response to the client into a buffer.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(p)
+	return rec.ResponseWriter.Write(p)
+}
+
+func (rec *recorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// once serves r with next unless it repeats a request already made with the
+// same Idempotency-Key, in which case the first response is sent again.
+func (idem *idempotency) once(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	key := r.Header.Get(headerIdempotencyKey)
+	if key == "" {
+		next(w, r)
+		return
+	}
+	if len(key) > maxIdempotencyKeyLen {
+		http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxValueSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	h.Write(body)
+	var fingerprint [sha256.Size]byte
+	h.Sum(fingerprint[:0])
+
+	// Keys are scoped by user so that one cannot replay another's writes.
+	scoped := requestUser(r) + "\x00" + key
+	pending := idempotentResponse{fingerprint: fingerprint}
+	if _, first := idem.responses.SetIf(scoped, pending, idempotencyTTL, func(_ store.Entry[string, idempotentResponse], exists bool) bool {
+		return !exists
+	}); !first {
+		idem.replay(w, scoped, fingerprint)
+		return
+	}
+
+	rec := &recorder{ResponseWriter: w}
+	defer func() {
+		// Failures on the server side may succeed when retried.
+		if rec.status == 0 || rec.status >= 500 {
+			idem.responses.Delete(scoped)
+			return
+		}
+		done := pending
+		done.done = true
+		done.status = rec.status
+		done.header = w.Header().Clone()
+		done.body = rec.body.Bytes()
+		idem.responses.Set(scoped, done)
+	}()
+	next(rec, r)
+}
+
+func (idem *idempotency) replay(w http.ResponseWriter, scoped string, fingerprint [sha256.Size]byte) {
+	prev, exists := idem.responses.Get(scoped)
+	switch {
+	case !exists:
+		http.Error(w, "the request with this Idempotency-Key failed, retry it", http.StatusConflict)
+	case prev.fingerprint != fingerprint:
+		http.Error(w, "Idempotency-Key was already used for another request", http.StatusUnprocessableEntity)
+	case !prev.done:
+		http.Error(w, "a request with this Idempotency-Key is in progress", http.StatusConflict)
+	default:
+		for name, v := range prev.header {
+			w.Header()[name] = v
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(prev.status)
+		w.Write(prev.body)
+	}
+}
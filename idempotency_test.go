@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIdempotencyReplay(t *testing.T) {
+	idem := newIdempotency()
+	calls := 0
+	status := http.StatusCreated
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idem.once(w, r, func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Header().Set("X-Call", strings.Repeat("x", calls))
+			w.WriteHeader(status)
+			w.Write([]byte("done"))
+		})
+	})
+	key := func(k, user string) http.Header {
+		return http.Header{headerIdempotencyKey: {k}, "X-Board-User": {user}}
+	}
+
+	w := serve(h, "POST", "/?id=a", "v", key("k1", "alice"))
+	if w.Code != http.StatusCreated || calls != 1 {
+		t.Fatalf("first request: status %d, %d calls", w.Code, calls)
+	}
+
+	w = serve(h, "POST", "/?id=a", "v", key("k1", "alice"))
+	if w.Code != http.StatusCreated || w.Body.String() != "done" || calls != 1 {
+		t.Errorf("retry: status %d, body %q, %d calls; want the first response", w.Code, w.Body, calls)
+	}
+	if w.Header().Get("Idempotent-Replayed") != "true" || w.Header().Get("X-Call") != "x" {
+		t.Errorf("retry headers = %v", w.Header())
+	}
+
+	if w = serve(h, "POST", "/?id=a", "other", key("k1", "alice")); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("same key, other body: status %d, want 422", w.Code)
+	}
+	if w = serve(h, "POST", "/?id=b", "v", key("k1", "alice")); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("same key, other target: status %d, want 422", w.Code)
+	}
+
+	// Keys belong to their user.
+	if w = serve(h, "POST", "/?id=a", "v", key("k1", "bob")); calls != 2 || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("same key from bob: %d calls, replayed %q; want a new call", calls, w.Header().Get("Idempotent-Replayed"))
+	}
+
+	// Without a key every request is served.
+	serve(h, "POST", "/?id=a", "v", nil)
+	serve(h, "POST", "/?id=a", "v", nil)
+	if calls != 4 {
+		t.Errorf("without a key: %d calls, want 4", calls)
+	}
+
+	// Server errors are not kept, so the retry runs again.
+	status = http.StatusInternalServerError
+	serve(h, "POST", "/?id=a", "v", key("k2", "alice"))
+	status = http.StatusOK
+	if w = serve(h, "POST", "/?id=a", "v", key("k2", "alice")); w.Code != http.StatusOK || calls != 6 {
+		t.Errorf("retry after a server error: status %d, %d calls", w.Code, calls)
+	}
+
+	if w = serve(h, "POST", "/?id=a", "v", key(strings.Repeat("k", maxIdempotencyKeyLen+1), "alice")); w.Code != http.StatusBadRequest {
+		t.Errorf("long key: status %d, want 400", w.Code)
+	}
+
+	if n := idem.forget("alice"); n != 2 {
+		t.Errorf("forget(alice) = %d, want 2", n)
+	}
+	serve(h, "POST", "/?id=a", "v", key("k1", "alice"))
+	if calls != 7 {
+		t.Errorf("after forget: %d calls, want 7", calls)
+	}
+}
+
+func TestIdempotencyInProgress(t *testing.T) {
+	idem := newIdempotency()
+	started, release := make(chan struct{}), make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idem.once(w, r, func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+		})
+	})
+	header := http.Header{headerIdempotencyKey: {"k"}}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- serve(h, "POST", "/", "v", header) }()
+	<-started
+	if w := serve(h, "POST", "/", "v", header); w.Code != http.StatusConflict {
+		t.Errorf("while in progress: status %d, want 409", w.Code)
+	}
+	close(release)
+	if w := <-done; w.Code != http.StatusOK {
+		t.Errorf("first request: status %d", w.Code)
+	}
+}
+
+func TestIdempotentAppend(t *testing.T) {
+	b, h := newTestBoard(t)
+	header := http.Header{headerIdempotencyKey: {"chunk-1"}}
+
+	for range 2 {
+		if w := serve(h, "POST", "/?id=ci/log&append", "one\n", header); w.Code != http.StatusOK {
+			t.Fatalf("append: status %d: %s", w.Code, w.Body)
+		}
+	}
+	if got := b.store.Get("ci/log"); got != "one\n" {
+		t.Errorf("log = %q, want the chunk appended once", got)
+	}
+}
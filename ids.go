@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// IDRules describes which clip ids are accepted and how they are normalized.
+//
+// An id is one or more segments separated by "/". A segment is made of
+// letters, marks, digits and the characters "-_.~@+:", and may not be empty,
+// "." or "..". Ids are converted to Unicode NFC, and case folded when
+// CaseFold is set, before any other check so that visually identical ids map
+// to the same clip.
+type IDRules struct {
+	CaseFold bool
+	// Strict rejects ids that are not already normalized instead of
+	// normalizing them.
+	Strict   bool
+	MaxLen   int
+	Reserved map[string]bool
+}
+
+// IDError explains why an id was rejected.
+type IDError struct {
+	ID     string
+	Pos    int
+	Reason string
+}
+
+func (e *IDError) Error() string {
+	if e.Pos >= 0 {
+		return fmt.Sprintf("invalid id %q at position %d: %s", e.ID, e.Pos, e.Reason)
+	}
+	return fmt.Sprintf("invalid id %q: %s", e.ID, e.Reason)
+}
+
+const idPunctuation = "-_.~@+:"
+
+func idRulesFromEnv() IDRules {
+	rules := IDRules{
+		CaseFold: os.Getenv("NOTE_BOARD_ID_CASE_FOLD") == "true",
+		Strict:   os.Getenv("NOTE_BOARD_ID_STRICT") == "true",
+		MaxLen:   envInt("NOTE_BOARD_ID_MAX_LEN", 256),
+		Reserved: make(map[string]bool),
+	}
+
+	reserved := os.Getenv("NOTE_BOARD_RESERVED_IDS")
+	if reserved == "" {
+		reserved = "admin,privacy"
+	}
+	for _, id := range strings.Split(reserved, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			rules.Reserved[rules.fold(norm.NFC.String(id))] = true
+		}
+	}
+
+	return rules
+}
+
+func (rules IDRules) fold(id string) string {
+	if rules.CaseFold {
+		return cases.Fold().String(id)
+	}
+	return id
+}
+
+// Namespace returns the canonical form of the namespace ns, so that it can
+// be compared with the first segment of normalized ids.
+func (rules IDRules) Namespace(ns string) string {
+	return rules.fold(norm.NFC.String(ns))
+}
+
+// Prefix returns the canonical form of a prefix of ids.
+func (rules IDRules) Prefix(prefix string) string {
+	return rules.fold(norm.NFC.String(prefix))
+}
+
+// Normalize returns the canonical form of raw or an *IDError describing the
+// first rule it breaks. Positions in errors count runes, starting at 0.
+func (rules IDRules) Normalize(raw string) (string, error) {
+	if raw == "" {
+		return "", &IDError{ID: raw, Pos: -1, Reason: "id is empty"}
+	}
+	if !utf8.ValidString(raw) {
+		for i, r := range raw {
+			if r == utf8.RuneError {
+				return "", &IDError{ID: raw, Pos: utf8.RuneCountInString(raw[:i]), Reason: "invalid UTF-8"}
+			}
+		}
+	}
+
+	id := rules.fold(norm.NFC.String(raw))
+	if rules.Strict && id != raw {
+		return "", &IDError{ID: raw, Pos: -1, Reason: fmt.Sprintf("id is not normalized, use %q", id)}
+	}
+
+	if n := utf8.RuneCountInString(id); rules.MaxLen > 0 && n > rules.MaxLen {
+		return "", &IDError{ID: raw, Pos: rules.MaxLen, Reason: fmt.Sprintf("id is %d characters long, the limit is %d", n, rules.MaxLen)}
+	}
+
+	pos := 0
+	for _, segment := range strings.Split(id, "/") {
+		switch segment {
+		case "":
+			return "", &IDError{ID: raw, Pos: pos, Reason: "empty path segment"}
+		case ".", "..":
+			return "", &IDError{ID: raw, Pos: pos, Reason: fmt.Sprintf("%q is not allowed as a path segment", segment)}
+		}
+
+		for _, r := range segment {
+			if !unicode.IsLetter(r) && !unicode.IsMark(r) && !unicode.IsDigit(r) && !strings.ContainsRune(idPunctuation, r) {
+				return "", &IDError{ID: raw, Pos: pos, Reason: fmt.Sprintf("character %U %q is not allowed", r, r)}
+			}
+			pos++
+		}
+		pos++ // the separator
+	}
+
+	if rules.Reserved[id] {
+		return "", &IDError{ID: raw, Pos: -1, Reason: "id is reserved"}
+	}
+
+	return id, nil
+}
+
+// idsHandler serves the canonical form of ?id= as {"id": ...}, so that
+// clients can sign a clip under the id it will be stored as.
+func (b *board) idsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := b.idRules.Normalize(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"id": id})
+}
+
+// normalizeValue checks that a clip value is valid UTF-8 and, when
+// NOTE_BOARD_VALUE_NFC is set, converts it to NFC.
+func normalizeValue(value string) (string, error) {
+	if !utf8.ValidString(value) {
+		return "", fmt.Errorf("value is not valid UTF-8")
+	}
+	if os.Getenv("NOTE_BOARD_VALUE_NFC") == "true" {
+		return norm.NFC.String(value), nil
+	}
+	return value, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestIDRulesNormalize(t *testing.T) {
+	rules := IDRules{MaxLen: 16, Reserved: map[string]bool{"admin": true}}
+	folded := rules
+	folded.CaseFold = true
+	strict := rules
+	strict.Strict = true
+
+	tests := []struct {
+		name  string
+		rules IDRules
+		raw   string
+		want  string
+	}{
+		{"plain", rules, "notes/a", "notes/a"},
+		{"punctuation", rules, "a-b_c.d~e@f+g:h", "a-b_c.d~e@f+g:h"},
+		{"marks and digits", rules, "ملاحظة/١", "ملاحظة/١"},
+		{"composed", rules, "café", "café"},
+		{"decomposed to NFC", rules, "cafe\u0301", "caf\u00e9"},
+		{"case kept", rules, "Notes/A", "Notes/A"},
+		{"case folded", folded, "Notes/A", "notes/a"},
+		{"full case folding", folded, "STRASSE/x", "strasse/x"},
+		{"folded and composed", folded, "CAFE\u0301", "caf\u00e9"},
+		{"strict accepts normalized", strict, "café", "café"},
+		{"length counts runes", rules, "éééééééééééééééé", "éééééééééééééééé"},
+		{"reserved prefix is fine", rules, "admin/x", "admin/x"},
+	}
+	for _, tt := range tests {
+		got, err := tt.rules.Normalize(tt.raw)
+		if err != nil {
+			t.Errorf("%s: Normalize(%q): %v", tt.name, tt.raw, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: Normalize(%q) = %q, want %q", tt.name, tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestIDRulesNormalizeErrors(t *testing.T) {
+	rules := IDRules{MaxLen: 16, Reserved: map[string]bool{"admin": true}}
+	folded := rules
+	folded.CaseFold = true
+	strict := rules
+	strict.Strict = true
+
+	tests := []struct {
+		name  string
+		rules IDRules
+		raw   string
+		pos   int
+	}{
+		{"empty", rules, "", -1},
+		{"invalid UTF-8", rules, "ab\xffc", 2},
+		{"too long", rules, "aaaaaaaaaaaaaaaaa", 16},
+		{"empty segment", rules, "a//b", 2},
+		{"leading slash", rules, "/a", 0},
+		{"trailing slash", rules, "a/", 2},
+		{"dot segment", rules, "a/./b", 2},
+		{"dot dot segment", rules, "a/../b", 2},
+		{"space", rules, "a b", 1},
+		{"position counts runes", rules, "éé?", 2},
+		{"reserved", rules, "admin", -1},
+		{"reserved after folding", folded, "ADMIN", -1},
+		{"reserved is case sensitive without folding", rules, "Admin?", 5},
+		{"strict rejects decomposed", strict, "cafe\u0301", -1},
+	}
+	for _, tt := range tests {
+		got, err := tt.rules.Normalize(tt.raw)
+		var idErr *IDError
+		if !errors.As(err, &idErr) {
+			t.Errorf("%s: Normalize(%q) = %q, %v, want an *IDError", tt.name, tt.raw, got, err)
+			continue
+		}
+		if idErr.Pos != tt.pos || idErr.ID != tt.raw {
+			t.Errorf("%s: Normalize(%q): %v, want position %d", tt.name, tt.raw, err, tt.pos)
+		}
+	}
+}
+
+func TestIDRulesPrefix(t *testing.T) {
+	folded := IDRules{CaseFold: true}
+	for _, tt := range []struct {
+		rules    IDRules
+		in, want string
+	}{
+		{IDRules{}, "Notes/café", "Notes/café"},
+		{folded, "Notes/CAFE\u0301", "notes/caf\u00e9"},
+		{folded, "", ""},
+		// Prefixes need not be valid ids.
+		{folded, "NOTES/", "notes/"},
+	} {
+		if got := tt.rules.Prefix(tt.in); got != tt.want {
+			t.Errorf("Prefix(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Secondary indexes map a metadata label ("meta:<key>") or a field of JSON
+// clips ("json:<pointer>") to the ids of the clips holding each value. Exact
+// indexes answer equality; range indexes keep their values sorted and also
+// answer <, <=, > and >=. Values that parse as numbers compare numerically,
+// in both kinds of index, and sort before other values.
+
+type IndexDef struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	Kind   string `json:"kind"`
+}
+
+type indexedValue struct {
+	value string
+	id    string
+}
+
+type secondaryIndex struct {
+	def     IndexDef
+	metaKey string
+	pointer []string
+
+	byID   map[string]string
+	exact  map[string]map[string]bool
+	sorted []indexedValue
+}
+
+func newSecondaryIndex(def IndexDef) (*secondaryIndex, error) {
+	if def.Kind != "exact" && def.Kind != "range" {
+		return nil, fmt.Errorf("kind must be \"exact\" or \"range\"")
+	}
+
+	idx := &secondaryIndex{
+		def:   def,
+		byID:  make(map[string]string),
+		exact: make(map[string]map[string]bool),
+	}
+
+	switch {
+	case strings.HasPrefix(def.Source, "meta:"):
+		idx.metaKey = strings.TrimPrefix(def.Source, "meta:")
+		if !metaKeyPattern.MatchString(idx.metaKey) {
+			return nil, fmt.Errorf("invalid metadata key %q", idx.metaKey)
+		}
+	case strings.HasPrefix(def.Source, "json:"):
+		pointer, err := parseJSONPointer(strings.TrimPrefix(def.Source, "json:"))
+		if err != nil {
+			return nil, err
+		}
+		idx.pointer = pointer
+	default:
+		return nil, fmt.Errorf("source must be \"meta:<key>\" or \"json:<pointer>\"")
+	}
+
+	return idx, nil
+}
+
+// extract returns the indexed value of a clip, if it has one. Only scalar
+// JSON values are indexed.
+func (idx *secondaryIndex) extract(val storedValue) (string, bool) {
+	if idx.metaKey != "" {
+		v, ok := val.meta[idx.metaKey]
+		return v, ok
+	}
+
+	if val.kind != clipJSON {
+		return "", false
+	}
+	doc, err := decodeJSON([]byte(val.value))
+	if err != nil {
+		return "", false
+	}
+	v, err := pointerGet(doc, idx.pointer)
+	if err != nil {
+		return "", false
+	}
+
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return fmt.Sprint(v), true
+	default:
+		return "", false
+	}
+}
+
+func parseNumber(s string) (*big.Float, bool) {
+	f, _, err := big.ParseFloat(s, 10, 128, big.ToNearestEven)
+	return f, err == nil
+}
+
+func compareIndexValues(a, b string) int {
+	fa, aNum := parseNumber(a)
+	fb, bNum := parseNumber(b)
+
+	switch {
+	case aNum && bNum:
+		return fa.Cmp(fb)
+	case aNum:
+		return -1
+	case bNum:
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+// exactKey is the key of v in an exact index: numbers are stored in their
+// shortest form so that equal numbers such as "1" and "1.0" match, as they
+// do in range indexes.
+func exactKey(v string) string {
+	if f, ok := parseNumber(v); ok {
+		if f.Sign() == 0 {
+			return "0"
+		}
+		return f.Text('g', -1)
+	}
+	return v
+}
+
+func (idx *secondaryIndex) search(value, id string) int {
+	return sort.Search(len(idx.sorted), func(i int) bool {
+		if c := compareIndexValues(idx.sorted[i].value, value); c != 0 {
+			return c > 0
+		}
+		return idx.sorted[i].id >= id
+	})
+}
+
+func (idx *secondaryIndex) remove(id string) {
+	old, ok := idx.byID[id]
+	if !ok {
+		return
+	}
+	delete(idx.byID, id)
+
+	if idx.def.Kind == "exact" {
+		key := exactKey(old)
+		delete(idx.exact[key], id)
+		if len(idx.exact[key]) == 0 {
+			delete(idx.exact, key)
+		}
+		return
+	}
+
+	if i := idx.search(old, id); i < len(idx.sorted) && idx.sorted[i].id == id {
+		idx.sorted = append(idx.sorted[:i], idx.sorted[i+1:]...)
+	}
+}
+
+func (idx *secondaryIndex) add(id string, val storedValue) {
+	idx.remove(id)
+
+	v, ok := idx.extract(val)
+	if !ok {
+		return
+	}
+	idx.byID[id] = v
+
+	if idx.def.Kind == "exact" {
+		key := exactKey(v)
+		if idx.exact[key] == nil {
+			idx.exact[key] = make(map[string]bool)
+		}
+		idx.exact[key][id] = true
+		return
+	}
+
+	i := idx.search(v, id)
+	idx.sorted = append(idx.sorted, indexedValue{})
+	copy(idx.sorted[i+1:], idx.sorted[i:])
+	idx.sorted[i] = indexedValue{value: v, id: id}
+}
+
+type indexPredicate struct {
+	index string
+	op    string
+	value string
+}
+
+func (p indexPredicate) holds(v string) bool {
+	c := compareIndexValues(v, p.value)
+	switch p.op {
+	case "=":
+		return c == 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// parseIndexPredicate parses "name=value", "name<value", "name<=value",
+// "name>value" or "name>=value".
+func parseIndexPredicate(s string) (indexPredicate, error) {
+	i := strings.IndexAny(s, "=<>")
+	if i <= 0 {
+		return indexPredicate{}, fmt.Errorf("invalid predicate %q: expected <index><op><value>", s)
+	}
+
+	op := s[i : i+1]
+	if op != "=" && i+1 < len(s) && s[i+1] == '=' {
+		op += "="
+	}
+	return indexPredicate{index: s[:i], op: op, value: s[i+len(op):]}, nil
+}
+
+func (idx *secondaryIndex) lookup(p indexPredicate) (map[string]bool, error) {
+	ids := make(map[string]bool)
+
+	if idx.def.Kind == "exact" {
+		if p.op != "=" {
+			return nil, fmt.Errorf("index %q only supports equality", idx.def.Name)
+		}
+		for id := range idx.exact[exactKey(p.value)] {
+			ids[id] = true
+		}
+		return ids, nil
+	}
+
+	// The values in [lo, hi) are equal to p.value.
+	lo := idx.search(p.value, "")
+	hi := sort.Search(len(idx.sorted), func(i int) bool {
+		return compareIndexValues(idx.sorted[i].value, p.value) > 0
+	})
+
+	start, end := 0, len(idx.sorted)
+	switch p.op {
+	case "=":
+		start, end = lo, hi
+	case ">=":
+		start = lo
+	case ">":
+		start = hi
+	case "<":
+		end = lo
+	case "<=":
+		end = hi
+	}
+	for _, iv := range idx.sorted[start:end] {
+		ids[iv.id] = true
+	}
+	return ids, nil
+}
+
+// IndexManager maintains the secondary indexes of a ValueStore.
+type IndexManager struct {
+	mu      sync.RWMutex
+	indexes map[string]*secondaryIndex
+}
+
+func NewIndexManager() *IndexManager {
+	return &IndexManager{indexes: make(map[string]*secondaryIndex)}
+}
+
+// Define creates or replaces an index and fills it from the clips in vs.
+func (im *IndexManager) Define(def IndexDef, vs *ValueStore) error {
+	idx, err := newSecondaryIndex(def)
+	if err != nil {
+		return err
+	}
+
+	// Writes wait while the index is filled, so that it misses none.
+	vs.values.Locked(func(live []clipEntry) {
+		for _, e := range live {
+			idx.add(e.Key, e.Value)
+		}
+		im.mu.Lock()
+		defer im.mu.Unlock()
+		im.indexes[def.Name] = idx
+	})
+	return nil
+}
+
+func (im *IndexManager) Drop(name string) bool {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	_, ok := im.indexes[name]
+	delete(im.indexes, name)
+	return ok
+}
+
+func (im *IndexManager) List() []IndexDef {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	defs := []IndexDef{}
+	for _, idx := range im.indexes {
+		defs = append(defs, idx.def)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Name < defs[j].Name })
+	return defs
+}
+
+func (im *IndexManager) Update(id string, val storedValue) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	for _, idx := range im.indexes {
+		idx.add(id, val)
+	}
+}
+
+func (im *IndexManager) Remove(id string) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	for _, idx := range im.indexes {
+		idx.remove(id)
+	}
+}
+
+// Query returns the ids matching every predicate according to the indexes.
+// The indexes are updated as part of each write, but a clip may change
+// between Query and reading it, so callers should check the candidates
+// against the stored clips with Matches.
+func (im *IndexManager) Query(preds []indexPredicate) ([]string, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	var result map[string]bool
+	for _, p := range preds {
+		idx, ok := im.indexes[p.index]
+		if !ok {
+			return nil, fmt.Errorf("no index named %q", p.index)
+		}
+		ids, err := idx.lookup(p)
+		if err != nil {
+			return nil, err
+		}
+
+		if result == nil {
+			result = ids
+			continue
+		}
+		for id := range result {
+			if !ids[id] {
+				delete(result, id)
+			}
+		}
+	}
+
+	list := make([]string, 0, len(result))
+	for id := range result {
+		list = append(list, id)
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+// Matches reports whether val satisfies every predicate.
+func (im *IndexManager) Matches(val storedValue, preds []indexPredicate) bool {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	for _, p := range preds {
+		idx, ok := im.indexes[p.index]
+		if !ok {
+			return false
+		}
+		v, ok := idx.extract(val)
+		if !ok || !p.holds(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// indexesHandler manages index definitions: GET lists them, PUT ?name=
+// defines one from a JSON body and DELETE ?name= drops one.
+func indexesHandler(vs *ValueStore) http.HandlerFunc {
+	return requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(vs.indexes.List())
+
+		case http.MethodPut:
+			name := r.URL.Query().Get("name")
+			if name == "" {
+				http.Error(w, "missing ?name parameter", http.StatusBadRequest)
+				return
+			}
+
+			var def IndexDef
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&def); err != nil {
+				http.Error(w, "invalid index: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			def.Name = name
+
+			if err := vs.indexes.Define(def, vs); err != nil {
+				http.Error(w, "invalid index: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(def)
+
+		case http.MethodDelete:
+			if !vs.indexes.Drop(r.URL.Query().Get("name")) {
+				http.Error(w, "index not found", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// queryClips returns the clips matching every ?where= predicate that the
+// client may read, for example /query?where=source=ci&where=build>=100.
+func (b *board) queryClips(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var preds []indexPredicate
+	for _, s := range r.URL.Query()["where"] {
+		p, err := parseIndexPredicate(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		preds = append(preds, p)
+	}
+	if len(preds) == 0 {
+		http.Error(w, "missing ?where parameter", http.StatusBadRequest)
+		return
+	}
+
+	ids, err := b.store.indexes.Query(preds)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	readable, ok := b.readable(w, r)
+	if !ok {
+		return
+	}
+
+	clips := []clipSummary{}
+	for _, id := range ids {
+		e, ok := b.store.Lookup(id)
+		if !ok || !b.store.indexes.Matches(e.Value, preds) || !readable(id, e.Value.kind, e.Value.meta, e.Value.readers) {
+			continue
+		}
+		clips = append(clips, clipSummary{
+			ID:      id,
+			Type:    e.Value.kind,
+			Size:    len(e.Value.value),
+			Meta:    e.Value.meta,
+			Readers: e.Value.readers,
+			Burn:    e.Value.burn,
+			Version: e.Version,
+			Expires: e.Expires,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(clips)
+}
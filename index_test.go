@@ -0,0 +1,182 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCompareIndexValues(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1", "2", -1},
+		{"10", "9", 1},
+		{"1", "1.0", 0},
+		{"-0", "0", 0},
+		{"1e3", "999", 1},
+		{"-5", "-10", 1},
+		{"12345678901234567890", "12345678901234567891", -1},
+		{"9", "a", -1},
+		{"a", "9", 1},
+		{"a", "b", -1},
+		{"b", "b", 0},
+	}
+	for _, tt := range tests {
+		if got := compareIndexValues(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareIndexValues(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestParseIndexPredicate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want indexPredicate
+	}{
+		{"build=10", indexPredicate{"build", "=", "10"}},
+		{"build<10", indexPredicate{"build", "<", "10"}},
+		{"build<=10", indexPredicate{"build", "<=", "10"}},
+		{"build>10", indexPredicate{"build", ">", "10"}},
+		{"build>=10", indexPredicate{"build", ">=", "10"}},
+		{"env==prod", indexPredicate{"env", "=", "=prod"}},
+		{"env=", indexPredicate{"env", "=", ""}},
+	}
+	for _, tt := range tests {
+		got, err := parseIndexPredicate(tt.in)
+		if err != nil {
+			t.Errorf("parseIndexPredicate(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseIndexPredicate(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "build", "=10", "<10"} {
+		if _, err := parseIndexPredicate(in); err == nil {
+			t.Errorf("parseIndexPredicate(%q) succeeded", in)
+		}
+	}
+}
+
+// newIndexedStore returns a store holding a clip per entry of builds, with
+// the build number as its "build" label and in its JSON value.
+func newIndexedStore(t *testing.T, builds map[string]string) *ValueStore {
+	t.Helper()
+	vs := NewValueStore(time.Hour, 0, nil)
+	t.Cleanup(vs.values.Close)
+
+	for id, build := range builds {
+		vs.Put(id, storedValue{
+			kind:  clipJSON,
+			value: `{"build":` + build + `}`,
+			meta:  map[string]string{"build": build},
+		}, time.Hour)
+	}
+	for _, def := range []IndexDef{
+		{Name: "range", Source: "meta:build", Kind: "range"},
+		{Name: "exact", Source: "meta:build", Kind: "exact"},
+		{Name: "json", Source: "json:/build", Kind: "range"},
+	} {
+		if err := vs.indexes.Define(def, vs); err != nil {
+			t.Fatalf("Define(%+v): %v", def, err)
+		}
+	}
+	return vs
+}
+
+func TestRangeIndex(t *testing.T) {
+	vs := newIndexedStore(t, map[string]string{
+		"ci/a": "9",
+		"ci/b": "10",
+		"ci/c": "10.0",
+		"ci/d": "100",
+		"ci/e": "-1",
+	})
+
+	tests := []struct {
+		pred string
+		want []string
+	}{
+		{"range=10", []string{"ci/b", "ci/c"}},
+		{"range<10", []string{"ci/a", "ci/e"}},
+		{"range<=10", []string{"ci/a", "ci/b", "ci/c", "ci/e"}},
+		{"range>10", []string{"ci/d"}},
+		{"range>=10", []string{"ci/b", "ci/c", "ci/d"}},
+		{"range>100", []string{}},
+		{"range<-1", []string{}},
+		{"range=1e1", []string{"ci/b", "ci/c"}},
+		{"exact=10", []string{"ci/b", "ci/c"}},
+		{"exact=10.00", []string{"ci/b", "ci/c"}},
+		{"exact=9", []string{"ci/a"}},
+		{"exact=11", []string{}},
+		{"json>=10", []string{"ci/b", "ci/c", "ci/d"}},
+	}
+	for _, tt := range tests {
+		pred, err := parseIndexPredicate(tt.pred)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := vs.indexes.Query([]indexPredicate{pred})
+		if err != nil {
+			t.Errorf("Query(%s): %v", tt.pred, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Query(%s) = %v, want %v", tt.pred, got, tt.want)
+		}
+	}
+}
+
+func TestIndexQueryErrors(t *testing.T) {
+	vs := newIndexedStore(t, nil)
+	for _, pred := range []indexPredicate{
+		{"missing", "=", "1"},
+		{"exact", "<", "1"},
+	} {
+		if _, err := vs.indexes.Query([]indexPredicate{pred}); err == nil {
+			t.Errorf("Query(%+v) succeeded", pred)
+		}
+	}
+}
+
+func TestIndexFollowsWrites(t *testing.T) {
+	vs := newIndexedStore(t, map[string]string{"ci/a": "1", "ci/b": "2"})
+	query := func(s string) []string {
+		t.Helper()
+		pred, err := parseIndexPredicate(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids, err := vs.indexes.Query([]indexPredicate{pred})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ids
+	}
+
+	vs.Put("ci/a", storedValue{kind: clipText, meta: map[string]string{"build": "3"}}, time.Hour)
+	if got := query("range>=2"); !slices.Equal(got, []string{"ci/a", "ci/b"}) {
+		t.Errorf("after overwrite: range>=2 = %v", got)
+	}
+	if got := query("exact=1"); len(got) != 0 {
+		t.Errorf("after overwrite: exact=1 = %v, want none", got)
+	}
+	if got := query("json>=1"); !slices.Equal(got, []string{"ci/b"}) {
+		t.Errorf("after overwrite with text: json>=1 = %v, want [ci/b]", got)
+	}
+
+	vs.Delete("ci/b")
+	for _, pred := range []string{"range=2", "exact=2", "json=2"} {
+		if got := query(pred); len(got) != 0 {
+			t.Errorf("after delete: %s = %v, want none", pred, got)
+		}
+	}
+
+	vs.Put("ci/c", storedValue{kind: clipText, meta: map[string]string{"build": "3"}}, time.Hour)
+	if got := query("range=3"); !slices.Equal(got, []string{"ci/a", "ci/c"}) {
+		t.Errorf("after new clip: range=3 = %v", got)
+	}
+}
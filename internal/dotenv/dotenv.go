@@ -0,0 +1,148 @@
+// Package dotenv parses environment variable files in the .env format:
+//
+//	# comment
+//	export NAME=value
+//	GREETING="hello\nworld"   # escapes in double quotes
+//	PATTERN='a$b\n'           # single quotes are literal
+//	KEY="-----BEGIN KEY-----
+//	...
+//	-----END KEY-----"
+//
+// Unquoted values end at a " #" comment and are trimmed. Quoted values may
+// span lines. Variables are not expanded.
+package dotenv
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Var struct {
+	Name  string
+	Value string
+}
+
+// ParseError reports the line of a file that could not be parsed.
+type ParseError struct {
+	Line int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// Parse returns the variables of a .env file in the order they are defined.
+// A name defined twice is an error, as one of the values would be lost
+// silently.
+func Parse(text string) ([]Var, error) {
+	var vars []Var
+	seen := make(map[string]int)
+
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "export "); ok {
+			line = strings.TrimLeft(rest, " \t")
+		}
+
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, &ParseError{lineNo, "expected NAME=value"}
+		}
+		name = strings.TrimSpace(name)
+		if !validName(name) {
+			return nil, &ParseError{lineNo, fmt.Sprintf("invalid variable name %q", name)}
+		}
+		if first, dup := seen[name]; dup {
+			return nil, &ParseError{lineNo, fmt.Sprintf("%s is already defined on line %d", name, first)}
+		}
+		seen[name] = lineNo
+
+		value = strings.TrimLeft(value, " \t")
+		if value != "" && (value[0] == '"' || value[0] == '\'') {
+			// Quoted values may continue on the following lines.
+			quote := value[0]
+			raw := value[1:]
+			end := closingQuote(raw, quote)
+			for end < 0 && i+1 < len(lines) {
+				i++
+				raw += "\n" + lines[i]
+				end = closingQuote(raw, quote)
+			}
+			if end < 0 {
+				return nil, &ParseError{lineNo, fmt.Sprintf("unterminated quoted value of %s", name)}
+			}
+			if trailing := strings.TrimSpace(raw[end+1:]); trailing != "" && !strings.HasPrefix(trailing, "#") {
+				return nil, &ParseError{i + 1, fmt.Sprintf("unexpected text after the value of %s", name)}
+			}
+			value = raw[:end]
+			if quote == '"' {
+				value = unescape(value)
+			}
+		} else {
+			if j := strings.Index(value, " #"); j >= 0 {
+				value = value[:j]
+			}
+			value = strings.TrimSpace(value)
+		}
+
+		vars = append(vars, Var{Name: name, Value: value})
+	}
+	return vars, nil
+}
+
+func validName(name string) bool {
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		return false
+	}
+	for _, c := range name {
+		if !(c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// closingQuote returns the index of the quote that ends s, skipping
+// backslash escapes in double quoted values, or -1.
+func closingQuote(s string, quote byte) int {
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quote == '"':
+			i++
+		case s[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+func unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case '"', '\\', '$':
+			b.WriteByte(s[i])
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,78 @@
+package dotenv
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []Var
+	}{
+		{"empty", "", nil},
+		{"comments and blank lines", "# c\n\n  # indented\n", nil},
+		{"plain", "A=1\nB=two", []Var{{"A", "1"}, {"B", "two"}}},
+		{"export", "export A=1\nexport  B=2", []Var{{"A", "1"}, {"B", "2"}}},
+		{"spaces around", "  A = 1  ", []Var{{"A", "1"}}},
+		{"empty value", "A=\nB=''\nC=\"\"", []Var{{"A", ""}, {"B", ""}, {"C", ""}}},
+		{"crlf", "A=1\r\nB=2\r\n", []Var{{"A", "1"}, {"B", "2"}}},
+		{"unquoted comment", "A=1 # one", []Var{{"A", "1"}}},
+		{"unquoted hash", "A=a#b", []Var{{"A", "a#b"}}},
+		{"unquoted equals", "A=b=c", []Var{{"A", "b=c"}}},
+		{"double quoted", `A="a b"`, []Var{{"A", "a b"}}},
+		{"double quoted hash", `A="a # b" # c`, []Var{{"A", "a # b"}}},
+		{"escapes", `A="l1\nl2\tt\r\"q\" \\ \$"`, []Var{{"A", "l1\nl2\tt\r\"q\" \\ $"}}},
+		{"unknown escape", `A="\x"`, []Var{{"A", `\x`}}},
+		{"trailing backslash", `A="a\\"`, []Var{{"A", `a\`}}},
+		{"single quoted", `A='a\n$b "c"'`, []Var{{"A", `a\n$b "c"`}}},
+		{"single quote ends at quote", `A='a\' # c`, []Var{{"A", `a\`}}},
+		{"multiline double", "A=\"one\ntwo\"\nB=3", []Var{{"A", "one\ntwo"}, {"B", "3"}}},
+		{"multiline single", "A='one\n  two'", []Var{{"A", "one\n  two"}}},
+		{"escaped quote across lines", "A=\"a\\\"\nb\"", []Var{{"A", "a\"\nb"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.text)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.text, err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Parse(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		line int
+	}{
+		{"no equals", "A=1\nB", 2},
+		{"bad name", "1A=1", 1},
+		{"name with dash", "A-B=1", 1},
+		{"empty name", "=1", 1},
+		{"duplicate", "A=1\n\nA=2", 3},
+		{"unterminated double", "A=1\nB=\"open\nstill", 2},
+		{"unterminated single", "A='open", 1},
+		{"text after quote", "A=\"a\nb\" c", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.text)
+			var pe *ParseError
+			if !errors.As(err, &pe) {
+				t.Fatalf("Parse(%q): got %v, want a *ParseError", tt.text, err)
+			}
+			if pe.Line != tt.line {
+				t.Errorf("Parse(%q): error on line %d, want %d: %v", tt.text, pe.Line, tt.line, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+// Package provenance defines how clips are signed with Ed25519. It is shared
+// by the note-board server, which verifies signatures on write and reports
+// them on read, and the CLI, which signs on push and checks on pull.
+//
+// A signature covers the clip id, type and value, so it cannot be moved to
+// another clip. The id is the one the server stores the clip under, after
+// normalization, not the one the client sent. Keys are identified by the fingerprint of the public key.
+package provenance
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+// Headers carrying a signature on writes and its details on reads.
+const (
+	HeaderSignature = "X-Board-Signature"
+	HeaderKey       = "X-Board-Signature-Key"
+	HeaderStatus    = "X-Board-Signature-Status"
+	HeaderSigner    = "X-Board-Signer"
+)
+
+// Verification statuses reported by the server.
+const (
+	StatusVerified = "verified"
+	StatusUnsigned = "unsigned"
+	StatusInvalid  = "invalid"
+)
+
+const context = "note-board clip signature v1"
+
+// Message returns the bytes that are signed for a clip.
+func Message(id, kind, value string) []byte {
+	msg := make([]byte, 0, len(context)+len(id)+len(kind)+len(value)+3)
+	msg = append(msg, context...)
+	for _, s := range []string{id, kind, value} {
+		msg = append(msg, 0)
+		msg = append(msg, s...)
+	}
+	return msg
+}
+
+func Sign(key ed25519.PrivateKey, id, kind, value string) []byte {
+	return ed25519.Sign(key, Message(id, kind, value))
+}
+
+func Verify(key ed25519.PublicKey, id, kind, value string, sig []byte) bool {
+	return ed25519.Verify(key, Message(id, kind, value), sig)
+}
+
+// Fingerprint identifies a public key in the style of OpenSSH.
+func Fingerprint(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+func EncodePublicKey(key ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("public key is not valid base64")
+	}
+	if len(b) != ed25519.PublicKeySize {
+		return nil, errors.New("public key is not an Ed25519 key")
+	}
+	return ed25519.PublicKey(b), nil
+}
@@ -0,0 +1,107 @@
+package provenance
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestMessage(t *testing.T) {
+	// Fields are separated so that moving bytes between them changes the
+	// message.
+	tests := []struct {
+		a, b [3]string
+	}{
+		{[3]string{"ab", "c", "v"}, [3]string{"a", "bc", "v"}},
+		{[3]string{"id", "text", "xy"}, [3]string{"id", "textx", "y"}},
+		{[3]string{"", "text", "v"}, [3]string{"text", "", "v"}},
+	}
+	for _, tt := range tests {
+		ma := Message(tt.a[0], tt.a[1], tt.a[2])
+		mb := Message(tt.b[0], tt.b[1], tt.b[2])
+		if bytes.Equal(ma, mb) {
+			t.Errorf("Message(%q) == Message(%q)", tt.a, tt.b)
+		}
+	}
+
+	want := context + "\x00notes/a\x00text\x00hello"
+	if got := string(Message("notes/a", "text", "hello")); got != want {
+		t.Errorf("Message = %q, want %q", got, want)
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := Sign(key, "notes/a", "text", "hello")
+
+	tests := []struct {
+		name            string
+		key             ed25519.PublicKey
+		id, kind, value string
+		sig             []byte
+		want            bool
+	}{
+		{"valid", pub, "notes/a", "text", "hello", sig, true},
+		{"other id", pub, "notes/b", "text", "hello", sig, false},
+		{"other type", pub, "notes/a", "json", "hello", sig, false},
+		{"other value", pub, "notes/a", "text", "hello!", sig, false},
+		{"other key", otherPub, "notes/a", "text", "hello", sig, false},
+		{"truncated signature", pub, "notes/a", "text", "hello", sig[:len(sig)-1], false},
+	}
+	for _, tt := range tests {
+		if got := Verify(tt.key, tt.id, tt.kind, tt.value, tt.sig); got != tt.want {
+			t.Errorf("%s: Verify = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPublicKeyEncoding(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ParsePublicKey(EncodePublicKey(pub))
+	if err != nil {
+		t.Fatalf("ParsePublicKey: %v", err)
+	}
+	if !got.Equal(pub) {
+		t.Error("public key does not round-trip")
+	}
+
+	for _, s := range []string{
+		"not base64!",
+		base64.StdEncoding.EncodeToString(pub[:31]),
+		base64.StdEncoding.EncodeToString(append(pub, 0)),
+	} {
+		if _, err := ParsePublicKey(s); err == nil {
+			t.Errorf("ParsePublicKey(%q) succeeded", s)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fp := Fingerprint(pub)
+	sum, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(fp, "SHA256:"))
+	if !strings.HasPrefix(fp, "SHA256:") || err != nil || len(sum) != sha256.Size {
+		t.Errorf("Fingerprint = %q, want SHA256: and an unpadded base64 digest", fp)
+	}
+	if want := sha256.Sum256(pub); !bytes.Equal(sum, want[:]) {
+		t.Errorf("Fingerprint = %q, not the digest of the key", fp)
+	}
+}
@@ -0,0 +1,172 @@
+// Package shamir implements Shamir's secret sharing over GF(256), splitting
+// a secret into n shares of which any k reconstruct it while fewer reveal
+// nothing about it.
+//
+// Shares are exchanged as text of the form
+//
+//	shamir1.<set>.<k>.<x>.<data>
+//
+// where set is a random identifier shared by the shares of one split, x the
+// share's evaluation point and data the base64url encoded share bytes.
+package shamir
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// exp and log tables of GF(256) with the AES polynomial and generator 3.
+var expTable, logTable [256]byte
+
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		expTable[i] = x
+		logTable[x] = byte(i)
+		x ^= mulSlow(x, 2)
+	}
+	expTable[255] = expTable[0]
+}
+
+func mulSlow(a, b byte) byte {
+	var p byte
+	for b > 0 {
+		if b&1 != 0 {
+			p ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1b
+		}
+		b >>= 1
+	}
+	return p
+}
+
+func mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])+int(logTable[b]))%255]
+}
+
+func div(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])-int(logTable[b])+255)%255]
+}
+
+// Share is one share of a secret.
+type Share struct {
+	Set       string
+	Threshold int
+	X         byte
+	Y         []byte
+}
+
+// Split divides secret into n shares with threshold k.
+func Split(secret []byte, n, k int) ([]Share, error) {
+	if k < 2 || k > n || n > 255 {
+		return nil, errors.New("need 2 <= k <= n <= 255")
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("secret is empty")
+	}
+
+	set := make([]byte, 4)
+	rand.Read(set)
+
+	shares := make([]Share, n)
+	for i := range shares {
+		shares[i] = Share{Set: hex.EncodeToString(set), Threshold: k, X: byte(i + 1), Y: make([]byte, len(secret))}
+	}
+
+	coeffs := make([]byte, k)
+	for j, s := range secret {
+		// A random polynomial of degree k-1 whose constant term is the
+		// secret byte, evaluated at each x with Horner's method.
+		rand.Read(coeffs[1:])
+		coeffs[0] = s
+		for i := range shares {
+			x := shares[i].X
+			var y byte
+			for d := k - 1; d >= 0; d-- {
+				y = mul(y, x) ^ coeffs[d]
+			}
+			shares[i].Y[j] = y
+		}
+	}
+	return shares, nil
+}
+
+// Combine reconstructs the secret from at least Threshold shares of the same
+// split by Lagrange interpolation at zero.
+func Combine(shares []Share) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no shares")
+	}
+	first := shares[0]
+	if len(shares) < first.Threshold {
+		return nil, fmt.Errorf("need %d shares, have %d", first.Threshold, len(shares))
+	}
+
+	seen := make(map[byte]bool)
+	for _, s := range shares {
+		if s.Set != first.Set || s.Threshold != first.Threshold || len(s.Y) != len(first.Y) {
+			return nil, errors.New("shares come from different splits")
+		}
+		if s.X == 0 || seen[s.X] {
+			return nil, errors.New("duplicate or invalid share")
+		}
+		seen[s.X] = true
+	}
+	shares = shares[:first.Threshold]
+
+	secret := make([]byte, len(first.Y))
+	for i, si := range shares {
+		// Lagrange basis polynomial for si evaluated at 0. Subtraction is
+		// XOR in GF(256).
+		basis := byte(1)
+		for j, sj := range shares {
+			if i != j {
+				basis = mul(basis, div(sj.X, sj.X^si.X))
+			}
+		}
+		for b := range secret {
+			secret[b] ^= mul(si.Y[b], basis)
+		}
+	}
+	return secret, nil
+}
+
+func (s Share) String() string {
+	return fmt.Sprintf("shamir1.%s.%d.%d.%s", s.Set, s.Threshold, s.X, base64.RawURLEncoding.EncodeToString(s.Y))
+}
+
+func Parse(text string) (Share, error) {
+	parts := strings.Split(strings.TrimSpace(text), ".")
+	if len(parts) != 5 || parts[0] != "shamir1" {
+		return Share{}, errors.New("not a share")
+	}
+
+	k, err := strconv.Atoi(parts[2])
+	if err != nil || k < 2 {
+		return Share{}, errors.New("invalid share threshold")
+	}
+	x, err := strconv.ParseUint(parts[3], 10, 8)
+	if err != nil || x == 0 {
+		return Share{}, errors.New("invalid share index")
+	}
+	y, err := base64.RawURLEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Share{}, errors.New("invalid share data")
+	}
+	return Share{Set: parts[1], Threshold: k, X: byte(x), Y: y}, nil
+}
@@ -0,0 +1,136 @@
+package shamir
+
+import (
+	"bytes"
+	"testing"
+)
+
+// subsets calls fn with every subset of shares of the given size.
+func subsets(shares []Share, size int, fn func([]Share)) {
+	var pick func(start int, chosen []Share)
+	pick = func(start int, chosen []Share) {
+		if len(chosen) == size {
+			fn(append([]Share(nil), chosen...))
+			return
+		}
+		for i := start; i < len(shares); i++ {
+			pick(i+1, append(chosen, shares[i]))
+		}
+	}
+	pick(0, nil)
+}
+
+func TestRoundTrip(t *testing.T) {
+	secrets := [][]byte{
+		[]byte("s"),
+		[]byte("correct horse battery staple"),
+		{0, 0, 0},
+		{0xff, 0x00, 0x80, 0x01},
+	}
+	for n := 2; n <= 6; n++ {
+		for k := 2; k <= n; k++ {
+			for _, secret := range secrets {
+				shares, err := Split(secret, n, k)
+				if err != nil {
+					t.Fatalf("Split(%d of %d): %v", k, n, err)
+				}
+				if len(shares) != n {
+					t.Fatalf("Split(%d of %d) returned %d shares", k, n, len(shares))
+				}
+				for size := k; size <= n; size++ {
+					subsets(shares, size, func(sub []Share) {
+						got, err := Combine(sub)
+						if err != nil {
+							t.Fatalf("Combine(%d of %d, %d shares): %v", k, n, size, err)
+						}
+						if !bytes.Equal(got, secret) {
+							t.Errorf("Combine(%d of %d, shares %v) = %x, want %x", k, n, xs(sub), got, secret)
+						}
+					})
+				}
+			}
+		}
+	}
+}
+
+func xs(shares []Share) []byte {
+	var x []byte
+	for _, s := range shares {
+		x = append(x, s.X)
+	}
+	return x
+}
+
+func TestSplitErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret []byte
+		n, k   int
+	}{
+		{"threshold of one", []byte("s"), 3, 1},
+		{"threshold above shares", []byte("s"), 3, 4},
+		{"too many shares", []byte("s"), 256, 2},
+		{"empty secret", nil, 3, 2},
+	}
+	for _, tt := range tests {
+		if _, err := Split(tt.secret, tt.n, tt.k); err == nil {
+			t.Errorf("%s: Split succeeded", tt.name)
+		}
+	}
+}
+
+func TestCombineErrors(t *testing.T) {
+	shares, err := Split([]byte("secret"), 4, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := Split([]byte("secret"), 4, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		shares []Share
+	}{
+		{"none", nil},
+		{"too few", shares[:2]},
+		{"duplicate", []Share{shares[0], shares[1], shares[1]}},
+		{"different splits", []Share{shares[0], shares[1], other[2]}},
+	}
+	for _, tt := range tests {
+		if _, err := Combine(tt.shares); err == nil {
+			t.Errorf("%s: Combine succeeded", tt.name)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	shares, err := Split([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range shares {
+		got, err := Parse(s.String())
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", s, err)
+		}
+		if got.Set != s.Set || got.Threshold != s.Threshold || got.X != s.X || !bytes.Equal(got.Y, s.Y) {
+			t.Errorf("Parse(%q) = %+v, want %+v", s, got, s)
+		}
+	}
+
+	for _, text := range []string{
+		"",
+		"shamir2.abcd.2.1.AQID",
+		"shamir1.abcd.1.1.AQID",
+		"shamir1.abcd.2.0.AQID",
+		"shamir1.abcd.2.256.AQID",
+		"shamir1.abcd.2.1.!!",
+		"shamir1.abcd.2.1",
+	} {
+		if _, err := Parse(text); err == nil {
+			t.Errorf("Parse(%q) succeeded", text)
+		}
+	}
+}
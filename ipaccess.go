@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// IP access rules restrict which client addresses may reach the server and
+// each namespace. They are read from the JSON file NOTE_BOARD_IP_RULES_FILE:
+//
+//	{
+//	  "trustedProxies": ["10.0.0.0/8"],
+//	  "deny": ["203.0.113.0/24"],
+//	  "namespaces": {
+//	    "finance": {"allow": ["198.51.100.0/24", "2001:db8:100::/48"]}
+//	  }
+//	}
+//
+// A deny entry always wins. When a list has allow entries, addresses outside
+// them are denied. The global list applies to every request and the
+// namespace list to requests for clips in it, including request bins and
+// mocks, which are in the "bin" and "mock" namespaces. Behind a trusted proxy the
+// client is the last address in X-Forwarded-For that is not itself a
+// trusted proxy.
+
+// prefixTrie is a binary trie of CIDR prefixes, one bit per level, for
+// lookups in time proportional to the address length.
+type prefixTrie struct {
+	root trieNode
+}
+
+type trieNode struct {
+	child  [2]*trieNode
+	prefix netip.Prefix
+	end    bool
+}
+
+func addrBit(addr netip.Addr, i int) int {
+	b := addr.AsSlice()
+	return int(b[i/8]>>(7-i%8)) & 1
+}
+
+func (t *prefixTrie) insert(p netip.Prefix) {
+	p = p.Masked()
+	n := &t.root
+	for i := 0; i < p.Bits(); i++ {
+		bit := addrBit(p.Addr(), i)
+		if n.child[bit] == nil {
+			n.child[bit] = &trieNode{}
+		}
+		n = n.child[bit]
+	}
+	n.prefix, n.end = p, true
+}
+
+// lookup returns the shortest prefix containing addr.
+func (t *prefixTrie) lookup(addr netip.Addr) (netip.Prefix, bool) {
+	n := &t.root
+	for i := 0; ; i++ {
+		if n.end {
+			return n.prefix, true
+		}
+		if i == addr.BitLen() {
+			return netip.Prefix{}, false
+		}
+		if n = n.child[addrBit(addr, i)]; n == nil {
+			return netip.Prefix{}, false
+		}
+	}
+}
+
+// cidrSet holds IPv4 and IPv6 prefixes. IPv4-mapped IPv6 addresses match
+// IPv4 prefixes.
+type cidrSet struct {
+	v4, v6 prefixTrie
+	size   int
+}
+
+func newCIDRSet(cidrs []string) (*cidrSet, error) {
+	s := &cidrSet{}
+	for _, c := range cidrs {
+		p, err := netip.ParsePrefix(c)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(c)
+			if aerr != nil {
+				return nil, fmt.Errorf("invalid CIDR %q", c)
+			}
+			p = netip.PrefixFrom(addr, addr.BitLen())
+		}
+
+		if p.Addr().Is4() {
+			s.v4.insert(p)
+		} else {
+			s.v6.insert(p)
+		}
+		s.size++
+	}
+	return s, nil
+}
+
+func (s *cidrSet) match(addr netip.Addr) (netip.Prefix, bool) {
+	if s == nil || !addr.IsValid() {
+		return netip.Prefix{}, false
+	}
+	addr = addr.Unmap()
+	if addr.Is4() {
+		return s.v4.lookup(addr)
+	}
+	return s.v6.lookup(addr.WithZone(""))
+}
+
+type accessList struct {
+	allow *cidrSet
+	deny  *cidrSet
+}
+
+// check reports whether addr passes the list and, when it does not, why.
+func (l accessList) check(addr netip.Addr) (bool, string) {
+	if p, ok := l.deny.match(addr); ok {
+		return false, "denied by " + p.String()
+	}
+	if l.allow != nil && l.allow.size > 0 {
+		if _, ok := l.allow.match(addr); !ok {
+			return false, "not in allowlist"
+		}
+	}
+	return true, ""
+}
+
+type accessListConfig struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+func (c accessListConfig) compile() (accessList, error) {
+	allow, err := newCIDRSet(c.Allow)
+	if err != nil {
+		return accessList{}, err
+	}
+	deny, err := newCIDRSet(c.Deny)
+	if err != nil {
+		return accessList{}, err
+	}
+	return accessList{allow: allow, deny: deny}, nil
+}
+
+type IPAccess struct {
+	trusted    *cidrSet
+	global     accessList
+	namespaces map[string]accessList
+	idRules    IDRules
+}
+
+func loadIPAccess(path string, idRules IDRules) (*IPAccess, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg struct {
+		TrustedProxies []string `json:"trustedProxies"`
+		accessListConfig
+		Namespaces map[string]accessListConfig `json:"namespaces"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	ia := &IPAccess{namespaces: make(map[string]accessList), idRules: idRules}
+	if ia.trusted, err = newCIDRSet(cfg.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("trustedProxies: %w", err)
+	}
+	if ia.global, err = cfg.compile(); err != nil {
+		return nil, err
+	}
+	for ns, c := range cfg.Namespaces {
+		// Namespaces are matched against normalized ids.
+		key := idRules.Namespace(ns)
+		if _, dup := ia.namespaces[key]; dup {
+			return nil, fmt.Errorf("namespace %q: listed twice once normalized", ns)
+		}
+		if ia.namespaces[key], err = c.compile(); err != nil {
+			return nil, fmt.Errorf("namespace %q: %w", ns, err)
+		}
+	}
+	return ia, nil
+}
+
+// client resolves the address of the client that sent r.
+func (ia *IPAccess) client(r *http.Request) netip.Addr {
+	peer, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}
+	}
+	addr := peer.Addr().Unmap()
+	if _, ok := ia.trusted.match(addr); !ok {
+		return addr
+	}
+
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = hop.Unmap()
+		if _, ok := ia.trusted.match(addr); !ok {
+			break
+		}
+	}
+	return addr
+}
+
+// Allowed reports whether addr may reach namespace ns.
+func (ia *IPAccess) Allowed(addr netip.Addr, ns string) bool {
+	if ia == nil {
+		return true
+	}
+	ok, _ := ia.check(addr, ns)
+	return ok
+}
+
+func (ia *IPAccess) check(addr netip.Addr, ns string) (bool, string) {
+	if ok, reason := ia.global.check(addr); !ok {
+		return false, reason
+	}
+	if l, exists := ia.namespaces[ns]; exists {
+		if ok, reason := l.check(addr); !ok {
+			return false, fmt.Sprintf("%s for namespace %q", reason, ns)
+		}
+	}
+	return true, ""
+}
+
+// requestNamespace returns the namespace r addresses through ?id= or a
+// /clips ?prefix=.
+func (ia *IPAccess) requestNamespace(r *http.Request) string {
+	q := r.URL.Query()
+	if id := q.Get("id"); id != "" {
+		if norm, err := ia.idRules.Normalize(id); err == nil {
+			id = norm
+		}
+		return namespaceOf(id)
+	}
+	if prefix := q.Get("prefix"); strings.Contains(prefix, "/") {
+		return ia.idRules.Namespace(namespaceOf(prefix))
+	}
+	return ""
+}
+
+// reachable checks the namespace of id against the rules for the client of
+// r, writing the error response when it is denied. Handlers call it for ids
+// the middleware cannot see: those taken from the path and those renamed by
+// hooks.
+func (b *board) reachable(w http.ResponseWriter, r *http.Request, id string) bool {
+	if b.ipAccess == nil {
+		return true
+	}
+	addr, _ := requestAddr(r)
+	if ok, reason := b.ipAccess.check(addr, namespaceOf(id)); !ok {
+		log.Printf("ipaccess: denied %s %s for %q from %s: %s", r.Method, r.URL.Path, id, addr, reason)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+type clientAddrKey struct{}
+
+// requestAddr returns the client address resolved by IPAccess.Middleware.
+func requestAddr(r *http.Request) (netip.Addr, bool) {
+	addr, ok := r.Context().Value(clientAddrKey{}).(netip.Addr)
+	return addr, ok && addr.IsValid()
+}
+
+// Middleware resolves the client address and rejects requests the rules
+// deny.
+func (ia *IPAccess) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr := ia.client(r)
+
+		if ok, reason := ia.check(addr, ia.requestNamespace(r)); !ok {
+			log.Printf("ipaccess: denied %s %s from %s (peer %s): %s", r.Method, r.URL.Path, addr, r.RemoteAddr, reason)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientAddrKey{}, addr)))
+	})
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestCIDRSetMatch(t *testing.T) {
+	s, err := newCIDRSet([]string{
+		"10.0.0.0/8",
+		"10.1.0.0/16",
+		"192.0.2.7",
+		"2001:db8::/32",
+		"2001:db8:1::1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"10.1.2.3", "10.0.0.0/8"},
+		{"10.255.0.1", "10.0.0.0/8"},
+		{"11.0.0.1", ""},
+		{"192.0.2.7", "192.0.2.7/32"},
+		{"192.0.2.8", ""},
+		{"::ffff:10.0.0.1", "10.0.0.0/8"},
+		{"2001:db8:1::1", "2001:db8::/32"},
+		{"2001:db9::1", ""},
+		{"fe80::1%eth0", ""},
+		{"2001:db8::1%eth0", "2001:db8::/32"},
+		// IPv4 prefixes do not match IPv6 addresses with the same bits.
+		{"a00::1", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if p, ok := s.match(netip.MustParseAddr(tt.addr)); ok {
+			got = p.String()
+		}
+		if got != tt.want {
+			t.Errorf("match(%s) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+
+	all, err := newCIDRSet([]string{"0.0.0.0/0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := all.match(netip.MustParseAddr("203.0.113.9")); !ok {
+		t.Error("0.0.0.0/0 does not match every IPv4 address")
+	}
+	if _, ok := all.match(netip.Addr{}); ok {
+		t.Error("the zero address matches")
+	}
+	if _, ok := (*cidrSet)(nil).match(netip.MustParseAddr("10.0.0.1")); ok {
+		t.Error("a nil set matches")
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "example.com", ""} {
+		if _, err := newCIDRSet([]string{bad}); err == nil {
+			t.Errorf("newCIDRSet(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestAccessListCheck(t *testing.T) {
+	l, err := accessListConfig{Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.0.0.0/24"}}.compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range map[string]bool{
+		"10.1.0.1":        true,
+		"10.0.0.1":        false,
+		"192.0.2.1":       false,
+		"::ffff:10.1.0.1": true,
+	} {
+		if got, reason := l.check(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("check(%s) = %v (%s), want %v", addr, got, reason, want)
+		}
+	}
+
+	open, err := accessListConfig{Deny: []string{"192.0.2.0/24"}}.compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := open.check(netip.MustParseAddr("198.51.100.1")); !ok {
+		t.Error("a list without allow entries denies")
+	}
+}
+
+func TestIPAccessClient(t *testing.T) {
+	ia, err := loadIPAccess(writeTemp(t, "ip.json", `{"trustedProxies": ["10.0.0.0/8", "fd00::/8"]}`), IDRules{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		want   string
+	}{
+		{"direct", "192.0.2.1:1234", nil, "192.0.2.1"},
+		{"untrusted peer cannot forward", "192.0.2.1:1234", []string{"198.51.100.1"}, "192.0.2.1"},
+		{"trusted proxy", "10.0.0.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"proxy chain", "10.0.0.1:1234", []string{"198.51.100.1, 10.0.0.2, 10.0.0.3"}, "198.51.100.1"},
+		{"spoofed hops before the client", "10.0.0.1:1234", []string{"203.0.113.5, 198.51.100.1"}, "198.51.100.1"},
+		{"several headers", "10.0.0.1:1234", []string{"203.0.113.5", "198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"mapped addresses", "[::ffff:10.0.0.1]:1234", []string{"::ffff:198.51.100.1"}, "198.51.100.1"},
+		{"IPv6 proxy", "[fd00::1]:1234", []string{"2001:db8::1"}, "2001:db8::1"},
+		{"no header", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"only proxies", "10.0.0.1:1234", []string{"10.0.0.2"}, "10.0.0.2"},
+		{"garbage stops the walk", "10.0.0.1:1234", []string{"198.51.100.1, garbage, 10.0.0.2"}, "10.0.0.2"},
+		{"unparsable peer", "pipe", nil, "invalid IP"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+		for _, h := range tt.xff {
+			r.Header.Add("X-Forwarded-For", h)
+		}
+		if got := ia.client(r).String(); got != tt.want {
+			t.Errorf("%s: client = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIPAccessMiddleware(t *testing.T) {
+	b, h := newTestBoard(t)
+	b.idRules.CaseFold = true
+	var err error
+	b.ipAccess, err = loadIPAccess(writeTemp(t, "ip.json", `{
+		"deny": ["203.0.113.0/24"],
+		"namespaces": {"Finance": {"allow": ["198.51.100.0/24"]}}
+	}`), b.idRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		addr, target string
+		want         int
+	}{
+		{"203.0.113.1", "/clips", http.StatusForbidden},
+		{"192.0.2.1", "/clips", http.StatusOK},
+		{"192.0.2.1", "/?id=finance/x", http.StatusForbidden},
+		{"192.0.2.1", "/?id=FINANCE/x", http.StatusForbidden},
+		{"192.0.2.1", "/clips?prefix=Finance/", http.StatusForbidden},
+		{"198.51.100.1", "/?id=finance/x", http.StatusNotFound},
+		{"192.0.2.1", "/?id=notes/x", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.target, nil)
+		r.RemoteAddr = tt.addr + ":1234"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("GET %s from %s: status %d, want %d", tt.target, tt.addr, w.Code, tt.want)
+		}
+	}
+
+	for _, config := range []string{
+		`{"trustedProxies": ["nope"]}`,
+		`{"deny": ["10.0.0.0/99"]}`,
+		`{"namespaces": {"a": {"allow": ["x"]}}}`,
+		`{"namespaces": {"a": {}, "A": {}}}`,
+	} {
+		if _, err := loadIPAccess(writeTemp(t, "ip.json", config), b.idRules); err == nil {
+			t.Errorf("loadIPAccess(%s) succeeded", config)
+		}
+	}
+}
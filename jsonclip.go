@@ -0,0 +1,513 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// JSON clips are decoded with UseNumber so that patching a document does not
+// change the precision of numbers it did not touch.
+
+func decodeJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after the JSON value")
+	}
+	return v, nil
+}
+
+func encodeJSON(v any) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// parseJSONPointer splits an RFC 6901 pointer into its unescaped tokens.
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if pointer[0] != '/' {
+		return nil, fmt.Errorf("JSON pointer %q must start with \"/\"", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return length, nil
+	}
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	last := length - 1
+	if allowEnd {
+		last = length
+	}
+	if i > last {
+		return 0, fmt.Errorf("array index %d out of range", i)
+	}
+	return i, nil
+}
+
+func pointerGet(doc any, tokens []string) (any, error) {
+	for _, t := range tokens {
+		switch node := doc.(type) {
+		case map[string]any:
+			child, ok := node[t]
+			if !ok {
+				return nil, fmt.Errorf("member %q not found", t)
+			}
+			doc = child
+		case []any:
+			i, err := arrayIndex(t, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("cannot descend into a scalar at %q", t)
+		}
+	}
+	return doc, nil
+}
+
+// pointerUpdate returns doc with fn applied to the parent of the location named
+// by tokens. fn gets the container and the last token and returns the new
+// container.
+func pointerUpdate(doc any, tokens []string, fn func(parent any, token string) (any, error)) (any, error) {
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("the document root cannot be the target of this operation")
+	}
+	if len(tokens) == 1 {
+		return fn(doc, tokens[0])
+	}
+
+	t := tokens[0]
+	switch node := doc.(type) {
+	case map[string]any:
+		child, ok := node[t]
+		if !ok {
+			return nil, fmt.Errorf("member %q not found", t)
+		}
+		updated, err := pointerUpdate(child, tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		node[t] = updated
+		return node, nil
+	case []any:
+		i, err := arrayIndex(t, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := pointerUpdate(node[i], tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = updated
+		return node, nil
+	default:
+		return nil, fmt.Errorf("cannot descend into a scalar at %q", t)
+	}
+}
+
+func pointerAdd(doc any, tokens []string, value any) (any, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return pointerUpdate(doc, tokens, func(parent any, token string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			node[token] = value
+			return node, nil
+		case []any:
+			i, err := arrayIndex(token, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = value
+			return node, nil
+		default:
+			return nil, fmt.Errorf("cannot add a member to a scalar")
+		}
+	})
+}
+
+func pointerRemove(doc any, tokens []string) (any, error) {
+	return pointerUpdate(doc, tokens, func(parent any, token string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			if _, ok := node[token]; !ok {
+				return nil, fmt.Errorf("member %q not found", token)
+			}
+			delete(node, token)
+			return node, nil
+		case []any:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(node[:i], node[i+1:]...), nil
+		default:
+			return nil, fmt.Errorf("cannot remove a member from a scalar")
+		}
+	})
+}
+
+// jsonEqual compares two decoded documents, treating numbers as equal when
+// their values are, as RFC 6902 requires for "test".
+func jsonEqual(a, b any) bool {
+	switch x := a.(type) {
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		fx, _, errx := big.ParseFloat(string(x), 10, 256, big.ToNearestEven)
+		fy, _, erry := big.ParseFloat(string(y), 10, 256, big.ToNearestEven)
+		return errx == nil && erry == nil && fx.Cmp(fy) == 0
+	case map[string]any:
+		y, ok := b.(map[string]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			if w, ok := y[k]; !ok || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		y, ok := b.([]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !jsonEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+func deepCopyJSON(v any) any {
+	switch x := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(x))
+		for k, v := range x {
+			m[k] = deepCopyJSON(v)
+		}
+		return m
+	case []any:
+		s := make([]any, len(x))
+		for i, v := range x {
+			s[i] = deepCopyJSON(v)
+		}
+		return s
+	default:
+		return v
+	}
+}
+
+type jsonPatchOp struct {
+	Op    string          `json:"op"`
+	Path  *string         `json:"path"`
+	From  *string         `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// applyJSONPatch applies an RFC 6902 patch to doc. Either every operation
+// succeeds or an error naming the failing operation is returned.
+func applyJSONPatch(doc string, patch []byte) (string, error) {
+	var ops []jsonPatchOp
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return "", fmt.Errorf("patch must be a JSON array of operations: %w", err)
+	}
+
+	target, err := decodeJSON([]byte(doc))
+	if err != nil {
+		return "", err
+	}
+
+	for i, op := range ops {
+		target, err = applyJSONPatchOp(target, op)
+		if err != nil {
+			path := ""
+			if op.Path != nil {
+				path = *op.Path
+			}
+			return "", fmt.Errorf("operation %d (%s %s): %w", i, op.Op, path, err)
+		}
+	}
+
+	return encodeJSON(target)
+}
+
+func applyJSONPatchOp(doc any, op jsonPatchOp) (any, error) {
+	if op.Path == nil {
+		return nil, fmt.Errorf("missing \"path\"")
+	}
+	path, err := parseJSONPointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	value := func() (any, error) {
+		if op.Value == nil {
+			return nil, fmt.Errorf("missing \"value\"")
+		}
+		return decodeJSON(op.Value)
+	}
+	from := func() ([]string, error) {
+		if op.From == nil {
+			return nil, fmt.Errorf("missing \"from\"")
+		}
+		return parseJSONPointer(*op.From)
+	}
+
+	switch op.Op {
+	case "add":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, v)
+
+	case "remove":
+		return pointerRemove(doc, path)
+
+	case "replace":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := pointerGet(doc, path); err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return v, nil
+		}
+		doc, err = pointerRemove(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, v)
+
+	case "move":
+		src, err := from()
+		if err != nil {
+			return nil, err
+		}
+		if *op.Path != *op.From && strings.HasPrefix(*op.Path, *op.From+"/") {
+			return nil, fmt.Errorf("cannot move a value into one of its children")
+		}
+		v, err := pointerGet(doc, src)
+		if err != nil {
+			return nil, err
+		}
+		doc, err = pointerRemove(doc, src)
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, v)
+
+	case "copy":
+		src, err := from()
+		if err != nil {
+			return nil, err
+		}
+		v, err := pointerGet(doc, src)
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, deepCopyJSON(v))
+
+	case "test":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		current, err := pointerGet(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(current, v) {
+			return nil, fmt.Errorf("test failed")
+		}
+		return doc, nil
+
+	default:
+		return nil, fmt.Errorf("unknown operation %q", op.Op)
+	}
+}
+
+// applyMergePatch applies an RFC 7396 merge patch to doc.
+func applyMergePatch(doc string, patch []byte) (string, error) {
+	p, err := decodeJSON(patch)
+	if err != nil {
+		return "", fmt.Errorf("invalid merge patch: %w", err)
+	}
+	target, err := decodeJSON([]byte(doc))
+	if err != nil {
+		return "", err
+	}
+	return encodeJSON(mergePatch(target, p))
+}
+
+func mergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = make(map[string]any)
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = mergePatch(t[k], v)
+		}
+	}
+	return t
+}
+
+// jsonPathQuery evaluates the subset of JSONPath made of "$" followed by
+// ".name", "['name']", "[index]", ".*" and "[*]" steps. Paths with a
+// wildcard return the list of matches; other paths return the single match.
+func jsonPathQuery(doc any, path string) (any, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("JSONPath %q must start with \"$\"", path)
+	}
+
+	nodes := []any{doc}
+	wildcard := false
+	rest := path[1:]
+
+	for rest != "" {
+		var step string
+		switch {
+		case strings.HasPrefix(rest, ".*"), strings.HasPrefix(rest, "[*]"):
+			if rest[0] == '.' {
+				rest = rest[2:]
+			} else {
+				rest = rest[3:]
+			}
+			wildcard = true
+			var next []any
+			for _, n := range nodes {
+				switch node := n.(type) {
+				case map[string]any:
+					for _, k := range slices.Sorted(maps.Keys(node)) {
+						next = append(next, node[k])
+					}
+				case []any:
+					next = append(next, node...)
+				}
+			}
+			nodes = next
+			continue
+
+		case strings.HasPrefix(rest, "['"):
+			end := strings.Index(rest, "']")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated ['...'] in JSONPath %q", path)
+			}
+			step, rest = rest[2:end], rest[end+2:]
+
+		case strings.HasPrefix(rest, "["):
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated [...] in JSONPath %q", path)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid index %q in JSONPath %q", rest[1:end], path)
+			}
+			rest = rest[end+1:]
+
+			var next []any
+			for _, n := range nodes {
+				if node, ok := n.([]any); ok {
+					i := index
+					if i < 0 {
+						i += len(node)
+					}
+					if i >= 0 && i < len(node) {
+						next = append(next, node[i])
+					}
+				}
+			}
+			nodes = next
+			continue
+
+		case strings.HasPrefix(rest, "."):
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				step, rest = rest[1:], ""
+			} else {
+				step, rest = rest[1:end+1], rest[end+1:]
+			}
+			if step == "" {
+				return nil, fmt.Errorf("empty member name in JSONPath %q", path)
+			}
+
+		default:
+			return nil, fmt.Errorf("unexpected %q in JSONPath %q", rest, path)
+		}
+
+		var next []any
+		for _, n := range nodes {
+			if node, ok := n.(map[string]any); ok {
+				if child, ok := node[step]; ok {
+					next = append(next, child)
+				}
+			}
+		}
+		nodes = next
+	}
+
+	if wildcard {
+		if nodes == nil {
+			nodes = []any{}
+		}
+		return nodes, nil
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("JSONPath %q matched nothing", path)
+	}
+	return nodes[0], nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Clip metadata is a set of key/value labels stored next to the value. Keys
+// are lower case so that they survive the round trip through HTTP headers.
+
+const (
+	metaHeaderPrefix = "X-Board-Meta-"
+	maxMetaLabels    = 32
+	maxMetaValueLen  = 256
+)
+
+var metaKeyPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9._-]{0,61}[a-z0-9])?$`)
+
+func checkMeta(meta map[string]string) error {
+	if len(meta) > maxMetaLabels {
+		return fmt.Errorf("at most %d metadata labels are allowed", maxMetaLabels)
+	}
+	for k, v := range meta {
+		if !metaKeyPattern.MatchString(k) {
+			return fmt.Errorf("invalid metadata key %q: use lower case letters, digits, '.', '_' and '-'", k)
+		}
+		if len(v) > maxMetaValueLen {
+			return fmt.Errorf("metadata %q is longer than %d bytes", k, maxMetaValueLen)
+		}
+		if strings.IndexFunc(v, unicode.IsControl) >= 0 {
+			return fmt.Errorf("metadata %q contains control characters", k)
+		}
+	}
+	return nil
+}
+
+// metaFromHeaders collects X-Board-Meta-* request headers.
+func metaFromHeaders(h http.Header) map[string]string {
+	var meta map[string]string
+	for name, values := range h {
+		if len(name) > len(metaHeaderPrefix) && strings.EqualFold(name[:len(metaHeaderPrefix)], metaHeaderPrefix) {
+			if meta == nil {
+				meta = make(map[string]string)
+			}
+			meta[strings.ToLower(name[len(metaHeaderPrefix):])] = values[0]
+		}
+	}
+	return meta
+}
+
+func writeMetaHeaders(w http.ResponseWriter, meta map[string]string) {
+	for k, v := range meta {
+		w.Header().Set(metaHeaderPrefix+k, v)
+	}
+}
+
+type selectorOp int
+
+const (
+	selectorEquals selectorOp = iota
+	selectorNotEquals
+	selectorIn
+	selectorNotIn
+	selectorExists
+	selectorNotExists
+)
+
+type selectorRequirement struct {
+	key    string
+	op     selectorOp
+	values []string
+}
+
+func (req selectorRequirement) matches(meta map[string]string) bool {
+	v, ok := meta[req.key]
+
+	switch req.op {
+	case selectorEquals:
+		return ok && v == req.values[0]
+	case selectorNotEquals:
+		return !ok || v != req.values[0]
+	case selectorIn:
+		return ok && slices.Contains(req.values, v)
+	case selectorNotIn:
+		return !ok || !slices.Contains(req.values, v)
+	case selectorExists:
+		return ok
+	case selectorNotExists:
+		return !ok
+	}
+	return false
+}
+
+// labelSelector selects clips by metadata. It uses the Kubernetes label
+// selector syntax: comma separated requirements of the forms "k=v", "k==v",
+// "k!=v", "k in (a,b)", "k notin (a,b)", "k" and "!k", all of which must
+// hold.
+type labelSelector []selectorRequirement
+
+func (s labelSelector) Matches(meta map[string]string) bool {
+	for _, req := range s {
+		if !req.matches(meta) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseLabelSelector(s string) (labelSelector, error) {
+	var sel labelSelector
+
+	for _, part := range splitSelector(s) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		req, err := parseSelectorRequirement(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %w", part, err)
+		}
+		sel = append(sel, req)
+	}
+
+	return sel, nil
+}
+
+// splitSelector splits on commas that are not inside a parenthesized set.
+func splitSelector(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func parseSelectorRequirement(part string) (selectorRequirement, error) {
+	if strings.HasPrefix(part, "!") {
+		key := strings.TrimSpace(part[1:])
+		if !metaKeyPattern.MatchString(key) {
+			return selectorRequirement{}, fmt.Errorf("invalid key %q", key)
+		}
+		return selectorRequirement{key: key, op: selectorNotExists}, nil
+	}
+
+	for _, o := range []struct {
+		token string
+		op    selectorOp
+	}{{"!=", selectorNotEquals}, {"==", selectorEquals}, {"=", selectorEquals}} {
+		if i := strings.Index(part, o.token); i >= 0 {
+			key := strings.TrimSpace(part[:i])
+			if !metaKeyPattern.MatchString(key) {
+				return selectorRequirement{}, fmt.Errorf("invalid key %q", key)
+			}
+			return selectorRequirement{key: key, op: o.op, values: []string{strings.TrimSpace(part[i+len(o.token):])}}, nil
+		}
+	}
+
+	fields := strings.Fields(part)
+	if len(fields) == 1 {
+		if !metaKeyPattern.MatchString(fields[0]) {
+			return selectorRequirement{}, fmt.Errorf("invalid key %q", fields[0])
+		}
+		return selectorRequirement{key: fields[0], op: selectorExists}, nil
+	}
+
+	if len(fields) < 3 || (fields[1] != "in" && fields[1] != "notin") {
+		return selectorRequirement{}, fmt.Errorf("expected \"key in (values)\" or \"key notin (values)\"")
+	}
+	key := fields[0]
+	if !metaKeyPattern.MatchString(key) {
+		return selectorRequirement{}, fmt.Errorf("invalid key %q", key)
+	}
+
+	set := strings.TrimSpace(strings.Join(fields[2:], " "))
+	if !strings.HasPrefix(set, "(") || !strings.HasSuffix(set, ")") {
+		return selectorRequirement{}, fmt.Errorf("values must be in parentheses")
+	}
+	var values []string
+	for _, v := range strings.Split(set[1:len(set)-1], ",") {
+		values = append(values, strings.TrimSpace(v))
+	}
+
+	op := selectorIn
+	if fields[1] == "notin" {
+		op = selectorNotIn
+	}
+	return selectorRequirement{key: key, op: op, values: values}, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLabelSelector(t *testing.T) {
+	tests := []struct {
+		in   string
+		want labelSelector
+	}{
+		{"", nil},
+		{" , ", nil},
+		{"env=prod", labelSelector{{"env", selectorEquals, []string{"prod"}}}},
+		{"env == prod", labelSelector{{"env", selectorEquals, []string{"prod"}}}},
+		{"env!=prod", labelSelector{{"env", selectorNotEquals, []string{"prod"}}}},
+		{"env=", labelSelector{{"env", selectorEquals, []string{""}}}},
+		{"env in (prod, staging)", labelSelector{{"env", selectorIn, []string{"prod", "staging"}}}},
+		{"env notin (dev)", labelSelector{{"env", selectorNotIn, []string{"dev"}}}},
+		{"team", labelSelector{{"team", selectorExists, nil}}},
+		{"! team", labelSelector{{"team", selectorNotExists, nil}}},
+		{"env in (a,b),team,!draft", labelSelector{
+			{"env", selectorIn, []string{"a", "b"}},
+			{"team", selectorExists, nil},
+			{"draft", selectorNotExists, nil},
+		}},
+	}
+	for _, tt := range tests {
+		got, err := parseLabelSelector(tt.in)
+		if err != nil {
+			t.Errorf("parseLabelSelector(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseLabelSelector(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{
+		"Env=prod",
+		"=prod",
+		"!",
+		"!Env",
+		"env prod",
+		"env in prod",
+		"env in (prod",
+		"env within (prod)",
+		"bad key in (a)",
+	} {
+		if _, err := parseLabelSelector(in); err == nil {
+			t.Errorf("parseLabelSelector(%q) succeeded", in)
+		}
+	}
+}
+
+func TestLabelSelectorMatches(t *testing.T) {
+	meta := map[string]string{"env": "prod", "team": "web"}
+	tests := []struct {
+		sel  string
+		want bool
+	}{
+		{"", true},
+		{"env=prod", true},
+		{"env=dev", false},
+		{"env!=dev", true},
+		{"owner!=bob", true},
+		{"env in (dev,prod)", true},
+		{"owner in (bob)", false},
+		{"env notin (prod)", false},
+		{"owner notin (bob)", true},
+		{"team", true},
+		{"owner", false},
+		{"!owner", true},
+		{"!team", false},
+		{"env=prod,team=web", true},
+		{"env=prod,team=api", false},
+	}
+	for _, tt := range tests {
+		sel, err := parseLabelSelector(tt.sel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sel.Matches(meta); got != tt.want {
+			t.Errorf("%q matches %v = %v, want %v", tt.sel, meta, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"note-board/internal/provenance"
+)
+
+// Log clips are append-only. Producers add chunks with POST ?append=true,
+// creating the log with the first one, and close it with close=true.
+// Consumers read them like any clip or follow them with GET ?follow=1,
+// which streams the content so far and then every append until the log is
+// closed, deleted or expires.
+
+// logFollowCheck is how often a follower looks for a log that was replaced
+// by a write other than an append. Appends, deletes and expiry wake
+// followers at once.
+const logFollowCheck = 15 * time.Second
+
+var (
+	errLogClosed = errors.New("log is closed")
+	errNotLog    = errors.New("only log clips can be appended to")
+	errLogFull   = errors.New("log is full")
+)
+
+// logHub wakes the followers of a log when it is appended to, deleted or
+// expires.
+type logHub struct {
+	mu   sync.Mutex
+	subs map[string]map[chan struct{}]struct{}
+}
+
+func newLogHub() *logHub {
+	return &logHub{subs: make(map[string]map[chan struct{}]struct{})}
+}
+
+func (h *logHub) subscribe(id string) chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan struct{}, 1)
+	if h.subs[id] == nil {
+		h.subs[id] = make(map[chan struct{}]struct{})
+	}
+	h.subs[id][ch] = struct{}{}
+	return ch
+}
+
+func (h *logHub) unsubscribe(id string, ch chan struct{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.subs[id], ch)
+	if len(h.subs[id]) == 0 {
+		delete(h.subs, id)
+	}
+}
+
+func (h *logHub) notify(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subs[id] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// queryBool parses the boolean query parameter name, which is false when it
+// is missing.
+func queryBool(r *http.Request, name string) (bool, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, errors.New("`" + name + "` must be true or false")
+	}
+	return b, nil
+}
+
+// appendClip appends the request body to a log clip, creating it when it
+// does not exist, and closes it when ?close=true.
+func (b *board) appendClip(w http.ResponseWriter, r *http.Request) {
+	id, ok := b.clipID(w, r)
+	if !ok {
+		return
+	}
+	closing, err := queryBool(r, "close")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get(provenance.HeaderSignature) != "" {
+		http.Error(w, "log clips cannot be signed", http.StatusBadRequest)
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxValueSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(data) == 0 && !closing {
+		http.Error(w, "nothing to append", http.StatusBadRequest)
+		return
+	}
+
+	hc := &HookContext{Op: HookSet, ID: id, Value: string(data), User: requestUser(r)}
+	if !runHooksBefore(w, hc, b.idRules) {
+		return
+	}
+	if hc.ID != id {
+		http.Error(w, "hooks cannot rename a log that is being appended to", http.StatusUnprocessableEntity)
+		return
+	}
+	chunk, err := normalizeValue(hc.Value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ttl := b.store.ttl
+	if v := r.URL.Query().Get("ttl"); v != "" {
+		ttl, err = time.ParseDuration(v)
+		if err != nil || ttl <= 0 {
+			http.Error(w, "`ttl` must be a positive duration such as 30m", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var log storedValue
+	err = b.store.UpdateWithTTL(id, ttl, func(cur storedValue, exists bool) (storedValue, error) {
+		switch {
+		case !exists:
+			cur = storedValue{kind: clipLog, owner: clipOwner(r)}
+		case cur.kind != clipLog:
+			return cur, errNotLog
+		case cur.closed:
+			return cur, errLogClosed
+		}
+		if len(cur.value)+len(chunk) > maxValueSize {
+			return cur, errLogFull
+		}
+		if err := b.schemas.validateUpdate(id, cur.value+chunk); err != nil {
+			return cur, err
+		}
+		cur.value += chunk
+		cur.closed = closing
+		cur.sig = nil
+		log = cur
+		return cur, nil
+	})
+	var invalid *schemaError
+	switch {
+	case errors.As(err, &invalid):
+		writeSchemaProblem(w, id, invalid.violations)
+		return
+	case errors.Is(err, errLogFull):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	b.logs.notify(id)
+
+	b.stats.RecordWrite(hc.User, id, b.store.Size())
+	hc.Value = chunk
+	runAfterHooks(hc)
+	writeAnnotations(w, hc)
+	b.scripts.Notify("set", id, log.value, hc.User)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"id":     id,
+		"size":   len(log.value),
+		"closed": log.closed,
+	})
+}
+
+// followLog streams the log id from the start and then as it grows, until
+// it is closed or goes away.
+func (b *board) followLog(w http.ResponseWriter, r *http.Request, id string) {
+	ch := b.logs.subscribe(id)
+	defer b.logs.unsubscribe(id, ch)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Board-Type", clipLog)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	check := time.NewTicker(logFollowCheck)
+	defer check.Stop()
+
+	offset := 0
+	for {
+		e, exists := b.store.Lookup(id)
+		if !exists || e.Value.kind != clipLog || len(e.Value.value) < offset {
+			return
+		}
+		if chunk := e.Value.value[offset:]; chunk != "" {
+			if _, err := io.WriteString(w, chunk); err != nil || rc.Flush() != nil {
+				return
+			}
+			offset += len(chunk)
+		}
+		if e.Value.closed {
+			return
+		}
+
+		select {
+		case <-ch:
+		case <-check.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogAppendAndClose(t *testing.T) {
+	b, h := newTestBoard(t)
+
+	tests := []struct {
+		target, body string
+		status       int
+		want         string
+	}{
+		{"/?id=ci/log&append", "one\n", http.StatusOK, "one\n"},
+		{"/?id=ci/log&append", "two\n", http.StatusOK, "one\ntwo\n"},
+		{"/?id=ci/log&append", "", http.StatusBadRequest, "one\ntwo\n"},
+		{"/?id=ci/log&append&close=maybe", "x", http.StatusBadRequest, "one\ntwo\n"},
+		{"/?id=ci/log&append&close=true", "three\n", http.StatusOK, "one\ntwo\nthree\n"},
+		{"/?id=ci/log&append", "four\n", http.StatusConflict, "one\ntwo\nthree\n"},
+		{"/?id=ci/log&append&close=true", "", http.StatusConflict, "one\ntwo\nthree\n"},
+	}
+	for _, tt := range tests {
+		w := serve(h, "POST", tt.target, tt.body, nil)
+		if w.Code != tt.status {
+			t.Errorf("POST %s %q: status %d, want %d: %s", tt.target, tt.body, w.Code, tt.status, w.Body)
+		}
+		if got := b.store.Get("ci/log"); got != tt.want {
+			t.Errorf("after POST %s %q: log = %q, want %q", tt.target, tt.body, got, tt.want)
+		}
+	}
+	e, _ := b.store.Lookup("ci/log")
+	if e.Value.kind != clipLog || !e.Value.closed {
+		t.Errorf("log = %+v, want a closed log", e.Value)
+	}
+
+	// Closing without a chunk is allowed.
+	serve(h, "POST", "/?id=ci/empty&append", "x", nil)
+	if w := serve(h, "POST", "/?id=ci/empty&append&close=true", "", nil); w.Code != http.StatusOK {
+		t.Errorf("close without a chunk: status %d: %s", w.Code, w.Body)
+	}
+
+	b.store.Put("notes/a", storedValue{kind: clipText, value: "text"}, time.Hour)
+	if w := serve(h, "POST", "/?id=notes/a&append", "more", nil); w.Code != http.StatusConflict {
+		t.Errorf("append to a text clip: status %d, want 409", w.Code)
+	}
+	b.store.Put("ci/full", storedValue{kind: clipLog, value: strings.Repeat("x", maxValueSize)}, time.Hour)
+	if w := serve(h, "POST", "/?id=ci/full&append", "x", nil); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("append to a full log: status %d, want 413", w.Code)
+	}
+}
+
+// followLog opens a follower of id on srv and returns its body once the
+// first line has arrived.
+func followLog(t *testing.T, srv *httptest.Server, id string) *bufio.Reader {
+	t.Helper()
+	resp, err := http.Get(srv.URL + "/?follow=1&id=" + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("follow: status %d", resp.StatusCode)
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+func TestLogFollow(t *testing.T) {
+	_, h := newTestBoard(t)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	serve(h, "POST", "/?id=ci/log&append", "one\n", nil)
+	body := followLog(t, srv, "ci/log")
+	if line, err := body.ReadString('\n'); line != "one\n" || err != nil {
+		t.Fatalf("first line = %q, %v", line, err)
+	}
+
+	serve(h, "POST", "/?id=ci/log&append", "two\n", nil)
+	if line, err := body.ReadString('\n'); line != "two\n" || err != nil {
+		t.Fatalf("appended line = %q, %v", line, err)
+	}
+
+	serve(h, "POST", "/?id=ci/log&append&close=true", "three\n", nil)
+	rest, err := io.ReadAll(body)
+	if string(rest) != "three\n" || err != nil {
+		t.Errorf("rest after close = %q, %v; want the last line and the end", rest, err)
+	}
+
+	serve(h, "POST", "/?id=ci/gone&append", "one\n", nil)
+	body = followLog(t, srv, "ci/gone")
+	if line, _ := body.ReadString('\n'); line != "one\n" {
+		t.Fatalf("first line = %q", line)
+	}
+	serve(h, "DELETE", "/?id=ci/gone", "", nil)
+	if rest, err := io.ReadAll(body); len(rest) != 0 || err != nil {
+		t.Errorf("rest after delete = %q, %v; want the end", rest, err)
+	}
+
+	if w := serve(h, "GET", "/?follow=1&id=ci/log&follow=1", "", nil); w.Code != http.StatusOK {
+		t.Errorf("follow of a closed log: status %d", w.Code)
+	}
+	serve(h, "POST", "/?id=notes/a&value=x", "", nil)
+	if w := serve(h, "GET", "/?follow=1&id=notes/a", "", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("follow of a text clip: status %d, want 400", w.Code)
+	}
+}
@@ -1,131 +1,356 @@
 package main
 
 import (
-	"encoding/json"
 	"log"
+	"net"
 	"net/http"
-	"sync"
+	"os"
+	"strconv"
 	"time"
+
+	"note-board/store"
 )
 
 type storedValue struct {
 	value     string
+	kind      string
+	meta      map[string]string
+	owner     string
+	sig       *clipSignature
 	timestamp time.Time
+
+	// recipients are the users an age clip is encrypted to.
+	recipients []string
+	// readers, when set, are the only users who may read the clip.
+	readers []string
+	// burn deletes the clip when it is first read.
+	burn bool
+	// closed logs take no more appends.
+	closed bool
 }
 
+type clipEntry = store.Entry[string, storedValue]
+
+type clipSnapshot = store.Snapshot[string, storedValue]
+
+// ValueStore holds the clips, keyed by normalized id. Its indexes and
+// history are updated, and every change is published on its change feed,
+// as part of each write.
 type ValueStore struct {
-	mu     sync.RWMutex
-	values map[string]storedValue
-	ttl    time.Duration
+	values  *store.Store[string, storedValue]
+	ttl     time.Duration
+	indexes *IndexManager
+	changes *changeFeed
+	history *clipHistory
+	// removed are called with the id of every clip deleted or expired.
+	removed []func(id string)
+	// extended are called with the id and new version of every clip whose
+	// expiry is extended.
+	extended []func(id string, version uint64)
 }
 
-func NewValueStore(ttl time.Duration) *ValueStore {
+// NewValueStore creates a store whose clips live for at most ttl and keep
+// their last history values. onExpire, when not nil, is called for every
+// clip that expires.
+func NewValueStore(ttl time.Duration, history int, onExpire func(id string, val storedValue)) *ValueStore {
 	vs := &ValueStore{
-		values: make(map[string]storedValue),
-		ttl:    ttl,
+		ttl:     ttl,
+		indexes: NewIndexManager(),
+		changes: newChangeFeed(),
+		history: newClipHistory(history),
 	}
 
-	go vs.startCleanupRoutine(1 * time.Hour)
+	vs.values = store.New(
+		store.WithTTL[string, storedValue](ttl),
+		store.WithCleanupInterval[string, storedValue](1*time.Hour),
+		store.WithSizer[string, storedValue](func(val storedValue) int64 { return int64(len(val.value)) }),
+		store.WithOnChange(vs.changed),
+		store.WithOnExpire(func(id string, val storedValue) {
+			if onExpire != nil {
+				onExpire(id, val)
+			}
+		}),
+	)
 
 	return vs
 }
 
-func (vs *ValueStore) Set(id string, value string) {
-	vs.mu.Lock()
-	defer vs.mu.Unlock()
-	vs.values[id] = storedValue{
-		value:     value,
-		timestamp: time.Now(),
+// changed keeps the indexes, history and change feed in step with the
+// clips. It runs with the store locked.
+func (vs *ValueStore) changed(c store.Change[string, storedValue]) {
+	id, val := c.Entry.Key, c.Entry.Value
+	change := clipChange{ID: id, Type: val.kind, meta: val.meta, readers: val.readers}
+	switch c.Op {
+	case store.OpSet:
+		vs.indexes.Update(id, val)
+		vs.history.add(id, c.Seq, val)
+		change.Op, change.Version, change.Size = "set", c.Seq, len(val.value)
+	case store.OpTouch:
+		for _, fn := range vs.extended {
+			fn(id, c.Seq)
+		}
+		change.Op, change.Version, change.Size = "extend", c.Seq, len(val.value)
+	case store.OpDelete, store.OpExpire:
+		vs.indexes.Remove(id)
+		vs.history.drop(id)
+		for _, fn := range vs.removed {
+			fn(id)
+		}
+		change.Op = "delete"
+		if c.Op == store.OpExpire {
+			change.Op = "expire"
+		}
 	}
+	vs.changes.publish(change)
+}
+
+// OnRemove registers fn to be called, with the store locked, for every clip
+// that is deleted or expires. It must be called before the store is used.
+func (vs *ValueStore) OnRemove(fn func(id string)) {
+	vs.removed = append(vs.removed, fn)
+}
+
+// OnExtend registers fn to be called, with the store locked, for every clip
+// whose expiry is extended, with the version the clip gets. It must be
+// called before the store is used.
+func (vs *ValueStore) OnExtend(fn func(id string, version uint64)) {
+	vs.extended = append(vs.extended, fn)
+}
+
+// Put stores val, stamped with the current time, so that it expires after
+// ttl, capped at the store's ttl. It returns the version of the clip.
+func (vs *ValueStore) Put(id string, val storedValue, ttl time.Duration) uint64 {
+	version, _ := vs.PutIf(id, val, ttl, nil)
+	return version
+}
+
+// PutIf is like Put but only stores val if cond, when not nil, accepts the
+// current clip.
+func (vs *ValueStore) PutIf(id string, val storedValue, ttl time.Duration, cond func(cur clipEntry, exists bool) bool) (uint64, bool) {
+	val.timestamp = time.Now()
+	return vs.values.SetIf(id, val, min(ttl, vs.ttl), cond)
+}
+
+// Update atomically replaces the clip stored under id with the result of fn.
+// The clip keeps its expiry.
+func (vs *ValueStore) Update(id string, fn func(val storedValue, exists bool) (storedValue, error)) error {
+	return vs.UpdateWithTTL(id, vs.ttl, fn)
+}
+
+// UpdateWithTTL is like Update, but a new clip expires after ttl, capped at
+// the store's ttl.
+func (vs *ValueStore) UpdateWithTTL(id string, ttl time.Duration, fn func(val storedValue, exists bool) (storedValue, error)) error {
+	return vs.values.UpdateWithTTL(id, min(ttl, vs.ttl), func(val storedValue, exists bool) (storedValue, error) {
+		val, err := fn(val, exists)
+		val.timestamp = time.Now()
+		return val, err
+	})
+}
+
+// Extend makes the clip under id expire after ttl from now, capped at the
+// store's ttl, if cond accepts it. The value is unchanged but the clip gets
+// a new version.
+func (vs *ValueStore) Extend(id string, ttl time.Duration, cond func(cur clipEntry) bool) (clipEntry, bool) {
+	return vs.values.Touch(id, min(ttl, vs.ttl), cond)
 }
 
 func (vs *ValueStore) Get(id string) string {
-	vs.mu.RLock()
-	val, exists := vs.values[id]
-	vs.mu.RUnlock()
+	val, _ := vs.values.Get(id)
+	return val.value
+}
+
+// Lookup returns the clip stored under id with its expiry time.
+func (vs *ValueStore) Lookup(id string) (clipEntry, bool) {
+	return vs.values.Lookup(id)
+}
+
+func (vs *ValueStore) Delete(id string) bool {
+	return vs.values.Delete(id)
+}
+
+// Range calls fn for every live clip until fn returns false.
+func (vs *ValueStore) Range(fn func(clipEntry) bool) {
+	vs.values.Range(fn)
+}
+
+// Snapshot opens a consistent view of the clips for long reads. It must be
+// closed.
+func (vs *ValueStore) Snapshot() *clipSnapshot {
+	return vs.values.Snapshot()
+}
+
+// OwnedBy returns the live clips recorded with the given owner.
+func (vs *ValueStore) OwnedBy(owner string) []clipEntry {
+	var owned []clipEntry
+	vs.Range(func(e clipEntry) bool {
+		if e.Value.owner == owner {
+			owned = append(owned, e)
+		}
+		return true
+	})
+	return owned
+}
+
+func (vs *ValueStore) Size() int64 {
+	return vs.values.Size()
+}
 
-	if !exists || time.Since(val.timestamp) > vs.ttl {
-		vs.mu.Lock()
-		delete(vs.values, id)
-		vs.mu.Unlock()
-		return ""
+// requestUser identifies the caller by their session or the X-Board-User
+// header, falling back to the client address for anonymous requests.
+func requestUser(r *http.Request) string {
+	if user := clipOwner(r); user != "" {
+		return user
 	}
+	return clientIP(r)
+}
 
-	return val.value
+// clipOwner is the user recorded as the owner of the clips r writes.
+func clipOwner(r *http.Request) string {
+	if user, ok := sessionUser(r); ok {
+		return user
+	}
+	return r.Header.Get("X-Board-User")
 }
 
-func (vs *ValueStore) startCleanupRoutine(interval time.Duration) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+// clientIP returns the address of the client that sent r, as resolved
+// through trusted proxies when IP access rules are configured.
+func clientIP(r *http.Request) string {
+	if addr, ok := requestAddr(r); ok {
+		return addr.String()
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
 
-	for range ticker.C {
-		now := time.Now()
+func envDuration(key string, def time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("%s: %v", key, err)
+		}
+		return d
+	}
+	return def
+}
 
-		vs.mu.Lock()
-		for id, val := range vs.values {
-			if now.Sub(val.timestamp) > vs.ttl {
-				delete(vs.values, id)
-			}
+func envInt(key string, def int) int {
+	if v := os.Getenv(key); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("%s: %v", key, err)
 		}
-		vs.mu.Unlock()
+		return n
 	}
+	return def
 }
 
 func main() {
-	store := NewValueStore(24 * time.Hour)
+	var scripts *ScriptEngine
+	store := NewValueStore(24*time.Hour, envInt("NOTE_BOARD_HISTORY", 10), func(id string, val storedValue) {
+		scripts.Notify("expire", id, val.value, val.owner)
+	})
+	statsBucket := envDuration("NOTE_BOARD_STATS_BUCKET", 1*time.Hour)
+	if statsBucket <= 0 {
+		log.Fatalf("NOTE_BOARD_STATS_BUCKET: must be positive, got %s", statsBucket)
+	}
+	statsRetention := envInt("NOTE_BOARD_STATS_RETENTION", 7*24)
+	if statsRetention <= 0 {
+		log.Fatalf("NOTE_BOARD_STATS_RETENTION: must be positive, got %d", statsRetention)
+	}
+	stats := NewStats(statsBucket, statsRetention, os.Getenv("NOTE_BOARD_STATS_FILE"))
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			id := r.URL.Query().Get("id")
+	idRules := idRulesFromEnv()
 
-			if id == "" {
-				http.Error(w, "missing ?id parameter", http.StatusBadRequest)
-				return
-			}
+	accounts := accountsFromEnv()
 
-			val := store.Get(id)
-			if val == "" {
-				http.Error(w, "not found or expired", http.StatusNotFound)
-				json.NewEncoder(w).Encode(map[string]string{
-					"message": "Clipboard not found",
-				})
-				return
-			}
+	http.HandleFunc("/admin/stats", statsHandler(stats, store))
+	http.HandleFunc("/privacy/export", exportHandler(store, stats, accounts))
+	idem := newIdempotency()
+	http.HandleFunc("/privacy/erasure", erasureHandler(NewErasureJobs(store, stats, idem, accounts)))
 
-			w.Header().Set("Content-Type", "application/json")
-			if err := json.NewEncoder(w).Encode(map[string]string{"id": id, "value": val}); err != nil {
-				http.Error(w, "internal server error", http.StatusInternalServerError)
-			}
+	schemas := NewSchemaRegistry()
+	http.HandleFunc("/schemas", schemasHandler(schemas, store, idRules))
 
-		case http.MethodPost:
-			id := r.URL.Query().Get("id")
-			val := r.URL.Query().Get("value")
+	scripts = NewScriptEngine(store, idRules, schemas)
+	http.HandleFunc("/admin/scripts", scriptsHandler(scripts))
 
-			if id == "" || val == "" {
-				http.Error(w, "`id` and `value` required", http.StatusBadRequest)
-				json.NewEncoder(w).Encode(map[string]string{
-					"message": "Sorry something went wrong",
-				})
-				return
-			}
+	if dir := os.Getenv("NOTE_BOARD_HOOK_DIR"); dir != "" {
+		if err := loadWasmHooks(dir); err != nil {
+			log.Fatalf("hooks: %v", err)
+		}
+	}
 
-			store.Set(id, val)
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(map[string]string{
-				"message": "Clip board recorded successfully",
-				"id":      id,
-				"value":   val,
-			})
-
-		default:
-			w.Header().Set("Allow", "GET, POST")
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	http.HandleFunc("/account/register", accounts.registerHandler())
+	http.HandleFunc("/account/login", accounts.loginHandler)
+	http.HandleFunc("/account/logout", accounts.logoutHandler)
+	http.HandleFunc("/account/totp/", accounts.totpHandler)
+	http.HandleFunc("/account/sessions", accounts.sessionsHandler)
+	http.HandleFunc("/account/keys", accounts.keysHandler)
+	http.HandleFunc("/keys", accounts.publicKeysHandler)
+	http.HandleFunc("/account/recipients", accounts.recipientsHandler)
+	http.HandleFunc("/recipients", accounts.publicRecipientsHandler)
+
+	var ipAccess *IPAccess
+	if path := os.Getenv("NOTE_BOARD_IP_RULES_FILE"); path != "" {
+		var err error
+		if ipAccess, err = loadIPAccess(path, idRules); err != nil {
+			log.Fatalf("ipaccess: %v", err)
 		}
-	})
+	}
+
+	var policy *PolicyEngine
+	if path := os.Getenv("NOTE_BOARD_POLICY_FILE"); path != "" {
+		var err error
+		if policy, err = loadPolicy(path); err != nil {
+			log.Fatalf("policy: %v", err)
+		}
+	}
+	http.HandleFunc("/admin/policy", policyHandler(policy, store, idRules))
+	http.HandleFunc("/admin/policy/explain", policyHandler(policy, store, idRules))
+
+	b := &board{
+		store:    store,
+		stats:    stats,
+		scripts:  scripts,
+		schemas:  schemas,
+		idRules:  idRules,
+		policy:   policy,
+		ipAccess: ipAccess,
+		accounts: accounts,
+		bins:     newBinHub(envInt("NOTE_BOARD_BIN_CAPTURES", 100)),
+		mocks:    newMockCalls(),
+		logs:     newLogHub(),
+		idem:     idem,
+	}
+	store.OnRemove(b.mocks.forget)
+	store.OnExtend(b.mocks.extend)
+	store.OnRemove(b.logs.notify)
+	http.HandleFunc("/", b.handleClip)
+	http.HandleFunc("/clips", b.listClips)
+	http.HandleFunc("/ids", b.idsHandler)
+	http.HandleFunc("/view", b.viewClip)
+	http.HandleFunc("/play", b.playCast)
+	http.HandleFunc("/query", b.queryClips)
+	http.HandleFunc("/admin/indexes", indexesHandler(store))
+	http.HandleFunc("/bin/{id}", b.captureRequest)
+	http.HandleFunc("/bin/{id}/{path...}", b.captureRequest)
+	http.HandleFunc("/bins/{id}", b.inspectBin)
+	http.HandleFunc("/bins/{id}/events", b.binEvents)
+	http.HandleFunc("/mock/{id}", b.serveMock)
+	http.HandleFunc("/mock/{id}/{path...}", b.serveMock)
+	http.HandleFunc("/changes", b.streamChanges)
+	http.HandleFunc("/history", b.listHistory)
+
+	handler := accounts.Middleware(http.DefaultServeMux)
+	if ipAccess != nil {
+		handler = ipAccess.Middleware(handler)
+	}
 
 	log.Println("Clipboard server listening on :8080 ...")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	if err := http.ListenAndServe(":8080", handler); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestBoard returns a board with default settings and an in-memory
+// store, and a handler serving its routes like main does.
+func newTestBoard(t *testing.T) (*board, http.Handler) {
+	t.Helper()
+
+	vs := NewValueStore(time.Hour, 10, nil)
+	t.Cleanup(vs.values.Close)
+	idRules := IDRules{MaxLen: 256, Reserved: map[string]bool{"admin": true, "privacy": true}}
+	schemas := NewSchemaRegistry()
+	b := &board{
+		store:    vs,
+		stats:    NewStats(time.Hour, 24, ""),
+		scripts:  NewScriptEngine(vs, idRules, schemas),
+		schemas:  schemas,
+		idRules:  idRules,
+		accounts: NewAccounts(time.Hour, nil),
+		bins:     newBinHub(100),
+		mocks:    newMockCalls(),
+		logs:     newLogHub(),
+		idem:     newIdempotency(),
+	}
+	vs.OnRemove(b.mocks.forget)
+	vs.OnExtend(b.mocks.extend)
+	vs.OnRemove(b.logs.notify)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", b.handleClip)
+	mux.HandleFunc("/clips", b.listClips)
+	mux.HandleFunc("/view", b.viewClip)
+	mux.HandleFunc("/bin/{id}", b.captureRequest)
+	mux.HandleFunc("/bin/{id}/{path...}", b.captureRequest)
+	mux.HandleFunc("/bins/{id}", b.inspectBin)
+	mux.HandleFunc("/mock/{id}", b.serveMock)
+	mux.HandleFunc("/mock/{id}/{path...}", b.serveMock)
+	return b, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Resolved on each request, so that tests may set ipAccess after
+		// creating the board.
+		var h http.Handler = b.accounts.Middleware(mux)
+		if b.ipAccess != nil {
+			h = b.ipAccess.Middleware(h)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// serve sends a request to h and returns the response.
+func serve(h http.Handler, method, target, body string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// writeTemp writes data to a file in a temporary directory and returns its
+// path.
+func writeTemp(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// withHooks replaces the registered hooks with hs for the rest of the test.
+func withHooks(t *testing.T, hs ...Hook) {
+	t.Helper()
+	hooksMu.Lock()
+	saved := hooks
+	hooks = hs
+	hooksMu.Unlock()
+
+	t.Cleanup(func() {
+		hooksMu.Lock()
+		hooks = saved
+		hooksMu.Unlock()
+	})
+}
+
+// renameHook moves every operation on the id from to the id to.
+type renameHook struct {
+	from, to string
+}
+
+func (h renameHook) Name() string { return "rename" }
+
+func (h renameHook) Before(hc *HookContext) error {
+	if hc.ID == h.from {
+		hc.ID = h.to
+	}
+	return nil
+}
+
+func (h renameHook) After(hc *HookContext) {}
+
+func body(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	data, err := io.ReadAll(w.Result().Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
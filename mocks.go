@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mock clips stub an HTTP API. A clip of type "mock" stored as mock/{id}
+// holds rules; requests to /mock/{id}/... get the responses of the first
+// rule matching their method, path and query:
+//
+//	{"rules": [{
+//	  "method": "POST",
+//	  "path": "/orders/*",
+//	  "query": {"dryRun": "true"},
+//	  "responses": [
+//	    {"status": 503, "headers": {"Retry-After": "1"}, "delay": "200ms"},
+//	    {"status": 201, "json": {"id": 42}}
+//	  ]
+//	}]}
+//
+// Successive calls matching a rule walk through its responses and then keep
+// returning the last one, or start over when the rule sets "cycle". The
+// count starts again whenever the clip is written, but not when only its
+// expiry is extended.
+//
+// Responses are served from the board's origin, so they are sandboxed and
+// never sniffed, and a mock cannot set cookies, hop-by-hop headers or the
+// headers that relax those protections. A response without a Content-Type
+// is sent as text/plain.
+
+const mockPrefix = "mock/"
+
+// maxMockDelay bounds the delay of a response.
+const maxMockDelay = 30 * time.Second
+
+// mockDroppedHeaders are the headers a mock response may not set.
+var mockDroppedHeaders = map[string]bool{
+	"Set-Cookie":                       true,
+	"Set-Cookie2":                      true,
+	"Connection":                       true,
+	"Keep-Alive":                       true,
+	"Proxy-Authenticate":               true,
+	"Proxy-Connection":                 true,
+	"Te":                               true,
+	"Trailer":                          true,
+	"Transfer-Encoding":                true,
+	"Upgrade":                          true,
+	"Content-Length":                   true,
+	"Content-Security-Policy":          true,
+	"X-Content-Type-Options":           true,
+	"Strict-Transport-Security":        true,
+	"Access-Control-Allow-Origin":      true,
+	"Access-Control-Allow-Credentials": true,
+	"Clear-Site-Data":                  true,
+	"Service-Worker-Allowed":           true,
+}
+
+type mockDef struct {
+	Rules []mockRule `json:"rules"`
+}
+
+type mockRule struct {
+	Method string `json:"method"`
+	// Path matches the request path below /mock/{id} exactly, or as a
+	// prefix when it ends in "*". An empty path matches any.
+	Path      string            `json:"path"`
+	Query     map[string]string `json:"query"`
+	Responses []mockResponse    `json:"responses"`
+	Cycle     bool              `json:"cycle"`
+}
+
+type mockResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+	// JSON, when set, is sent as the body with an application/json type.
+	JSON  json.RawMessage `json:"json"`
+	Delay string          `json:"delay"`
+
+	delay time.Duration
+}
+
+func parseMock(value string) (*mockDef, error) {
+	var def mockDef
+	dec := json.NewDecoder(strings.NewReader(value))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&def); err != nil {
+		return nil, errors.New("invalid mock: " + err.Error())
+	}
+	if len(def.Rules) == 0 {
+		return nil, errors.New("invalid mock: no rules")
+	}
+
+	for i := range def.Rules {
+		rule := &def.Rules[i]
+		if len(rule.Responses) == 0 {
+			return nil, fmt.Errorf("invalid mock: rule %d has no responses", i)
+		}
+		for j := range rule.Responses {
+			resp := &rule.Responses[j]
+			if resp.Status == 0 {
+				resp.Status = http.StatusOK
+			}
+			if resp.Status < 100 || resp.Status > 999 {
+				return nil, fmt.Errorf("invalid mock: rule %d response %d: invalid status %d", i, j, resp.Status)
+			}
+			if resp.Body != "" && resp.JSON != nil {
+				return nil, fmt.Errorf("invalid mock: rule %d response %d: set body or json, not both", i, j)
+			}
+			if resp.Delay != "" {
+				d, err := time.ParseDuration(resp.Delay)
+				if err != nil || d < 0 || d > maxMockDelay {
+					return nil, fmt.Errorf("invalid mock: rule %d response %d: delay must be a duration up to %s", i, j, maxMockDelay)
+				}
+				resp.delay = d
+			}
+		}
+	}
+	return &def, nil
+}
+
+func (rule *mockRule) matches(r *http.Request, path string) bool {
+	if rule.Method != "" && !strings.EqualFold(rule.Method, r.Method) {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(rule.Path, "*"); ok {
+		if !strings.HasPrefix(path, prefix) {
+			return false
+		}
+	} else if rule.Path != "" && rule.Path != path {
+		return false
+	}
+
+	q := r.URL.Query()
+	for k, v := range rule.Query {
+		if !q.Has(k) || q.Get(k) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// mockCalls counts the calls to each rule of the mock clips, keyed by clip
+// id. Counts belong to a written version of the clip and to the versions
+// extending it, and restart with the next write.
+type mockCalls struct {
+	mu    sync.Mutex
+	calls map[string]*mockCounts
+}
+
+type mockCounts struct {
+	versions map[uint64]bool
+	rules    []int
+}
+
+func newMockCalls() *mockCalls {
+	return &mockCalls{calls: make(map[string]*mockCounts)}
+}
+
+// next counts a call to rule i of version of the clip id and returns the
+// index of the response it gets.
+func (mc *mockCalls) next(id string, version uint64, rule *mockRule, i, rules int) int {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	c := mc.calls[id]
+	if c == nil || !c.versions[version] {
+		c = &mockCounts{versions: map[uint64]bool{version: true}, rules: make([]int, rules)}
+		mc.calls[id] = c
+	}
+	n := c.rules[i]
+	c.rules[i]++
+
+	if rule.Cycle {
+		return n % len(rule.Responses)
+	}
+	return min(n, len(rule.Responses)-1)
+}
+
+// extend carries the counts of the clip id over to the version its expiry
+// was extended to. Calls made to the earlier version still count.
+func (mc *mockCalls) extend(id string, version uint64) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if c := mc.calls[id]; c != nil {
+		c.versions[version] = true
+	}
+}
+
+// forget drops the counts of the clip id, once it is deleted or expires.
+func (mc *mockCalls) forget(id string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	delete(mc.calls, id)
+}
+
+// serveMock answers r with the mock clip named in its path. Callers are
+// authorized as anonymous with the action "mock", as the credentials the
+// code under test sends are meant for the API being stubbed.
+func (b *board) serveMock(w http.ResponseWriter, r *http.Request) {
+	id, err := b.idRules.Normalize(mockPrefix + r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !b.reachable(w, r, id) {
+		return
+	}
+	user := clientIP(r)
+	if !b.authorizeAs(w, PolicySubject{Name: user}, "mock", id) {
+		return
+	}
+
+	e, exists := b.store.Lookup(id)
+	if !exists {
+		http.Error(w, "no mock "+id, http.StatusNotFound)
+		return
+	}
+	if e.Value.kind != clipMock {
+		http.Error(w, id+" is not a mock", http.StatusConflict)
+		return
+	}
+	if !canRead(w, r, e.Value) {
+		return
+	}
+	def, err := parseMock(e.Value.value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	b.stats.RecordRead(user, id)
+
+	path := "/" + r.PathValue("path")
+	for i := range def.Rules {
+		rule := &def.Rules[i]
+		if !rule.matches(r, path) {
+			continue
+		}
+
+		resp := rule.Responses[b.mocks.next(id, e.Version, rule, i, len(def.Rules))]
+		if resp.delay > 0 {
+			select {
+			case <-time.After(resp.delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		h := w.Header()
+		body := resp.Body
+		if resp.JSON != nil {
+			h.Set("Content-Type", "application/json")
+			body = string(resp.JSON)
+		}
+		for k, v := range resp.Headers {
+			if k = http.CanonicalHeaderKey(k); !mockDroppedHeaders[k] {
+				h.Set(k, v)
+			}
+		}
+		if h.Get("Content-Type") == "" {
+			h.Set("Content-Type", "text/plain; charset=utf-8")
+		}
+		h.Set("Content-Security-Policy", "sandbox")
+		h.Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(resp.Status)
+		if r.Method != http.MethodHead {
+			w.Write([]byte(body))
+		}
+		return
+	}
+
+	http.Error(w, fmt.Sprintf("no rule of %s matches %s %s", id, r.Method, path), http.StatusNotFound)
+}
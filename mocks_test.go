@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMockCountsSurviveExtend(t *testing.T) {
+	b, h := newTestBoard(t)
+	def := `{"rules": [{"responses": [{"body": "first"}, {"body": "second"}]}]}`
+	b.store.Put("mock/api", storedValue{kind: clipMock, value: def}, time.Hour)
+
+	call := func() string {
+		t.Helper()
+		w := serve(h, "GET", "/mock/api/x", "", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("mock: %d %s", w.Code, w.Body)
+		}
+		return w.Body.String()
+	}
+
+	if got := call(); got != "first" {
+		t.Fatalf("first call = %q", got)
+	}
+	if _, ok := b.store.Extend("mock/api", time.Hour, nil); !ok {
+		t.Fatal("Extend failed")
+	}
+	if got := call(); got != "second" {
+		t.Errorf("call after extending = %q, want second", got)
+	}
+
+	b.store.Put("mock/api", storedValue{kind: clipMock, value: def}, time.Hour)
+	if got := call(); got != "first" {
+		t.Errorf("call after writing = %q, want first", got)
+	}
+}
+
+func TestParseMock(t *testing.T) {
+	def, err := parseMock(`{"rules": [{
+		"method": "POST",
+		"path": "/orders/*",
+		"responses": [{"delay": "200ms"}, {"status": 201, "json": {"id": 42}}]
+	}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resps := def.Rules[0].Responses
+	if resps[0].Status != http.StatusOK || resps[0].delay != 200*time.Millisecond {
+		t.Errorf("first response = %+v, want 200 after 200ms", resps[0])
+	}
+	if resps[1].Status != http.StatusCreated || string(resps[1].JSON) != `{"id": 42}` {
+		t.Errorf("second response = %+v", resps[1])
+	}
+
+	for _, value := range []string{
+		``,
+		`[]`,
+		`{"rules": []}`,
+		`{"rules": [{"responses": []}]}`,
+		`{"rules": [{"responses": [{}]}], "extra": 1}`,
+		`{"rules": [{"responses": [{"status": 42}]}]}`,
+		`{"rules": [{"responses": [{"status": 1000}]}]}`,
+		`{"rules": [{"responses": [{"body": "x", "json": 1}]}]}`,
+		`{"rules": [{"responses": [{"delay": "soon"}]}]}`,
+		`{"rules": [{"responses": [{"delay": "-1s"}]}]}`,
+		`{"rules": [{"responses": [{"delay": "31s"}]}]}`,
+	} {
+		if _, err := parseMock(value); err == nil {
+			t.Errorf("parseMock(%s) succeeded", value)
+		}
+	}
+}
+
+func TestMockRuleMatching(t *testing.T) {
+	b, h := newTestBoard(t)
+	b.store.Put("mock/api", storedValue{kind: clipMock, value: `{"rules": [
+		{"method": "post", "path": "/orders/*", "responses": [{"body": "order"}]},
+		{"path": "/health", "query": {"deep": "true"}, "responses": [{"body": "deep"}]},
+		{"path": "/health", "responses": [{"body": "shallow"}]},
+		{"path": "/cycle", "cycle": true, "responses": [{"body": "a"}, {"body": "b"}]}
+	]}`}, time.Hour)
+
+	tests := []struct {
+		method, target string
+		status         int
+		body           string
+	}{
+		{"POST", "/mock/api/orders/1", http.StatusOK, "order"},
+		{"GET", "/mock/api/orders/1", http.StatusNotFound, ""},
+		{"GET", "/mock/api/health?deep=true", http.StatusOK, "deep"},
+		{"GET", "/mock/api/health?deep=false", http.StatusOK, "shallow"},
+		{"GET", "/mock/api/health/x", http.StatusNotFound, ""},
+		{"GET", "/mock/api/cycle", http.StatusOK, "a"},
+		{"GET", "/mock/api/cycle", http.StatusOK, "b"},
+		{"GET", "/mock/api/cycle", http.StatusOK, "a"},
+		{"HEAD", "/mock/api/health", http.StatusOK, ""},
+		{"GET", "/mock/none", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := serve(h, tt.method, tt.target, "", nil)
+		if w.Code != tt.status || (tt.body != "" && w.Body.String() != tt.body) {
+			t.Errorf("%s %s = %d %q, want %d %q", tt.method, tt.target, w.Code, w.Body, tt.status, tt.body)
+		}
+	}
+
+	b.store.Put("mock/text", storedValue{kind: clipText, value: "x"}, time.Hour)
+	if w := serve(h, "GET", "/mock/text", "", nil); w.Code != http.StatusConflict {
+		t.Errorf("GET of a text clip as a mock: %d, want 409", w.Code)
+	}
+}
+
+func TestMockDroppedHeaders(t *testing.T) {
+	b, h := newTestBoard(t)
+	b.store.Put("mock/api", storedValue{kind: clipMock, value: `{"rules": [{"responses": [{
+		"status": 201,
+		"headers": {
+			"set-cookie": "nb_session=stolen",
+			"Content-Security-Policy": "default-src *",
+			"X-Content-Type-Options": "",
+			"Access-Control-Allow-Origin": "*",
+			"Content-Length": "1",
+			"Transfer-Encoding": "chunked",
+			"Clear-Site-Data": "*",
+			"Retry-After": "1",
+			"Content-Type": "text/html"
+		},
+		"body": "<script>alert(1)</script>"
+	}]}]}`}, time.Hour)
+
+	w := serve(h, "GET", "/mock/api", "", nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	for k := range mockDroppedHeaders {
+		switch k {
+		case "Content-Security-Policy", "X-Content-Type-Options":
+			continue
+		}
+		if v := w.Header().Values(k); len(v) > 0 {
+			t.Errorf("%s = %q, want it dropped", k, v)
+		}
+	}
+	for k, want := range map[string]string{
+		"Content-Security-Policy": "sandbox",
+		"X-Content-Type-Options":  "nosniff",
+		"Retry-After":             "1",
+		"Content-Type":            "text/html",
+	} {
+		if got := w.Header().Values(k); len(got) != 1 || got[0] != want {
+			t.Errorf("%s = %q, want %q", k, got, want)
+		}
+	}
+
+	b.store.Put("mock/json", storedValue{kind: clipMock, value: `{"rules": [{"responses": [{"json": [1]}]}]}`}, time.Hour)
+	w = serve(h, "GET", "/mock/json", "", nil)
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" || w.Body.String() != "[1]" {
+		t.Errorf("json response: %s %q", ct, w.Body)
+	}
+	b.store.Put("mock/plain", storedValue{kind: clipMock, value: `{"rules": [{"responses": [{"body": "hi"}]}]}`}, time.Hour)
+	w = serve(h, "GET", "/mock/plain", "", nil)
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("response without a type: Content-Type %q", ct)
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/cel-go/cel"
+)
+
+// Policies authorize clip requests with CEL expressions. A policy file
+// (NOTE_BOARD_POLICY_FILE) maps bearer tokens to subjects and lists rules
+// that are tried in order; the first rule whose condition holds decides, and
+// requests no rule matches get the default effect:
+//
+//	{
+//	  "default": "allow",
+//	  "subjects": {"s3cr3t": {"name": "ci", "roles": ["ci"]}},
+//	  "rules": [{
+//	    "name": "ci-builds-in-business-hours",
+//	    "effect": "deny",
+//	    "when": "'ci' in subject.roles && action != 'read' && (!resource.id.startsWith('build/') || now.getHours('Europe/London') < 9 || now.getHours('Europe/London') >= 18)"
+//	  }]
+//	}
+//
+// Conditions see subject (name, org, roles, authenticated), action ("read",
+// "write", "delete", or "capture" and "mock" for the always anonymous
+// requests to request bins and mock endpoints), resource (id, namespace,
+// exists, type and meta of the stored clip) and now. A condition that fails
+// to evaluate, for example by reading a missing map key, denies the request;
+// guard optional labels with "'key' in resource.meta".
+
+const (
+	policyAllow = "allow"
+	policyDeny  = "deny"
+)
+
+// policyCostLimit bounds the work of a single condition.
+const policyCostLimit = 100000
+
+type PolicySubject struct {
+	Name          string   `json:"name"`
+	Org           string   `json:"org,omitempty"`
+	Roles         []string `json:"roles"`
+	Authenticated bool     `json:"authenticated"`
+}
+
+type PolicyRule struct {
+	Name   string `json:"name"`
+	Effect string `json:"effect"`
+	When   string `json:"when"`
+
+	program cel.Program
+}
+
+type policyConfig struct {
+	Default  string                   `json:"default"`
+	Subjects map[string]PolicySubject `json:"subjects"`
+	Rules    []*PolicyRule            `json:"rules"`
+}
+
+type PolicyEngine struct {
+	config policyConfig
+}
+
+// PolicyRequest is what a decision is made about.
+type PolicyRequest struct {
+	Subject PolicySubject
+	Action  string
+	ID      string
+	Exists  bool
+	Type    string
+	Meta    map[string]string
+	Time    time.Time
+}
+
+func (pr PolicyRequest) activation() map[string]any {
+	roles := pr.Subject.Roles
+	if roles == nil {
+		roles = []string{}
+	}
+	meta := pr.Meta
+	if meta == nil {
+		meta = map[string]string{}
+	}
+
+	return map[string]any{
+		"subject": map[string]any{
+			"name":          pr.Subject.Name,
+			"org":           pr.Subject.Org,
+			"roles":         roles,
+			"authenticated": pr.Subject.Authenticated,
+		},
+		"action": pr.Action,
+		"resource": map[string]any{
+			"id":        pr.ID,
+			"namespace": namespaceOf(pr.ID),
+			"exists":    pr.Exists,
+			"type":      pr.Type,
+			"meta":      meta,
+		},
+		"now": pr.Time,
+	}
+}
+
+type ruleResult struct {
+	Name    string `json:"name"`
+	Effect  string `json:"effect"`
+	Matched bool   `json:"matched"`
+	Error   string `json:"error,omitempty"`
+}
+
+// PolicyDecision is the outcome of a request. Rule names the deciding rule
+// and is empty when the default applied.
+type PolicyDecision struct {
+	Effect string       `json:"effect"`
+	Rule   string       `json:"rule,omitempty"`
+	Rules  []ruleResult `json:"rules,omitempty"`
+}
+
+func (d PolicyDecision) Allowed() bool {
+	return d.Effect == policyAllow
+}
+
+func loadPolicy(path string) (*PolicyEngine, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg policyConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Default == "" {
+		cfg.Default = policyDeny
+	}
+	if cfg.Default != policyAllow && cfg.Default != policyDeny {
+		return nil, fmt.Errorf("default must be %q or %q", policyAllow, policyDeny)
+	}
+	for token, s := range cfg.Subjects {
+		s.Authenticated = true
+		cfg.Subjects[token] = s
+	}
+
+	env, err := cel.NewEnv(
+		cel.Variable("subject", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("action", cel.StringType),
+		cel.Variable("resource", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("now", cel.TimestampType),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, rule := range cfg.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if rule.Effect != policyAllow && rule.Effect != policyDeny {
+			return nil, fmt.Errorf("rule %q: effect must be %q or %q", rule.Name, policyAllow, policyDeny)
+		}
+
+		ast, iss := env.Compile(rule.When)
+		if iss.Err() != nil {
+			return nil, fmt.Errorf("rule %q: %w", rule.Name, iss.Err())
+		}
+		if !ast.OutputType().IsExactType(cel.BoolType) {
+			return nil, fmt.Errorf("rule %q: condition must be a bool, not %s", rule.Name, ast.OutputType())
+		}
+		rule.program, err = env.Program(ast, cel.CostLimit(policyCostLimit))
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+	}
+
+	return &PolicyEngine{config: cfg}, nil
+}
+
+// Subject identifies the caller of r by their session or bearer token.
+// Requests with an unknown token are rejected rather than treated as
+// anonymous.
+func (pe *PolicyEngine) Subject(r *http.Request) (PolicySubject, error) {
+	if s := sessionFrom(r); s != nil && !s.Pending {
+		return PolicySubject{Name: s.Account, Org: s.Org, Authenticated: true}, nil
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return PolicySubject{Name: requestUser(r)}, nil
+	}
+
+	s, ok := pe.config.Subjects[token]
+	if !ok {
+		return PolicySubject{}, errors.New("unknown token")
+	}
+	return s, nil
+}
+
+// Decide evaluates the rules in order. A rule that fails to evaluate denies
+// the request. With explain set every rule is evaluated and reported.
+func (pe *PolicyEngine) Decide(pr PolicyRequest, explain bool) PolicyDecision {
+	vars := pr.activation()
+	var d PolicyDecision
+
+	for _, rule := range pe.config.Rules {
+		res := ruleResult{Name: rule.Name, Effect: rule.Effect}
+
+		out, _, err := rule.program.Eval(vars)
+		if err != nil {
+			res.Error = err.Error()
+			res.Effect = policyDeny
+		} else {
+			res.Matched, _ = out.Value().(bool)
+		}
+
+		if d.Effect == "" && (res.Matched || res.Error != "") {
+			d.Effect, d.Rule = res.Effect, rule.Name
+		}
+		if explain {
+			d.Rules = append(d.Rules, res)
+		} else if d.Effect != "" {
+			return d
+		}
+	}
+
+	if d.Effect == "" {
+		d.Effect = pe.config.Default
+	}
+	return d
+}
+
+func policyAction(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return "read"
+	case http.MethodDelete:
+		return "delete"
+	default:
+		return "write"
+	}
+}
+
+// authorize checks r against the policy for the clip id, writing the error
+// response when it is not allowed.
+func (b *board) authorize(w http.ResponseWriter, r *http.Request, id string) bool {
+	if b.policy == nil {
+		return true
+	}
+
+	subject, err := b.policy.Subject(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="note-board"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	return b.authorizeAs(w, subject, policyAction(r.Method), id)
+}
+
+// recheck checks the id a before-hook left, which may differ from the id
+// the request named, against the IP rules and, when the hook renamed the
+// clip, against the policy as well, writing the error response when it is
+// denied.
+func (b *board) recheck(w http.ResponseWriter, r *http.Request, id, renamed string) bool {
+	if !b.reachable(w, r, renamed) {
+		return false
+	}
+	return renamed == id || b.authorize(w, r, renamed)
+}
+
+// authorizeAs checks an action by subject on the clip id against the policy,
+// writing the error response when it is not allowed.
+func (b *board) authorizeAs(w http.ResponseWriter, subject PolicySubject, action, id string) bool {
+	if b.policy == nil {
+		return true
+	}
+
+	pr := PolicyRequest{Subject: subject, Action: action, ID: id, Time: time.Now()}
+	if e, ok := b.store.Lookup(id); ok {
+		pr.Exists, pr.Type, pr.Meta = true, e.Value.kind, e.Value.meta
+	}
+
+	d := b.policy.Decide(pr, false)
+	if !d.Allowed() {
+		msg := "forbidden by default policy"
+		if d.Rule != "" {
+			msg = fmt.Sprintf("forbidden by policy rule %q", d.Rule)
+		}
+		http.Error(w, msg, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// readable returns a function reporting whether the caller of r may read a
+// clip, for listings and streams that must leave out the clips it could
+// not fetch: the IP access rules must let it reach the namespace, the policy
+// must allow it to read the clip and, when the clip has readers, it must be
+// one of them. It writes the error response when the caller cannot be
+// identified.
+func (b *board) readable(w http.ResponseWriter, r *http.Request) (func(id, kind string, meta map[string]string, readers []string) bool, bool) {
+	var subject PolicySubject
+	if b.policy != nil {
+		var err error
+		if subject, err = b.policy.Subject(r); err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="note-board"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return nil, false
+		}
+	}
+	addr, _ := requestAddr(r)
+	user, _ := sessionUser(r)
+
+	return func(id, kind string, meta map[string]string, readers []string) bool {
+		if !b.ipAccess.Allowed(addr, namespaceOf(id)) {
+			return false
+		}
+		if len(readers) > 0 && (user == "" || !slices.Contains(readers, user)) {
+			return false
+		}
+		if b.policy == nil {
+			return true
+		}
+		pr := PolicyRequest{Subject: subject, Action: "read", ID: id, Exists: true, Type: kind, Meta: meta, Time: time.Now()}
+		return b.policy.Decide(pr, false).Allowed()
+	}, true
+}
+
+type explainRequest struct {
+	Token  string            `json:"token"`
+	User   string            `json:"user"`
+	Action string            `json:"action"`
+	ID     string            `json:"id"`
+	Meta   map[string]string `json:"meta"`
+	Time   time.Time         `json:"time"`
+}
+
+// policyHandler lists the loaded rules on GET. POST /admin/policy/explain
+// evaluates every rule for the described request and reports the decision,
+// reading the stored clip unless meta is given.
+func policyHandler(pe *PolicyEngine, store *ValueStore, idRules IDRules) http.HandlerFunc {
+	return requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		if pe == nil {
+			http.Error(w, "no policy loaded", http.StatusNotFound)
+			return
+		}
+
+		switch {
+		case r.URL.Path == "/admin/policy" && r.Method == http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetEscapeHTML(false)
+			enc.Encode(map[string]any{
+				"default": pe.config.Default,
+				"rules":   pe.config.Rules,
+			})
+
+		case r.URL.Path == "/admin/policy/explain" && r.Method == http.MethodPost:
+			var req explainRequest
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&req); err != nil {
+				http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			id, err := idRules.Normalize(req.ID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			pr := PolicyRequest{Subject: PolicySubject{Name: req.User}, Action: req.Action, ID: id, Meta: req.Meta, Time: req.Time}
+			if req.Token != "" {
+				s, ok := pe.config.Subjects[req.Token]
+				if !ok {
+					http.Error(w, "unknown token", http.StatusBadRequest)
+					return
+				}
+				pr.Subject = s
+			}
+			if pr.Action == "" {
+				pr.Action = "read"
+			}
+			if pr.Time.IsZero() {
+				pr.Time = time.Now()
+			}
+			if e, ok := store.Lookup(id); ok {
+				pr.Exists, pr.Type = true, e.Value.kind
+				if pr.Meta == nil {
+					pr.Meta = e.Value.meta
+				}
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{
+				"subject":  pr.Subject,
+				"action":   pr.Action,
+				"resource": pr.activation()["resource"],
+				"time":     pr.Time,
+				"decision": pe.Decide(pr, true),
+			})
+
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPolicyRecheckedForRenamedIDs(t *testing.T) {
+	b, h := newTestBoard(t)
+	var err error
+	b.policy, err = loadPolicy(writeTemp(t, "policy.json", `{
+		"default": "allow",
+		"rules": [{"name": "no-secrets", "effect": "deny", "when": "resource.namespace == 'secret'"}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.store.Put("secret/x", storedValue{kind: clipText, value: "hidden"}, time.Hour)
+	withHooks(t, renameHook{from: "public/x", to: "secret/x"})
+
+	tests := []struct {
+		method, target string
+	}{
+		{http.MethodGet, "/?id=public/x"},
+		{http.MethodPost, "/?id=public/x&value=overwritten"},
+		{http.MethodDelete, "/?id=public/x"},
+		{http.MethodGet, "/view?id=public/x"},
+	}
+	for _, tt := range tests {
+		if w := serve(h, tt.method, tt.target, "", nil); w.Code != http.StatusForbidden {
+			t.Errorf("%s %s: status %d, want 403: %s", tt.method, tt.target, w.Code, body(t, w))
+		}
+	}
+	if v := b.store.Get("secret/x"); v != "hidden" {
+		t.Errorf("secret/x = %q, want it unchanged", v)
+	}
+
+	if w := serve(h, http.MethodGet, "/?id=public/y", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("GET of an id no hook renames: status %d, want 404", w.Code)
+	}
+}
+
+func loadTestPolicy(t *testing.T, config string) *PolicyEngine {
+	t.Helper()
+	pe, err := loadPolicy(writeTemp(t, "policy.json", config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pe
+}
+
+const testPolicy = `{
+	"default": "deny",
+	"subjects": {"ci-token": {"name": "ci", "roles": ["ci"]}},
+	"rules": [
+		{"name": "ci-night", "effect": "deny", "when": "'ci' in subject.roles && now.getHours('UTC') < 6"},
+		{"name": "ci-builds", "effect": "allow", "when": "'ci' in subject.roles && resource.namespace == 'build'"},
+		{"name": "public", "effect": "allow", "when": "resource.meta.visibility == 'public'"},
+		{"name": "readers", "effect": "allow", "when": "action == 'read'"}
+	]
+}`
+
+func TestPolicyDecide(t *testing.T) {
+	pe := loadTestPolicy(t, testPolicy)
+	ci := pe.config.Subjects["ci-token"]
+	if !ci.Authenticated {
+		t.Error("subjects with a token are not authenticated")
+	}
+	noon := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	night := time.Date(2024, 5, 1, 3, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		pr     PolicyRequest
+		effect string
+		rule   string
+	}{
+		{"first matching rule decides", PolicyRequest{Subject: ci, Action: "write", ID: "build/1", Time: noon}, policyAllow, "ci-builds"},
+		{"earlier rule wins", PolicyRequest{Subject: ci, Action: "write", ID: "build/1", Time: night}, policyDeny, "ci-night"},
+		// A missing label fails to evaluate, which denies.
+		{"evaluation error denies", PolicyRequest{Action: "read", ID: "notes/a", Time: noon}, policyDeny, "public"},
+		{"later rule", PolicyRequest{Action: "read", ID: "notes/a", Meta: map[string]string{"visibility": "team"}, Time: noon}, policyAllow, "readers"},
+		{"default", PolicyRequest{Action: "write", ID: "notes/a", Meta: map[string]string{"visibility": "team"}, Time: noon}, policyDeny, ""},
+	}
+	for _, tt := range tests {
+		d := pe.Decide(tt.pr, false)
+		if d.Effect != tt.effect || d.Rule != tt.rule {
+			t.Errorf("%s: decision = %+v, want %s by %q", tt.name, d, tt.effect, tt.rule)
+		}
+		if d.Rules != nil {
+			t.Errorf("%s: rules reported without explain", tt.name)
+		}
+	}
+}
+
+func TestPolicyExplain(t *testing.T) {
+	pe := loadTestPolicy(t, testPolicy)
+	d := pe.Decide(PolicyRequest{
+		Subject: pe.config.Subjects["ci-token"],
+		Action:  "read",
+		ID:      "build/1",
+		Time:    time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}, true)
+
+	if d.Effect != policyAllow || d.Rule != "ci-builds" {
+		t.Errorf("decision = %s by %q, want allow by ci-builds", d.Effect, d.Rule)
+	}
+	want := []ruleResult{
+		{Name: "ci-night", Effect: policyDeny},
+		{Name: "ci-builds", Effect: policyAllow, Matched: true},
+		{Name: "public", Effect: policyDeny},
+		{Name: "readers", Effect: policyAllow, Matched: true},
+	}
+	if len(d.Rules) != len(want) {
+		t.Fatalf("rules = %+v, want %d", d.Rules, len(want))
+	}
+	for i, res := range d.Rules {
+		// Only public reads the missing visibility label.
+		if failed := res.Error != ""; failed != (res.Name == "public") {
+			t.Errorf("rule %s: error %q", res.Name, res.Error)
+		}
+		res.Error = ""
+		if res != want[i] {
+			t.Errorf("rule %d = %+v, want %+v", i, res, want[i])
+		}
+	}
+}
+
+func TestPolicyExplainHandler(t *testing.T) {
+	t.Setenv("NOTE_BOARD_ADMIN_TOKEN", "admin")
+	b, _ := newTestBoard(t)
+	pe := loadTestPolicy(t, testPolicy)
+	b.store.Put("notes/a", storedValue{kind: clipText, meta: map[string]string{"visibility": "public"}}, time.Hour)
+	h := policyHandler(pe, b.store, b.idRules)
+
+	w := serve(h, "POST", "/admin/policy/explain", `{"user": "bob", "action": "write", "id": "notes/a"}`,
+		http.Header{"Authorization": {"Bearer admin"}})
+	var resp struct {
+		Decision PolicyDecision `json:"decision"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%d %s: %v", w.Code, w.Body, err)
+	}
+	if d := resp.Decision; d.Effect != policyAllow || d.Rule != "public" || len(d.Rules) != 4 {
+		t.Errorf("decision = %+v, want allow by public with every rule", d)
+	}
+
+	w = serve(h, "POST", "/admin/policy/explain", `{"token": "nope", "id": "notes/a"}`,
+		http.Header{"Authorization": {"Bearer admin"}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unknown token: status %d, want 400", w.Code)
+	}
+}
+
+func TestLoadPolicyErrors(t *testing.T) {
+	for _, config := range []string{
+		`{"default": "maybe"}`,
+		`{"rules": [{"effect": "permit", "when": "true"}]}`,
+		`{"rules": [{"effect": "allow", "when": "action"}]}`,
+		`{"rules": [{"effect": "allow", "when": "nosuchvar == 1"}]}`,
+		`{"rules": [`,
+	} {
+		if _, err := loadPolicy(writeTemp(t, "policy.json", config)); err == nil {
+			t.Errorf("loadPolicy(%s) succeeded", config)
+		}
+	}
+
+	pe := loadTestPolicy(t, `{"rules": [{"effect": "allow", "when": "false"}]}`)
+	if pe.config.Default != policyDeny || pe.config.Rules[0].Name != "rule-1" {
+		t.Errorf("defaults = %q, %q; want deny and rule-1", pe.config.Default, pe.config.Rules[0].Name)
+	}
+}
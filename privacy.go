@@ -0,0 +1,274 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+type exportedClip struct {
+	ID        string            `json:"id"`
+	Value     string            `json:"value"`
+	Meta      map[string]string `json:"meta,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+	ExpiresAt time.Time         `json:"expiresAt"`
+}
+
+type exportedVersion struct {
+	ID string `json:"id"`
+	clipVersion
+}
+
+type exportManifest struct {
+	User        string      `json:"user"`
+	GeneratedAt time.Time   `json:"generatedAt"`
+	Sequence    uint64      `json:"sequence"`
+	Clips       int         `json:"clips"`
+	Versions    int         `json:"versions"`
+	StatsBucket []time.Time `json:"statsBuckets"`
+	Account     bool        `json:"account"`
+	Sessions    int         `json:"sessions"`
+	Captures    int         `json:"binCaptures"`
+}
+
+func anonymousUser() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "anonymous-" + hex.EncodeToString(b)
+}
+
+// exportHandler writes every piece of data held about ?user= as a zip
+// archive: the clips they own, the earlier values of clips they wrote, the
+// usage buckets that reference them, their account with its sessions, keys
+// and recipients, and the requests bins caught from their sessions' addresses.
+func exportHandler(store *ValueStore, stats *Stats, accounts *Accounts) http.HandlerFunc {
+	return requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user := r.URL.Query().Get("user")
+		if user == "" {
+			http.Error(w, "missing ?user parameter", http.StatusBadRequest)
+			return
+		}
+
+		snap := store.Snapshot()
+		defer snap.Close()
+
+		var clips []exportedClip
+		snap.Range(func(e clipEntry) bool {
+			if e.Value.owner == user {
+				clips = append(clips, exportedClip{
+					ID:        e.Key,
+					Value:     e.Value.value,
+					Meta:      e.Value.meta,
+					CreatedAt: e.Value.timestamp,
+					ExpiresAt: e.Expires,
+				})
+			}
+			return true
+		})
+		sort.Slice(clips, func(i, j int) bool { return clips[i].ID < clips[j].ID })
+
+		var versions []exportedVersion
+		for id, vs := range store.history.ownedBy(user) {
+			for _, v := range vs {
+				versions = append(versions, exportedVersion{ID: id, clipVersion: v})
+			}
+		}
+		sort.Slice(versions, func(i, j int) bool {
+			if versions[i].ID != versions[j].ID {
+				return versions[i].ID < versions[j].ID
+			}
+			return versions[i].Version < versions[j].Version
+		})
+
+		account := accounts.export(user)
+		addrs := make(map[string]bool)
+		if account != nil {
+			for _, s := range account.Sessions {
+				addrs[s.IP] = true
+			}
+		}
+		captures := capturesFrom(snap, addrs)
+
+		manifest := exportManifest{
+			User:        user,
+			GeneratedAt: time.Now(),
+			Sequence:    snap.Seq(),
+			Clips:       len(clips),
+			Versions:    len(versions),
+			StatsBucket: stats.References(user),
+			Account:     account != nil,
+			Captures:    len(captures),
+		}
+		if account != nil {
+			manifest.Sessions = len(account.Sessions)
+		}
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="note-board-export.zip"`)
+
+		zw := zip.NewWriter(w)
+		for name, v := range map[string]any{
+			"manifest.json": manifest,
+			"clips.json":    clips,
+			"history.json":  versions,
+			"account.json":  account,
+			"captures.json": captures,
+		} {
+			f, err := zw.Create(name)
+			if err != nil {
+				log.Printf("privacy: export %s: %v", user, err)
+				return
+			}
+			enc := json.NewEncoder(f)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(v); err != nil {
+				log.Printf("privacy: export %s: %v", user, err)
+				return
+			}
+		}
+		if err := zw.Close(); err != nil {
+			log.Printf("privacy: export %s: %v", user, err)
+		}
+	})
+}
+
+type erasureJob struct {
+	ID           string     `json:"id"`
+	User         string     `json:"user"`
+	Status       string     `json:"status"`
+	StartedAt    time.Time  `json:"startedAt"`
+	FinishedAt   *time.Time `json:"finishedAt,omitempty"`
+	ClipsDeleted int        `json:"clipsDeleted"`
+	Versions     int        `json:"versionsDeleted"`
+	Responses    int        `json:"responsesDeleted"`
+	StatsBuckets int        `json:"statsBucketsAnonymized"`
+	accountErasure
+	Captures int `json:"binCapturesDeleted"`
+}
+
+// ErasureJobs runs and tracks requests to remove a user's data.
+type ErasureJobs struct {
+	mu       sync.Mutex
+	jobs     map[string]*erasureJob
+	store    *ValueStore
+	stats    *Stats
+	idem     *idempotency
+	accounts *Accounts
+}
+
+func NewErasureJobs(store *ValueStore, stats *Stats, idem *idempotency, accounts *Accounts) *ErasureJobs {
+	return &ErasureJobs{
+		jobs:     make(map[string]*erasureJob),
+		store:    store,
+		stats:    stats,
+		idem:     idem,
+		accounts: accounts,
+	}
+}
+
+func (ej *ErasureJobs) Start(user string) erasureJob {
+	b := make([]byte, 8)
+	rand.Read(b)
+	job := &erasureJob{
+		ID:        hex.EncodeToString(b),
+		User:      user,
+		Status:    "running",
+		StartedAt: time.Now(),
+	}
+
+	ej.mu.Lock()
+	ej.jobs[job.ID] = job
+	snapshot := *job
+	ej.mu.Unlock()
+
+	go ej.run(job)
+
+	return snapshot
+}
+
+func (ej *ErasureJobs) run(job *erasureJob) {
+	deleted := 0
+	for _, e := range ej.store.OwnedBy(job.User) {
+		if ej.store.Delete(e.Key) {
+			deleted++
+		}
+	}
+	versions := ej.store.history.forget(job.User)
+	responses := ej.idem.forget(job.User)
+	buckets := ej.stats.Forget(job.User)
+	account := ej.accounts.erase(job.User)
+	captures := eraseCaptures(ej.store, account.addrs)
+
+	now := time.Now()
+	ej.mu.Lock()
+	job.ClipsDeleted = deleted
+	job.Versions = versions
+	job.Responses = responses
+	job.StatsBuckets = buckets
+	job.accountErasure = account
+	job.Captures = captures
+	job.Status = "done"
+	job.FinishedAt = &now
+	ej.mu.Unlock()
+
+	log.Printf("privacy: erasure job %s finished: %d clips, %d versions, %d responses, %d sessions, %d signing keys, %d recipients and %d bin captures deleted, %d stats buckets anonymized (account deleted: %v)",
+		job.ID, deleted, versions, responses, account.Sessions, account.Keys, account.Recipients, captures, buckets, account.Account)
+}
+
+func (ej *ErasureJobs) Get(id string) (erasureJob, bool) {
+	ej.mu.Lock()
+	defer ej.mu.Unlock()
+
+	job, ok := ej.jobs[id]
+	if !ok {
+		return erasureJob{}, false
+	}
+	return *job, true
+}
+
+// erasureHandler starts an erasure job with POST ?user= and reports its
+// progress with GET ?job=.
+func erasureHandler(jobs *ErasureJobs) http.HandlerFunc {
+	return requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			job, ok := jobs.Get(r.URL.Query().Get("job"))
+			if !ok {
+				http.Error(w, "erasure job not found", http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(job)
+
+		case http.MethodPost:
+			user := r.URL.Query().Get("user")
+			if user == "" {
+				http.Error(w, "missing ?user parameter", http.StatusBadRequest)
+				return
+			}
+
+			job := jobs.Start(user)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Location", fmt.Sprintf("/privacy/erasure?job=%s", job.ID))
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(job)
+
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
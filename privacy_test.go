@@ -0,0 +1,137 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+)
+
+// newPrivacyUser registers alice with a signing key and an age recipient,
+// logs her in from 192.0.2.7 and captures a request from there and one from
+// another address in bin/hooks.
+func newPrivacyUser(t *testing.T) (*board, http.Handler) {
+	t.Helper()
+	b, h := newTestBoard(t)
+
+	if err := b.accounts.Register("alice", "", "correct horse battery"); err != nil {
+		t.Fatal(err)
+	}
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.accounts.AddKey("alice", "laptop", pub); err != nil {
+		t.Fatal(err)
+	}
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.accounts.AddRecipient("alice", "backup", id.Recipient().String()); err != nil {
+		t.Fatal(err)
+	}
+
+	login := httptest.NewRequest("POST", "/account/login", nil)
+	login.RemoteAddr = "192.0.2.7:1234"
+	if _, _, err := b.accounts.Login("alice", "correct horse battery", "", login); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, addr := range []string{"192.0.2.7:1234", "198.51.100.1:1234"} {
+		r := httptest.NewRequest("POST", "/bin/hooks", strings.NewReader("ping"))
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("capture from %s: %d %s", addr, w.Code, w.Body)
+		}
+	}
+	return b, h
+}
+
+func TestPrivacyExportAccount(t *testing.T) {
+	t.Setenv("NOTE_BOARD_ADMIN_TOKEN", "secret")
+	b, _ := newPrivacyUser(t)
+
+	w := serve(exportHandler(b.store, b.stats, b.accounts), "GET", "/privacy/export?user=alice", "",
+		http.Header{"Authorization": {"Bearer secret"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("export: %d %s", w.Code, w.Body)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]json.RawMessage)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v json.RawMessage
+		if err := json.NewDecoder(rc).Decode(&v); err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+		rc.Close()
+		files[f.Name] = v
+	}
+
+	var manifest exportManifest
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if !manifest.Account || manifest.Sessions != 1 || manifest.Captures != 1 {
+		t.Errorf("manifest = %+v, want the account, 1 session and 1 capture", manifest)
+	}
+
+	var account accountExport
+	if err := json.Unmarshal(files["account.json"], &account); err != nil {
+		t.Fatal(err)
+	}
+	if account.Name != "alice" || len(account.Keys) != 1 || len(account.Recipients) != 1 ||
+		len(account.Sessions) != 1 || account.Sessions[0].IP != "192.0.2.7" {
+		t.Errorf("account.json = %s", files["account.json"])
+	}
+
+	var captures []exportedCapture
+	if err := json.Unmarshal(files["captures.json"], &captures); err != nil {
+		t.Fatal(err)
+	}
+	if len(captures) != 1 || captures[0].Bin != "bin/hooks" || captures[0].IP != "192.0.2.7" {
+		t.Errorf("captures.json = %s", files["captures.json"])
+	}
+}
+
+func TestPrivacyErasureAccount(t *testing.T) {
+	b, _ := newPrivacyUser(t)
+
+	ej := NewErasureJobs(b.store, b.stats, b.idem, b.accounts)
+	job := &erasureJob{User: "alice"}
+	ej.run(job)
+
+	if e := job.accountErasure; !e.Account || e.Sessions != 1 || e.Keys != 1 || e.Recipients != 1 || job.Captures != 1 {
+		t.Errorf("job = %+v, want the account, 1 session, key, recipient and capture deleted", job)
+	}
+
+	if b.accounts.exists("alice") || len(b.accounts.Sessions("alice")) != 0 {
+		t.Error("account or sessions left after erasure")
+	}
+	e, _ := b.store.Lookup("bin/hooks")
+	captures, err := decodeCaptures(e.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(captures) != 1 || captures[0].IP != "198.51.100.1" {
+		t.Errorf("bin/hooks holds %+v, want only the capture from 198.51.100.1", captures)
+	}
+	if vs := b.store.history.versions[binPrefix+"hooks"]; len(vs) != 0 {
+		t.Errorf("bin/hooks keeps %d earlier values", len(vs))
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"filippo.io/age"
+)
+
+// Encrypted clips are encrypted by the CLI to the age X25519 recipients
+// their readers registered. The server never sees a private key: it keeps
+// the recipients so that senders can look them up, and stores the armored
+// ciphertext together with the names of the users it was encrypted to.
+
+type ageRecipient struct {
+	Recipient string    `json:"recipient"`
+	Name      string    `json:"name,omitempty"`
+	Added     time.Time `json:"added"`
+}
+
+func (a *Accounts) AddRecipient(name, label, recipient string) (ageRecipient, error) {
+	if _, err := age.ParseX25519Recipient(recipient); err != nil {
+		return ageRecipient{}, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	acc, ok := a.accounts[name]
+	if !ok {
+		return ageRecipient{}, errors.New("no such account")
+	}
+	for _, r := range acc.recipients {
+		if r.Recipient == recipient {
+			return ageRecipient{}, errors.New("recipient is already registered")
+		}
+	}
+
+	r := ageRecipient{Recipient: recipient, Name: label, Added: time.Now()}
+	acc.recipients = append(acc.recipients, r)
+	return r, nil
+}
+
+func (a *Accounts) RemoveRecipient(name, recipient string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	acc, ok := a.accounts[name]
+	if !ok {
+		return false
+	}
+	for i, r := range acc.recipients {
+		if r.Recipient == recipient {
+			acc.recipients = append(acc.recipients[:i:i], acc.recipients[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Accounts) Recipients(name string) []ageRecipient {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	recipients := []ageRecipient{}
+	if acc, ok := a.accounts[name]; ok {
+		recipients = append(recipients, acc.recipients...)
+	}
+	sort.Slice(recipients, func(i, j int) bool { return recipients[i].Added.Before(recipients[j].Added) })
+	return recipients
+}
+
+// recipientsHandler manages the age recipients of the caller's account: GET
+// lists them, POST {"name", "recipient"} registers one and DELETE
+// ?recipient= removes one.
+func (a *Accounts) recipientsHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := requireSession(w, r, false)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.Recipients(s.Account))
+
+	case http.MethodPost:
+		var req struct {
+			Name      string `json:"name"`
+			Recipient string `json:"recipient"`
+		}
+		if !decodeBody(w, r, &req) {
+			return
+		}
+		rcpt, err := a.AddRecipient(s.Account, req.Name, req.Recipient)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(rcpt)
+
+	case http.MethodDelete:
+		if !a.RemoveRecipient(s.Account, r.URL.Query().Get("recipient")) {
+			http.Error(w, "recipient not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// publicRecipientsHandler lets senders look up the recipients of ?user=.
+func (a *Accounts) publicRecipientsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.Recipients(r.URL.Query().Get("user")))
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// schemaViolation is one reason a value does not conform to its namespace
+// schema. Locations are JSON pointers.
+type schemaViolation struct {
+	InstanceLocation string `json:"instanceLocation"`
+	KeywordLocation  string `json:"keywordLocation"`
+	Message          string `json:"message"`
+}
+
+type namespaceSchema struct {
+	source   json.RawMessage
+	compiled *jsonschema.Schema
+}
+
+// SchemaRegistry holds the JSON Schema declared for each namespace. Every
+// write to a namespace with a schema must be a JSON value that conforms to
+// it.
+type SchemaRegistry struct {
+	mu      sync.RWMutex
+	schemas map[string]*namespaceSchema
+}
+
+func NewSchemaRegistry() *SchemaRegistry {
+	return &SchemaRegistry{schemas: make(map[string]*namespaceSchema)}
+}
+
+func compileSchema(ns string, source []byte) (*jsonschema.Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(source))
+	if err != nil {
+		return nil, err
+	}
+
+	url := "note-board:///schemas/" + ns + ".json"
+	c := jsonschema.NewCompiler()
+	// Schemas are uploaded by clients; a $ref may only point into the
+	// schema itself or at the standard metaschemas, never at a file or URL.
+	c.UseLoader(jsonschema.SchemeURLLoader{})
+	if err := c.AddResource(url, doc); err != nil {
+		return nil, err
+	}
+	return c.Compile(url)
+}
+
+func (sr *SchemaRegistry) Put(ns string, source []byte, compiled *jsonschema.Schema) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.schemas[ns] = &namespaceSchema{source: source, compiled: compiled}
+}
+
+func (sr *SchemaRegistry) Get(ns string) (json.RawMessage, bool) {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	s, ok := sr.schemas[ns]
+	if !ok {
+		return nil, false
+	}
+	return s.source, true
+}
+
+func (sr *SchemaRegistry) Delete(ns string) bool {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	_, ok := sr.schemas[ns]
+	delete(sr.schemas, ns)
+	return ok
+}
+
+// Validate checks value against the schema of the namespace of id. It
+// returns nil when the namespace has no schema or the value conforms.
+func (sr *SchemaRegistry) Validate(id, value string) []schemaViolation {
+	sr.mu.RLock()
+	s, ok := sr.schemas[namespaceOf(id)]
+	sr.mu.RUnlock()
+
+	if !ok {
+		return nil
+	}
+	return validateAgainst(s.compiled, value)
+}
+
+func validateAgainst(schema *jsonschema.Schema, value string) []schemaViolation {
+	inst, err := jsonschema.UnmarshalJSON(strings.NewReader(value))
+	if err != nil {
+		return []schemaViolation{{InstanceLocation: "", Message: "value is not valid JSON: " + err.Error()}}
+	}
+
+	err = schema.Validate(inst)
+	if err == nil {
+		return nil
+	}
+	ve, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return []schemaViolation{{Message: err.Error()}}
+	}
+
+	var violations []schemaViolation
+	for _, unit := range ve.BasicOutput().Errors {
+		if unit.Error == nil {
+			continue
+		}
+		violations = append(violations, schemaViolation{
+			InstanceLocation: unit.InstanceLocation,
+			KeywordLocation:  unit.KeywordLocation,
+			Message:          unit.Error.String(),
+		})
+	}
+	if len(violations) == 0 {
+		violations = append(violations, schemaViolation{Message: ve.Error()})
+	}
+	return violations
+}
+
+// schemaError rejects a write made inside a store update.
+type schemaError struct {
+	violations []schemaViolation
+}
+
+func (e *schemaError) Error() string {
+	return "value does not conform to the namespace schema"
+}
+
+// validateUpdate returns a *schemaError when value does not conform to the
+// schema of the namespace of id.
+func (sr *SchemaRegistry) validateUpdate(id, value string) error {
+	if v := sr.Validate(id, value); v != nil {
+		return &schemaError{v}
+	}
+	return nil
+}
+
+// writeSchemaProblem writes the problem response for a rejected write.
+func writeSchemaProblem(w http.ResponseWriter, id string, violations []schemaViolation) {
+	writeProblem(w, problem{
+		Type:   "/problems/schema-violation",
+		Title:  "Value does not match the namespace schema",
+		Status: http.StatusUnprocessableEntity,
+		Detail: fmt.Sprintf("%q does not conform to the schema of namespace %q", id, namespaceOf(id)),
+		Errors: violations,
+	})
+}
+
+type nonConformingClip struct {
+	ID     string            `json:"id"`
+	Errors []schemaViolation `json:"errors"`
+}
+
+// schemasHandler manages namespace schemas with GET, PUT and DELETE
+// ?ns=, normalized like the first segment of clip ids. PUT reports the existing clips of the namespace that do not conform
+// to the new schema; with ?dryRun=true the schema is only checked.
+func schemasHandler(sr *SchemaRegistry, store *ValueStore, idRules IDRules) http.HandlerFunc {
+	return requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		ns := idRules.Namespace(r.URL.Query().Get("ns"))
+		if ns == "" {
+			http.Error(w, "missing ?ns parameter", http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			source, ok := sr.Get(ns)
+			if !ok {
+				http.Error(w, "no schema for namespace", http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/schema+json")
+			w.Write(source)
+
+		case http.MethodPut:
+			source, err := io.ReadAll(io.LimitReader(r.Body, maxValueSize))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			compiled, err := compileSchema(ns, source)
+			if err != nil {
+				writeProblem(w, problem{
+					Type:   "/problems/invalid-schema",
+					Title:  "Invalid JSON Schema",
+					Status: http.StatusBadRequest,
+					Detail: err.Error(),
+				})
+				return
+			}
+
+			nonConforming := []nonConformingClip{}
+			store.Range(func(e clipEntry) bool {
+				if namespaceOf(e.Key) == ns {
+					if v := validateAgainst(compiled, e.Value.value); v != nil {
+						nonConforming = append(nonConforming, nonConformingClip{ID: e.Key, Errors: v})
+					}
+				}
+				return true
+			})
+			sort.Slice(nonConforming, func(i, j int) bool { return nonConforming[i].ID < nonConforming[j].ID })
+
+			dryRun := r.URL.Query().Get("dryRun") == "true"
+			if !dryRun {
+				sr.Put(ns, source, compiled)
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{
+				"namespace":     ns,
+				"applied":       !dryRun,
+				"nonConforming": nonConforming,
+			})
+
+		case http.MethodDelete:
+			if !sr.Delete(ns) {
+				http.Error(w, "no schema for namespace", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
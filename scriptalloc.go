@@ -0,0 +1,543 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// Scripts are limited in the memory they allocate, not in the heap they
+// hold: every value built by an operator or a builtin is charged to the run,
+// garbage or not, and the run fails once the charges pass the engine's
+// limit. Starlark gives no hook into its allocations, so the program is
+// rewritten before it is compiled: the operators that can build large values
+// become calls to the checked versions below, and every call goes through
+// allocCall. Operators and the builtins that can grow a value much beyond
+// their arguments are charged before they run, everything else once it
+// returns. Values built one step at a time are bounded by the step limit.
+
+var errAllocLimit = errors.New("memory limit exceeded")
+
+const (
+	// allocElemSize is charged per element of a list or tuple and
+	// allocEntrySize per entry of a dict or set.
+	allocElemSize  = 16
+	allocEntrySize = 64
+)
+
+// The checked operators and allocCall are predeclared under names scripts
+// cannot spell, so they can be neither called nor shadowed.
+const allocCallName = "$call"
+
+var allocOps = map[syntax.Token]string{
+	syntax.PLUS:    "$+",
+	syntax.STAR:    "$*",
+	syntax.PERCENT: "$%",
+	syntax.PIPE:    "$|",
+	syntax.LTLT:    "$<<",
+}
+
+// allocAssignOps maps augmented assignments to the operator they apply.
+// += and |= update lists and dicts in place, as Starlark does.
+var allocAssignOps = map[syntax.Token]string{
+	syntax.PLUS_EQ:    "$+=",
+	syntax.STAR_EQ:    "$*",
+	syntax.PERCENT_EQ: "$%",
+	syntax.PIPE_EQ:    "$|=",
+	syntax.LTLT_EQ:    "$<<",
+}
+
+// allocBudget is the memory a run may still allocate, kept as a thread
+// local.
+type allocBudget struct {
+	left uint64
+}
+
+const allocBudgetKey = "alloc"
+
+func chargeAlloc(thread *starlark.Thread, n uint64) error {
+	budget, ok := thread.Local(allocBudgetKey).(*allocBudget)
+	if !ok {
+		return nil
+	}
+	if n > budget.left {
+		budget.left = 0
+		thread.Cancel(errAllocLimit.Error())
+		return errAllocLimit
+	}
+	budget.left -= n
+	return nil
+}
+
+// allocLeft returns what the run may still allocate.
+func allocLeft(thread *starlark.Thread) uint64 {
+	if budget, ok := thread.Local(allocBudgetKey).(*allocBudget); ok {
+		return budget.left
+	}
+	return math.MaxUint64
+}
+
+func mulSize(a, b uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	if hi != 0 {
+		return math.MaxUint64
+	}
+	return lo
+}
+
+// allocSize is the memory charged for v itself, not counting the values it
+// refers to, which were charged when they were built.
+func allocSize(v starlark.Value) uint64 {
+	switch v := v.(type) {
+	case starlark.String:
+		return uint64(len(v))
+	case starlark.Bytes:
+		return uint64(len(v))
+	case *starlark.List:
+		return mulSize(uint64(v.Len()), allocElemSize)
+	case starlark.Tuple:
+		return mulSize(uint64(len(v)), allocElemSize)
+	case *starlark.Dict:
+		return mulSize(uint64(v.Len()), allocEntrySize)
+	case *starlark.Set:
+		return mulSize(uint64(v.Len()), allocEntrySize)
+	case starlark.Int:
+		if _, ok := v.Int64(); !ok {
+			return uint64(v.BigInt().BitLen() / 8)
+		}
+	}
+	return 0
+}
+
+// reprSize is the length of the string form of v, or a number above limit
+// once it passes limit. Containers on the path from the root count as the
+// "..." Starlark prints for cycles.
+func reprSize(v starlark.Value, limit uint64) uint64 {
+	var walk func(v starlark.Value, path map[starlark.Value]bool) uint64
+	walk = func(v starlark.Value, path map[starlark.Value]bool) uint64 {
+		var elems []starlark.Value
+		switch v := v.(type) {
+		case starlark.String:
+			return uint64(len(v)) + 2
+		case starlark.Bytes:
+			return uint64(len(v))*4 + 3
+		case starlark.Int:
+			if _, ok := v.Int64(); !ok {
+				return uint64(v.BigInt().BitLen()/3) + 1
+			}
+			return 20
+		case starlark.Tuple:
+			elems = v
+		case *starlark.List, *starlark.Dict, *starlark.Set:
+			if path[v] {
+				return 5
+			}
+			path[v] = true
+			defer delete(path, v)
+			if d, ok := v.(*starlark.Dict); ok {
+				for _, item := range d.Items() {
+					elems = append(elems, item[0], item[1])
+				}
+			} else {
+				iter := starlark.Iterate(v)
+				var x starlark.Value
+				for iter.Next(&x) {
+					elems = append(elems, x)
+				}
+				iter.Done()
+			}
+		default:
+			return uint64(len(v.Type())) + 32
+		}
+
+		n := uint64(2)
+		for _, e := range elems {
+			if n += walk(e, path) + 2; n > limit {
+				return n
+			}
+		}
+		return n
+	}
+	return walk(v, make(map[starlark.Value]bool))
+}
+
+// binarySize is the size of x op y when it can be told before computing it,
+// or 0.
+func binarySize(thread *starlark.Thread, op syntax.Token, x, y starlark.Value) uint64 {
+	sized := func(v starlark.Value) bool {
+		switch v.(type) {
+		case starlark.String, starlark.Bytes, *starlark.List, starlark.Tuple:
+			return true
+		}
+		return false
+	}
+	switch op {
+	case syntax.PLUS:
+		if sized(x) && sized(y) {
+			return allocSize(x) + allocSize(y)
+		}
+	case syntax.STAR:
+		if !sized(x) {
+			x, y = y, x
+		}
+		if n, ok := y.(starlark.Int); ok && sized(x) {
+			if n, ok := n.Uint64(); ok {
+				return mulSize(allocSize(x), n)
+			}
+			if n.BigInt().Sign() > 0 {
+				return math.MaxUint64
+			}
+		}
+	case syntax.PERCENT:
+		if _, ok := x.(starlark.String); ok {
+			return allocSize(x) + reprSize(y, allocLeft(thread))
+		}
+	}
+	return 0
+}
+
+func allocBinary(op syntax.Token) *starlark.Builtin {
+	return starlark.NewBuiltin(allocOps[op], func(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+		x, y := args[0], args[1]
+		size := binarySize(thread, op, x, y)
+		if err := chargeAlloc(thread, size); err != nil {
+			return nil, err
+		}
+		z, err := starlark.Binary(op, x, y)
+		if err != nil || size > 0 {
+			return z, err
+		}
+		return z, chargeAlloc(thread, allocSize(z))
+	})
+}
+
+// allocInPlace applies x += y or x |= y, extending lists and updating dicts
+// in place like Starlark's own augmented assignment.
+func allocInPlace(op syntax.Token) *starlark.Builtin {
+	name := allocAssignOps[op]
+	binary := allocBinary(map[syntax.Token]syntax.Token{syntax.PLUS_EQ: syntax.PLUS, syntax.PIPE_EQ: syntax.PIPE}[op])
+	return starlark.NewBuiltin(name, func(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		x, y := args[0], args[1]
+		switch x := x.(type) {
+		case *starlark.List:
+			if _, ok := y.(starlark.Iterable); !ok || op != syntax.PLUS_EQ {
+				break
+			}
+			// Collected first, since x may be y.
+			var elems []starlark.Value
+			iter := starlark.Iterate(y)
+			var v starlark.Value
+			for iter.Next(&v) {
+				if err := chargeAlloc(thread, allocElemSize); err != nil {
+					iter.Done()
+					return nil, err
+				}
+				elems = append(elems, v)
+			}
+			iter.Done()
+			for _, v := range elems {
+				if err := x.Append(v); err != nil {
+					return nil, err
+				}
+			}
+			return x, nil
+		case *starlark.Dict:
+			ydict, ok := y.(*starlark.Dict)
+			if !ok || op != syntax.PIPE_EQ {
+				break
+			}
+			if err := chargeAlloc(thread, mulSize(uint64(ydict.Len()), allocEntrySize)); err != nil {
+				return nil, err
+			}
+			for _, item := range ydict.Items() {
+				if err := x.SetKey(item[0], item[1]); err != nil {
+					return nil, err
+				}
+			}
+			return x, nil
+		}
+		return binary.CallInternal(thread, args, kwargs)
+	})
+}
+
+// callSize is the size of what the builtin fn builds from args, when it can
+// be much larger than its arguments, or 0.
+func callSize(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple) uint64 {
+	lenOf := func(i int) uint64 {
+		if i < len(args) {
+			if n := starlark.Len(args[i]); n > 0 {
+				return uint64(n)
+			}
+		}
+		return 0
+	}
+	reprOf := func(args starlark.Tuple) uint64 {
+		limit := allocLeft(thread)
+		var n uint64
+		for _, a := range args {
+			if n += reprSize(a, limit); n > limit {
+				break
+			}
+		}
+		return n
+	}
+
+	switch recv := fn.Receiver().(type) {
+	case nil:
+		switch fn.Name() {
+		case "str", "repr", "print":
+			return reprOf(args)
+		case "list", "tuple", "sorted", "reversed":
+			return mulSize(lenOf(0), allocElemSize)
+		case "dict", "set", "enumerate", "zip":
+			return mulSize(lenOf(0), allocEntrySize)
+		}
+	case starlark.String:
+		switch fn.Name() {
+		case "format":
+			return uint64(len(recv)) + reprOf(args)
+		case "join":
+			if len(args) == 0 {
+				return 0
+			}
+			iter := starlark.Iterate(args[0])
+			if iter == nil {
+				return 0
+			}
+			defer iter.Done()
+			var n uint64
+			var v starlark.Value
+			for iter.Next(&v) {
+				n += uint64(len(recv)) + allocSize(v)
+			}
+			return n
+		case "replace":
+			if len(args) < 2 {
+				return 0
+			}
+			old, ok1 := args[0].(starlark.String)
+			repl, ok2 := args[1].(starlark.String)
+			if !ok1 || !ok2 {
+				return 0
+			}
+			return uint64(len(recv)) + mulSize(uint64(strings.Count(string(recv), string(old))), uint64(len(repl)))
+		}
+	case *starlark.List:
+		switch fn.Name() {
+		case "append", "insert":
+			return allocElemSize
+		case "extend":
+			return mulSize(lenOf(0), allocElemSize)
+		}
+	case *starlark.Dict:
+		switch fn.Name() {
+		case "setdefault":
+			return allocEntrySize
+		case "update":
+			return mulSize(lenOf(0), allocEntrySize)
+		}
+	case *starlark.Set:
+		switch fn.Name() {
+		case "add":
+			return allocEntrySize
+		case "update", "union":
+			return mulSize(lenOf(0), allocEntrySize)
+		}
+	}
+	return 0
+}
+
+// allocCall calls its first argument with the rest. Builtins are charged
+// for what they build; functions defined by the script were charged as they
+// ran.
+var allocCall = starlark.NewBuiltin(allocCallName, func(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	fn, args := args[0], args[1:]
+	builtin, ok := fn.(*starlark.Builtin)
+	if !ok {
+		return starlark.Call(thread, fn, args, kwargs)
+	}
+
+	size := callSize(thread, builtin, args)
+	if err := chargeAlloc(thread, size); err != nil {
+		return nil, err
+	}
+	v, err := starlark.Call(thread, fn, args, kwargs)
+	if err != nil || size > 0 {
+		return v, err
+	}
+	return v, chargeAlloc(thread, allocSize(v))
+})
+
+// allocPredeclared returns the checked operators and allocCall, by the
+// names the rewritten program uses.
+func allocPredeclared() starlark.StringDict {
+	d := starlark.StringDict{allocCallName: allocCall}
+	for op, name := range allocOps {
+		d[name] = allocBinary(op)
+	}
+	for op, name := range allocAssignOps {
+		if _, ok := d[name]; !ok {
+			d[name] = allocInPlace(op)
+		}
+	}
+	return d
+}
+
+var allocNames = allocPredeclared()
+
+// limitAllocations rewrites f so that its operators and calls go through
+// allocNames.
+func limitAllocations(f *syntax.File) {
+	for _, s := range f.Stmts {
+		allocStmt(s)
+	}
+}
+
+func allocStmts(stmts []syntax.Stmt) {
+	for _, s := range stmts {
+		allocStmt(s)
+	}
+}
+
+func allocStmt(s syntax.Stmt) {
+	switch s := s.(type) {
+	case *syntax.AssignStmt:
+		if name, ok := allocAssignOps[s.Op]; ok {
+			// x op= y becomes x = $op(x, y); x is evaluated twice.
+			s.RHS = allocCallOf(name, s.OpPos, allocExpr(s.LHS), allocExpr(s.RHS))
+			s.Op = syntax.EQ
+		} else {
+			s.RHS = allocExpr(s.RHS)
+		}
+		s.LHS = allocExpr(s.LHS)
+	case *syntax.DefStmt:
+		s.Params = allocExprs(s.Params)
+		allocStmts(s.Body)
+	case *syntax.ExprStmt:
+		s.X = allocExpr(s.X)
+	case *syntax.IfStmt:
+		s.Cond = allocExpr(s.Cond)
+		allocStmts(s.True)
+		allocStmts(s.False)
+	case *syntax.ForStmt:
+		s.Vars = allocExpr(s.Vars)
+		s.X = allocExpr(s.X)
+		allocStmts(s.Body)
+	case *syntax.WhileStmt:
+		s.Cond = allocExpr(s.Cond)
+		allocStmts(s.Body)
+	case *syntax.ReturnStmt:
+		if s.Result != nil {
+			s.Result = allocExpr(s.Result)
+		}
+	}
+}
+
+func allocExprs(es []syntax.Expr) []syntax.Expr {
+	out := make([]syntax.Expr, len(es))
+	for i, e := range es {
+		out[i] = allocExpr(e)
+	}
+	return out
+}
+
+func allocCallOf(name string, pos syntax.Position, args ...syntax.Expr) *syntax.CallExpr {
+	return &syntax.CallExpr{
+		Fn:     &syntax.Ident{NamePos: pos, Name: name},
+		Lparen: pos,
+		Args:   args,
+		Rparen: pos,
+	}
+}
+
+// allocExpr returns a rewritten copy of e, so that an expression used twice
+// is resolved twice.
+func allocExpr(e syntax.Expr) syntax.Expr {
+	switch e := e.(type) {
+	case nil:
+		return nil
+	case *syntax.BinaryExpr:
+		if name, ok := allocOps[e.Op]; ok {
+			return allocCallOf(name, e.OpPos, allocExpr(e.X), allocExpr(e.Y))
+		}
+		c := *e
+		c.X, c.Y = allocExpr(e.X), allocExpr(e.Y)
+		return &c
+	case *syntax.CallExpr:
+		call := allocCallOf(allocCallName, e.Lparen, append([]syntax.Expr{allocExpr(e.Fn)}, allocExprs(e.Args)...)...)
+		call.Rparen = e.Rparen
+		return call
+	case *syntax.Comprehension:
+		c := *e
+		c.Body = allocExpr(e.Body)
+		c.Clauses = make([]syntax.Node, len(e.Clauses))
+		for i, clause := range e.Clauses {
+			switch clause := clause.(type) {
+			case *syntax.ForClause:
+				cc := *clause
+				cc.Vars, cc.X = allocExpr(clause.Vars), allocExpr(clause.X)
+				c.Clauses[i] = &cc
+			case *syntax.IfClause:
+				cc := *clause
+				cc.Cond = allocExpr(clause.Cond)
+				c.Clauses[i] = &cc
+			}
+		}
+		return &c
+	case *syntax.CondExpr:
+		c := *e
+		c.Cond, c.True, c.False = allocExpr(e.Cond), allocExpr(e.True), allocExpr(e.False)
+		return &c
+	case *syntax.DictEntry:
+		c := *e
+		c.Key, c.Value = allocExpr(e.Key), allocExpr(e.Value)
+		return &c
+	case *syntax.DictExpr:
+		c := *e
+		c.List = allocExprs(e.List)
+		return &c
+	case *syntax.DotExpr:
+		c := *e
+		c.X = allocExpr(e.X)
+		name := *e.Name
+		c.Name = &name
+		return &c
+	case *syntax.Ident:
+		c := *e
+		return &c
+	case *syntax.IndexExpr:
+		c := *e
+		c.X, c.Y = allocExpr(e.X), allocExpr(e.Y)
+		return &c
+	case *syntax.LambdaExpr:
+		c := *e
+		c.Params = allocExprs(e.Params)
+		c.Body = allocExpr(e.Body)
+		return &c
+	case *syntax.ListExpr:
+		c := *e
+		c.List = allocExprs(e.List)
+		return &c
+	case *syntax.ParenExpr:
+		c := *e
+		c.X = allocExpr(e.X)
+		return &c
+	case *syntax.SliceExpr:
+		c := *e
+		c.X, c.Lo, c.Hi, c.Step = allocExpr(e.X), allocExpr(e.Lo), allocExpr(e.Hi), allocExpr(e.Step)
+		return &c
+	case *syntax.TupleExpr:
+		c := *e
+		c.List = allocExprs(e.List)
+		return &c
+	case *syntax.UnaryExpr:
+		c := *e
+		c.X = allocExpr(e.X)
+		return &c
+	}
+	return e
+}
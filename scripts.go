@@ -0,0 +1,386 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// Scripts are small Starlark programs run after a clip is set, deleted or
+// expires.
+// The program sees the triggering event as `event` (type, id, value, user)
+// and can use `store.get`, `store.set`, `store.append`, `store.delete` and
+// `log`. Writes made by a script do not trigger other scripts.
+//
+// Every run is limited in execution steps, wall time and the memory it
+// allocates; see scriptalloc.go for how allocations are counted.
+
+type Script struct {
+	Name    string `json:"name"`
+	Event   string `json:"event"`
+	Prefix  string `json:"prefix"`
+	Source  string `json:"source"`
+	Runs    int    `json:"runs"`
+	LastErr string `json:"lastError,omitempty"`
+
+	program *starlark.Program
+}
+
+type scriptEvent struct {
+	Type  string
+	ID    string
+	Value string
+	User  string
+}
+
+type ScriptEngine struct {
+	mu      sync.RWMutex
+	scripts map[string]*Script
+	store   *ValueStore
+	idRules IDRules
+	schemas *SchemaRegistry
+	events  chan scriptEvent
+
+	timeout  time.Duration
+	maxSteps uint64
+	maxAlloc uint64
+}
+
+var scriptFileOptions = &syntax.FileOptions{
+	TopLevelControl: true,
+	GlobalReassign:  true,
+	While:           true,
+}
+
+var scriptPredeclared = map[string]bool{"event": true, "store": true, "log": true}
+
+func NewScriptEngine(store *ValueStore, idRules IDRules, schemas *SchemaRegistry) *ScriptEngine {
+	se := &ScriptEngine{
+		scripts:  make(map[string]*Script),
+		store:    store,
+		idRules:  idRules,
+		schemas:  schemas,
+		events:   make(chan scriptEvent, 1024),
+		timeout:  envDuration("NOTE_BOARD_SCRIPT_TIMEOUT", 1*time.Second),
+		maxSteps: uint64(envInt("NOTE_BOARD_SCRIPT_MAX_STEPS", 1_000_000)),
+		maxAlloc: uint64(envInt("NOTE_BOARD_SCRIPT_MAX_ALLOC", 32<<20)),
+	}
+
+	go se.run()
+
+	return se
+}
+
+func (se *ScriptEngine) Put(s *Script) error {
+	switch s.Event {
+	case "set", "delete", "expire":
+	default:
+		return fmt.Errorf("event must be \"set\", \"delete\" or \"expire\"")
+	}
+
+	f, err := scriptFileOptions.Parse(s.Name+".star", s.Source, 0)
+	if err != nil {
+		return err
+	}
+	limitAllocations(f)
+	program, err := starlark.FileProgram(f, func(name string) bool {
+		return scriptPredeclared[name] || allocNames.Has(name)
+	})
+	if err != nil {
+		return err
+	}
+	s.program = program
+	// Events carry normalized ids.
+	s.Prefix = se.idRules.Prefix(s.Prefix)
+
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	se.scripts[s.Name] = s
+	return nil
+}
+
+func (se *ScriptEngine) Delete(name string) bool {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+
+	_, exists := se.scripts[name]
+	delete(se.scripts, name)
+	return exists
+}
+
+func (se *ScriptEngine) List() []Script {
+	se.mu.RLock()
+	defer se.mu.RUnlock()
+
+	list := make([]Script, 0, len(se.scripts))
+	for _, s := range se.scripts {
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Notify queues a store event for the scripts. Events are processed in order
+// by a single worker; if the queue is full the event is dropped.
+func (se *ScriptEngine) Notify(eventType, id, value, user string) {
+	select {
+	case se.events <- scriptEvent{eventType, id, value, user}:
+	default:
+		log.Printf("scripts: queue full, dropping %s event for %q", eventType, id)
+	}
+}
+
+func (se *ScriptEngine) run() {
+	for ev := range se.events {
+		se.mu.RLock()
+		var matched []*Script
+		for _, s := range se.scripts {
+			if s.Event == ev.Type && strings.HasPrefix(ev.ID, s.Prefix) {
+				matched = append(matched, s)
+			}
+		}
+		se.mu.RUnlock()
+		sort.Slice(matched, func(i, j int) bool { return matched[i].Name < matched[j].Name })
+
+		for _, s := range matched {
+			err := se.exec(s, ev)
+
+			se.mu.Lock()
+			s.Runs++
+			s.LastErr = ""
+			if err != nil {
+				s.LastErr = err.Error()
+			}
+			se.mu.Unlock()
+
+			if err != nil {
+				log.Printf("scripts: %s: %v", s.Name, err)
+			}
+		}
+	}
+}
+
+func (se *ScriptEngine) exec(s *Script, ev scriptEvent) error {
+	thread := &starlark.Thread{
+		Name:  s.Name,
+		Print: func(_ *starlark.Thread, msg string) { log.Printf("scripts: %s: %s", s.Name, msg) },
+	}
+	thread.SetMaxExecutionSteps(se.maxSteps)
+	thread.SetLocal(allocBudgetKey, &allocBudget{left: se.maxAlloc})
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-done:
+		case <-time.After(se.timeout):
+			thread.Cancel("time limit exceeded")
+		}
+	}()
+
+	predeclared := starlark.StringDict{
+		"event": starlarkstruct.FromStringDict(starlark.String("event"), starlark.StringDict{
+			"type":  starlark.String(ev.Type),
+			"id":    starlark.String(ev.ID),
+			"value": starlark.String(ev.Value),
+			"user":  starlark.String(ev.User),
+		}),
+		"store": se.storeModule(s),
+		"log": starlark.NewBuiltin("log", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var msg string
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &msg); err != nil {
+				return nil, err
+			}
+			log.Printf("scripts: %s: %s", s.Name, msg)
+			return starlark.None, nil
+		}),
+	}
+
+	maps.Copy(predeclared, allocNames)
+
+	_, err := s.program.Init(thread, predeclared)
+	return err
+}
+
+// storeModule exposes the restricted store API. Scripts write as the owner
+// "script:<name>".
+func (se *ScriptEngine) storeModule(s *Script) *starlarkstruct.Module {
+	owner := "script:" + s.Name
+
+	builtin := func(name string, fn func(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error)) *starlark.Builtin {
+		return starlark.NewBuiltin(name, func(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			return fn(args, kwargs)
+		})
+	}
+
+	return &starlarkstruct.Module{
+		Name: "store",
+		Members: starlark.StringDict{
+			"get": builtin("get", func(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+				var id string
+				if err := starlark.UnpackPositionalArgs("get", args, kwargs, 1, &id); err != nil {
+					return nil, err
+				}
+				id, err := se.idRules.Normalize(id)
+				if err != nil {
+					return nil, err
+				}
+				if val := se.store.Get(id); val != "" {
+					return starlark.String(val), nil
+				}
+				return starlark.None, nil
+			}),
+			"set": builtin("set", func(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+				var id, value string
+				if err := starlark.UnpackPositionalArgs("set", args, kwargs, 2, &id, &value); err != nil {
+					return nil, err
+				}
+				id, err := se.idRules.Normalize(id)
+				if err != nil {
+					return nil, err
+				}
+				if len(value) > maxValueSize {
+					return nil, fmt.Errorf("%q would exceed %d bytes", id, maxValueSize)
+				}
+				err = se.store.Update(id, func(cur storedValue, exists bool) (storedValue, error) {
+					switch {
+					case !exists:
+						cur = storedValue{kind: clipText, owner: owner}
+					case cur.kind == clipAge:
+						return cur, fmt.Errorf("%q is encrypted and cannot be set by scripts", id)
+					case cur.kind == clipLog:
+						return cur, fmt.Errorf("%q is a log and can only be appended to", id)
+					case len(cur.readers) > 0:
+						return cur, fmt.Errorf("%q is restricted to its readers and cannot be set by scripts", id)
+					}
+					if err := checkValue(cur.kind, value); err != nil {
+						return cur, fmt.Errorf("%q: %w", id, err)
+					}
+					if err := se.checkSchema(id, value); err != nil {
+						return cur, err
+					}
+					// Like append, set keeps the clip's type, labels, owner
+					// and expiry, but a signature no longer covers it.
+					cur.value = value
+					cur.sig = nil
+					return cur, nil
+				})
+				if err != nil {
+					return nil, err
+				}
+				return starlark.None, nil
+			}),
+			"append": builtin("append", func(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+				var id, value string
+				if err := starlark.UnpackPositionalArgs("append", args, kwargs, 2, &id, &value); err != nil {
+					return nil, err
+				}
+				id, err := se.idRules.Normalize(id)
+				if err != nil {
+					return nil, err
+				}
+				err = se.store.Update(id, func(cur storedValue, exists bool) (storedValue, error) {
+					switch {
+					case !exists:
+						cur = storedValue{kind: clipText, owner: owner}
+					case cur.kind == clipAge:
+						return cur, fmt.Errorf("%q is encrypted and cannot be appended to", id)
+					case cur.kind == clipLog && cur.closed:
+						return cur, errLogClosed
+					}
+					if len(cur.value)+len(value) > maxValueSize {
+						return cur, fmt.Errorf("%q would exceed %d bytes", id, maxValueSize)
+					}
+					if err := se.checkSchema(id, cur.value+value); err != nil {
+						return cur, err
+					}
+					// The clip keeps its type, labels, owner and readers,
+					// but a signature no longer covers it.
+					cur.value += value
+					cur.sig = nil
+					return cur, nil
+				})
+				if err != nil {
+					return nil, err
+				}
+				return starlark.None, nil
+			}),
+			"delete": builtin("delete", func(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+				var id string
+				if err := starlark.UnpackPositionalArgs("delete", args, kwargs, 1, &id); err != nil {
+					return nil, err
+				}
+				id, err := se.idRules.Normalize(id)
+				if err != nil {
+					return nil, err
+				}
+				return starlark.Bool(se.store.Delete(id)), nil
+			}),
+		},
+	}
+}
+
+func (se *ScriptEngine) checkSchema(id, value string) error {
+	if v := se.schemas.Validate(id, value); v != nil {
+		return fmt.Errorf("%q does not conform to its namespace schema: %s: %s", id, v[0].InstanceLocation, v[0].Message)
+	}
+	return nil
+}
+
+// scriptsHandler manages scripts: GET lists them, PUT ?name= creates or
+// replaces one from a JSON body and DELETE ?name= removes it.
+func scriptsHandler(se *ScriptEngine) http.HandlerFunc {
+	return requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(se.List())
+
+		case http.MethodPut:
+			name := r.URL.Query().Get("name")
+			if name == "" {
+				http.Error(w, "missing ?name parameter", http.StatusBadRequest)
+				return
+			}
+
+			var s Script
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&s); err != nil {
+				http.Error(w, "invalid script: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			s.Name = name
+			s.Runs = 0
+			s.LastErr = ""
+
+			if err := se.Put(&s); err != nil {
+				http.Error(w, "invalid script: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(s)
+
+		case http.MethodDelete:
+			if !se.Delete(r.URL.Query().Get("name")) {
+				http.Error(w, "script not found", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
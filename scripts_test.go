@@ -0,0 +1,130 @@
+package main
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runScript runs source once for a set event on id.
+func runScript(t *testing.T, se *ScriptEngine, source, id string) error {
+	t.Helper()
+	s := &Script{Name: "test", Event: "set", Source: source}
+	if err := se.Put(s); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	return se.exec(s, scriptEvent{Type: "set", ID: id})
+}
+
+func TestScriptSet(t *testing.T) {
+	b, _ := newTestBoard(t)
+	b.store.Put("notes/a", storedValue{kind: clipText, value: "old", owner: "alice", meta: map[string]string{"env": "prod"}}, time.Hour)
+	b.store.Put("notes/age", storedValue{kind: clipAge, value: ageArmorHeader + "\n"}, time.Hour)
+	b.store.Put("notes/log", storedValue{kind: clipLog, value: "line\n"}, time.Hour)
+	b.store.Put("notes/private", storedValue{kind: clipText, value: "old", readers: []string{"alice"}}, time.Hour)
+	b.store.Put("notes/json", storedValue{kind: clipJSON, value: "{}"}, time.Hour)
+
+	if err := runScript(t, b.scripts, `store.set("notes/a", "new")`, "notes/a"); err != nil {
+		t.Fatal(err)
+	}
+	e, _ := b.store.Lookup("notes/a")
+	if v := e.Value; v.value != "new" || v.owner != "alice" || v.meta["env"] != "prod" || v.kind != clipText {
+		t.Errorf("notes/a = %+v, want the new value with its owner and labels", v)
+	}
+
+	if err := runScript(t, b.scripts, `store.set("notes/b", "new")`, "notes/b"); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := b.store.Lookup("notes/b"); e.Value.owner != "script:test" {
+		t.Errorf("notes/b owner = %q, want script:test", e.Value.owner)
+	}
+
+	for _, id := range []string{"notes/age", "notes/log", "notes/private", "notes/json"} {
+		before := b.store.Get(id)
+		if err := runScript(t, b.scripts, `store.set("`+id+`", "new")`, id); err == nil {
+			t.Errorf("store.set(%q) succeeded", id)
+		}
+		if got := b.store.Get(id); got != before {
+			t.Errorf("%s = %q after a refused set, want %q", id, got, before)
+		}
+	}
+}
+
+func TestScriptAllocationLimit(t *testing.T) {
+	b, _ := newTestBoard(t)
+	b.scripts.maxAlloc = 1 << 20
+
+	for _, src := range []string{
+		`s = "x" * 100000000`,
+		`s = 100000000 * "x"`,
+		`s = [0] * 100000000`,
+		`s = "x" * 1000` + "\nfor i in range(20):\n    s += s",
+		`l = [0] * 1000` + "\nfor i in range(20):\n    l.extend(l)",
+		`l = [0] * 1000` + "\nfor i in range(20):\n    l += l",
+		`s = "x" * 2000` + "\ns = s.replace(\"x\", s)",
+		`s = ",".join(["x" * 1000] * 10000)`,
+		`s = str(["x" * 1000] * 10000)`,
+		`s = "%s" % (["x" * 1000] * 10000,)`,
+		`l = list(range(100000000))`,
+		"def f(n):\n    return \"x\" * n\ns = f(100000000)",
+		`s = (lambda: "x" * 100000000)()`,
+		`s = ["x" * 100000000 for i in range(1)]`,
+	} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		err := runScript(t, b.scripts, src, "notes/a")
+		runtime.ReadMemStats(&after)
+
+		if err == nil || !strings.Contains(err.Error(), "memory limit exceeded") {
+			t.Errorf("%q: err = %v, want the memory limit", src, err)
+		}
+		if n := after.TotalAlloc - before.TotalAlloc; n > 16<<20 {
+			t.Errorf("%q: allocated %d bytes", src, n)
+		}
+	}
+}
+
+func TestScriptAllocationRewriteKeepsSemantics(t *testing.T) {
+	b, _ := newTestBoard(t)
+
+	src := `
+a = [1]
+alias = a
+a += [2]
+d = {"x": 1}
+d2 = d
+d |= {"y": 2}
+s = "ab"
+s += "c"
+s *= 2
+n = 3
+n <<= 2
+m = {"k": [0]}
+m["k"] += [1]
+
+def join(*parts, sep = "-", **extra):
+    return sep.join(parts) + str(len(extra))
+
+result = [
+    alias,
+    sorted(d2.keys()),
+    s,
+    n,
+    m["k"],
+    join("a", "b", sep = "+", x = 1),
+    [x * 2 for x in range(3) if x != 1],
+    (lambda x: x % 3)(7),
+    "%d-%s" % (1, "z"),
+    {"a": 1} | {"b": 2},
+]
+store.set("out/result", str(result))
+`
+	if err := runScript(t, b.scripts, src, "notes/a"); err != nil {
+		t.Fatal(err)
+	}
+	want := `[[1, 2], ["x", "y"], "abcabc", 12, [0, 1], "a+b1", [0, 4], 1, "1-z", {"a": 1, "b": 2}]`
+	if got := b.store.Get("out/result"); got != want {
+		t.Errorf("result = %s, want %s", got, want)
+	}
+}
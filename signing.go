@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"note-board/internal/provenance"
+)
+
+// Signed clips carry an Ed25519 signature made with a key their author
+// registered on their account. The server checks the signature on write,
+// stores it with the signer and reports on every read whether it still
+// verifies against the clip and a registered key.
+//
+// A signature covers the id the clip is stored under, after normalization,
+// not the id as sent, so that readers can verify it against the id they
+// get back. Clients find the normalized id with GET /ids?id=.
+
+type publicKey struct {
+	ID    string            `json:"id"`
+	Name  string            `json:"name,omitempty"`
+	Key   ed25519.PublicKey `json:"-"`
+	Added time.Time         `json:"added"`
+}
+
+func (k publicKey) MarshalJSON() ([]byte, error) {
+	type plain publicKey
+	return json.Marshal(struct {
+		plain
+		PublicKey string `json:"publicKey"`
+	}{plain(k), provenance.EncodePublicKey(k.Key)})
+}
+
+// clipSignature is the signature stored with a clip.
+type clipSignature struct {
+	Signer string
+	KeyID  string
+	Value  []byte
+}
+
+func (a *Accounts) AddKey(name, label string, key ed25519.PublicKey) (publicKey, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	acc, ok := a.accounts[name]
+	if !ok {
+		return publicKey{}, errors.New("no such account")
+	}
+
+	pk := publicKey{ID: provenance.Fingerprint(key), Name: label, Key: key, Added: time.Now()}
+	for _, k := range acc.keys {
+		if k.ID == pk.ID {
+			return publicKey{}, errors.New("key is already registered")
+		}
+	}
+	acc.keys = append(acc.keys, pk)
+	return pk, nil
+}
+
+func (a *Accounts) RemoveKey(name, id string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	acc, ok := a.accounts[name]
+	if !ok {
+		return false
+	}
+	for i, k := range acc.keys {
+		if k.ID == id {
+			acc.keys = append(acc.keys[:i:i], acc.keys[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Accounts) Keys(name string) []publicKey {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	keys := []publicKey{}
+	if acc, ok := a.accounts[name]; ok {
+		keys = append(keys, acc.keys...)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Added.Before(keys[j].Added) })
+	return keys
+}
+
+func (a *Accounts) Key(name, id string) (ed25519.PublicKey, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if acc, ok := a.accounts[name]; ok {
+		for _, k := range acc.keys {
+			if k.ID == id {
+				return k.Key, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// readSignature checks the signature sent with a write of the final id,
+// type and value, writing the error response when it is not acceptable. It
+// returns nil for unsigned writes.
+func (b *board) readSignature(w http.ResponseWriter, r *http.Request, id, kind, value string) (*clipSignature, bool) {
+	header := r.Header.Get(provenance.HeaderSignature)
+	if header == "" {
+		return nil, true
+	}
+
+	signer, ok := sessionUser(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="note-board"`)
+		http.Error(w, "signed writes require a login", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(header)
+	if err != nil {
+		http.Error(w, "signature is not valid base64", http.StatusBadRequest)
+		return nil, false
+	}
+	keyID := r.Header.Get(provenance.HeaderKey)
+	key, ok := b.accounts.Key(signer, keyID)
+	if !ok {
+		http.Error(w, "signing key is not registered to "+signer, http.StatusUnprocessableEntity)
+		return nil, false
+	}
+	if !provenance.Verify(key, id, kind, value, sig) {
+		http.Error(w, "signature does not match the clip as stored", http.StatusUnprocessableEntity)
+		return nil, false
+	}
+
+	return &clipSignature{Signer: signer, KeyID: keyID, Value: sig}, true
+}
+
+// signatureStatus verifies the stored signature of a clip again, so that
+// clips signed with a key that has since been removed no longer verify.
+func (b *board) signatureStatus(id string, val storedValue) string {
+	if val.sig == nil {
+		return provenance.StatusUnsigned
+	}
+	key, ok := b.accounts.Key(val.sig.Signer, val.sig.KeyID)
+	if !ok || !provenance.Verify(key, id, val.kind, val.value, val.sig.Value) {
+		return provenance.StatusInvalid
+	}
+	return provenance.StatusVerified
+}
+
+// writeSignature reports the signature of a clip in headers and returns it
+// for the response body.
+func (b *board) writeSignature(w http.ResponseWriter, id string, val storedValue) map[string]string {
+	status := b.signatureStatus(id, val)
+	w.Header().Set(provenance.HeaderStatus, status)
+
+	info := map[string]string{"status": status}
+	if val.sig != nil {
+		sig := base64.StdEncoding.EncodeToString(val.sig.Value)
+		w.Header().Set(provenance.HeaderSigner, val.sig.Signer)
+		w.Header().Set(provenance.HeaderKey, val.sig.KeyID)
+		w.Header().Set(provenance.HeaderSignature, sig)
+		info["signer"], info["key"], info["signature"] = val.sig.Signer, val.sig.KeyID, sig
+	}
+	return info
+}
+
+// keysHandler manages the signing keys of the caller's account: GET lists
+// them, POST {"name", "publicKey"} registers one and DELETE ?id= removes
+// one.
+func (a *Accounts) keysHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := requireSession(w, r, false)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.Keys(s.Account))
+
+	case http.MethodPost:
+		var req struct {
+			Name      string `json:"name"`
+			PublicKey string `json:"publicKey"`
+		}
+		if !decodeBody(w, r, &req) {
+			return
+		}
+		key, err := provenance.ParsePublicKey(req.PublicKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pk, err := a.AddKey(s.Account, req.Name, key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(pk)
+
+	case http.MethodDelete:
+		if !a.RemoveKey(s.Account, r.URL.Query().Get("id")) {
+			http.Error(w, "key not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// publicKeysHandler lets anyone look up the keys of ?user= to verify the
+// clips they signed.
+func (a *Accounts) publicKeysHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.Keys(r.URL.Query().Get("user")))
+}
@@ -0,0 +1,592 @@
+// Package store provides an in-memory key/value store whose entries expire
+// after a time to live. It is the storage behind the note-board server and
+// can be embedded in other services.
+//
+//	s := store.New[string, []byte](
+//		store.WithTTL[string, []byte](time.Hour),
+//		store.WithSizer[string, []byte](func(v []byte) int64 { return int64(len(v)) }),
+//	)
+//	defer s.Close()
+//
+// Every write is stamped with the next value of a store-wide sequence.
+// Snapshot opens a consistent view of the store as of that sequence; the
+// older versions of entries it can see are kept until it is closed, so long
+// reads neither block writers nor observe them.
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// Entry is a live entry of a Store. Expires is zero for entries that never
+// expire. Version is the sequence number of the write that stored Value.
+type Entry[K comparable, V any] struct {
+	Key     K
+	Value   V
+	Expires time.Time
+	Version uint64
+}
+
+// version is one value of a key. Versions form a chain from the newest to
+// the oldest still visible to an open snapshot. A deleted version is a
+// tombstone hiding the older ones from newer snapshots.
+type version[V any] struct {
+	value   V
+	expires time.Time
+	size    int64
+	seq     uint64
+	deleted bool
+	prev    *version[V]
+}
+
+func (v *version[V]) expired(now time.Time) bool {
+	return !v.expires.IsZero() && now.After(v.expires)
+}
+
+func (v *version[V]) live(now time.Time) bool {
+	return v != nil && !v.deleted && !v.expired(now)
+}
+
+// at returns the newest version written at or before seq.
+func (v *version[V]) at(seq uint64) *version[V] {
+	for ; v != nil; v = v.prev {
+		if v.seq <= seq {
+			return v
+		}
+	}
+	return nil
+}
+
+func entryOf[K comparable, V any](key K, v *version[V]) Entry[K, V] {
+	return Entry[K, V]{Key: key, Value: v.value, Expires: v.expires, Version: v.seq}
+}
+
+// Op is the kind of a Change.
+type Op int
+
+const (
+	// OpSet stores a new value.
+	OpSet Op = iota
+	// OpTouch stores the current value again with a new expiry.
+	OpTouch
+	// OpDelete removes an entry by request.
+	OpDelete
+	// OpExpire removes an entry whose time to live ran out.
+	OpExpire
+)
+
+// Change is a write to a Store as passed to the WithOnChange hook. Entry is
+// the entry stored or, for OpDelete and OpExpire, the one removed. Seq is
+// the sequence number of the write.
+type Change[K comparable, V any] struct {
+	Op    Op
+	Entry Entry[K, V]
+	Seq   uint64
+}
+
+// Store is a concurrency-safe map with per-entry expiry. Expired entries are
+// never returned; they are removed when they are next looked up or by the
+// periodic cleanup, whichever comes first.
+type Store[K comparable, V any] struct {
+	mu    sync.RWMutex
+	items map[K]*version[V]
+	size  int64
+	seq   uint64
+
+	// snapshots counts the open snapshots by sequence; history holds the
+	// keys with versions kept only for them.
+	snapshots map[uint64]int
+	history   map[K]struct{}
+
+	ttl             time.Duration
+	cleanupInterval time.Duration
+	onExpire        func(K, V)
+	onChange        func(Change[K, V])
+	sizer           func(V) int64
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type Option[K comparable, V any] func(*Store[K, V])
+
+// WithTTL sets the time to live used by Set. Zero, the default, keeps
+// entries until they are deleted.
+func WithTTL[K comparable, V any](ttl time.Duration) Option[K, V] {
+	return func(s *Store[K, V]) { s.ttl = ttl }
+}
+
+// WithCleanupInterval sets how often expired entries are swept. The default
+// is one minute; zero disables the sweep.
+func WithCleanupInterval[K comparable, V any](interval time.Duration) Option[K, V] {
+	return func(s *Store[K, V]) { s.cleanupInterval = interval }
+}
+
+// WithOnExpire registers fn to be called, without the store lock held, for
+// every entry removed because it expired.
+func WithOnExpire[K comparable, V any](fn func(K, V)) Option[K, V] {
+	return func(s *Store[K, V]) { s.onExpire = fn }
+}
+
+// WithOnChange registers fn to be called for every write, in the order of
+// the writes and with the store lock held, so that state kept alongside the
+// store, such as an index, never disagrees with it. fn must be quick and must
+// not call the store.
+func WithOnChange[K comparable, V any](fn func(Change[K, V])) Option[K, V] {
+	return func(s *Store[K, V]) { s.onChange = fn }
+}
+
+// WithSizer sets the function used to account the size of values, reported
+// by Size.
+func WithSizer[K comparable, V any](fn func(V) int64) Option[K, V] {
+	return func(s *Store[K, V]) { s.sizer = fn }
+}
+
+func New[K comparable, V any](opts ...Option[K, V]) *Store[K, V] {
+	s := &Store[K, V]{
+		items:           make(map[K]*version[V]),
+		snapshots:       make(map[uint64]int),
+		history:         make(map[K]struct{}),
+		cleanupInterval: 1 * time.Minute,
+		stop:            make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.cleanupInterval > 0 {
+		go s.startCleanupRoutine(s.cleanupInterval)
+	}
+
+	return s
+}
+
+// Set stores value under key with the store's default time to live.
+func (s *Store[K, V]) Set(key K, value V) {
+	s.SetWithTTL(key, value, s.ttl)
+}
+
+// SetWithTTL stores value under key, expiring it after ttl. A ttl of zero
+// keeps the entry until it is deleted.
+func (s *Store[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	v := &version[V]{value: value}
+	if ttl > 0 {
+		v.expires = time.Now().Add(ttl)
+	}
+	if s.sizer != nil {
+		v.size = s.sizer(value)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(key, v)
+	s.changed(OpSet, key, v)
+}
+
+// SetIf stores value under key like SetWithTTL if cond accepts the current
+// entry; exists is false when there is none. It returns the version of the
+// stored entry and whether it was stored.
+func (s *Store[K, V]) SetIf(key K, value V, ttl time.Duration, cond func(cur Entry[K, V], exists bool) bool) (uint64, bool) {
+	v := &version[V]{value: value}
+	if ttl > 0 {
+		v.expires = time.Now().Add(ttl)
+	}
+	if s.sizer != nil {
+		v.size = s.sizer(value)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var cur Entry[K, V]
+	old := s.items[key]
+	exists := old.live(time.Now())
+	if exists {
+		cur = entryOf(key, old)
+	}
+	if cond != nil && !cond(cur, exists) {
+		return 0, false
+	}
+	s.put(key, v)
+	s.changed(OpSet, key, v)
+	return v.seq, true
+}
+
+// Update atomically replaces the value under key with the result of fn,
+// which receives the current value and whether it exists. An existing entry
+// keeps its expiry; a new one gets the default time to live. If fn returns an
+// error the store is left unchanged and the error is returned.
+func (s *Store[K, V]) Update(key K, fn func(V, bool) (V, error)) error {
+	return s.UpdateWithTTL(key, s.ttl, fn)
+}
+
+// UpdateWithTTL is like Update, but a new entry expires after ttl.
+func (s *Store[K, V]) UpdateWithTTL(key K, ttl time.Duration, fn func(V, bool) (V, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := s.items[key]
+	exists := old.live(time.Now())
+
+	var current V
+	if exists {
+		current = old.value
+	}
+	value, err := fn(current, exists)
+	if err != nil {
+		return err
+	}
+
+	v := &version[V]{value: value}
+	if exists {
+		v.expires = old.expires
+	} else if ttl > 0 {
+		v.expires = time.Now().Add(ttl)
+	}
+	if s.sizer != nil {
+		v.size = s.sizer(value)
+	}
+
+	s.put(key, v)
+	s.changed(OpSet, key, v)
+	return nil
+}
+
+// Touch stores the live entry under key again, unchanged but expiring after
+// ttl from now, if cond accepts it. It returns the new entry and whether it
+// was stored.
+func (s *Store[K, V]) Touch(key K, ttl time.Duration, cond func(cur Entry[K, V]) bool) (Entry[K, V], bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := s.items[key]
+	if !old.live(time.Now()) || (cond != nil && !cond(entryOf(key, old))) {
+		return Entry[K, V]{}, false
+	}
+	v := &version[V]{value: old.value, size: old.size}
+	if ttl > 0 {
+		v.expires = time.Now().Add(ttl)
+	}
+	s.put(key, v)
+	s.changed(OpTouch, key, v)
+	return entryOf(key, v), true
+}
+
+func (s *Store[K, V]) Get(key K) (V, bool) {
+	e, ok := s.Lookup(key)
+	return e.Value, ok
+}
+
+// Lookup returns the entry stored under key, including its expiry.
+func (s *Store[K, V]) Lookup(key K) (Entry[K, V], bool) {
+	s.mu.RLock()
+	v := s.items[key]
+	s.mu.RUnlock()
+
+	if v == nil || v.deleted {
+		return Entry[K, V]{}, false
+	}
+	if v.expired(time.Now()) {
+		s.expire(key)
+		return Entry[K, V]{}, false
+	}
+
+	return entryOf(key, v), true
+}
+
+// Delete removes key and reports whether a live entry was removed. An entry
+// that had already expired is removed as expired, running the expiry
+// callback.
+func (s *Store[K, V]) Delete(key K) bool {
+	s.mu.Lock()
+	v := s.items[key]
+	if v == nil || v.deleted {
+		s.mu.Unlock()
+		return false
+	}
+	s.remove(key)
+	if v.expired(time.Now()) {
+		s.changed(OpExpire, key, v)
+		s.mu.Unlock()
+
+		if s.onExpire != nil {
+			s.onExpire(key, v.value)
+		}
+		return false
+	}
+	s.changed(OpDelete, key, v)
+	s.mu.Unlock()
+	return true
+}
+
+// Range calls fn for every live entry until fn returns false. It iterates a
+// snapshot opened when it is called, so fn may modify the store.
+func (s *Store[K, V]) Range(fn func(Entry[K, V]) bool) {
+	sn := s.Snapshot()
+	defer sn.Close()
+	sn.Range(fn)
+}
+
+// Locked calls fn with the live entries while holding the store lock, so
+// that no write happens until it returns. It is used to set up state that
+// WithOnChange then keeps in step with the store. fn must not call the
+// store.
+func (s *Store[K, V]) Locked(fn func(live []Entry[K, V])) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	live := make([]Entry[K, V], 0, len(s.items))
+	for key, v := range s.items {
+		if v.live(now) {
+			live = append(live, entryOf(key, v))
+		}
+	}
+	fn(live)
+}
+
+// Len returns the number of entries, including expired ones not yet swept.
+func (s *Store[K, V]) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := 0
+	for _, v := range s.items {
+		if !v.deleted {
+			n++
+		}
+	}
+	return n
+}
+
+// Seq returns the sequence number of the latest write.
+func (s *Store[K, V]) Seq() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.seq
+}
+
+// Size returns the total size of the stored values as measured by the
+// sizer, or zero when none was configured.
+func (s *Store[K, V]) Size() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.size
+}
+
+// Close stops the cleanup loop. The store remains usable.
+func (s *Store[K, V]) Close() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// expire removes key if it is still expired and runs the expiry callback.
+func (s *Store[K, V]) expire(key K) {
+	s.mu.Lock()
+	v := s.items[key]
+	if v == nil || v.deleted || !v.expired(time.Now()) {
+		s.mu.Unlock()
+		return
+	}
+	s.remove(key)
+	s.changed(OpExpire, key, v)
+	s.mu.Unlock()
+
+	if s.onExpire != nil {
+		s.onExpire(key, v.value)
+	}
+}
+
+// changed runs the change hook for a write of v under key; for removals v
+// is the removed version. Callers hold s.mu.
+func (s *Store[K, V]) changed(op Op, key K, v *version[V]) {
+	if s.onChange != nil {
+		s.onChange(Change[K, V]{Op: op, Entry: entryOf(key, v), Seq: s.seq})
+	}
+}
+
+// put makes v the newest version of key. Callers hold s.mu.
+func (s *Store[K, V]) put(key K, v *version[V]) {
+	s.seq++
+	v.seq = s.seq
+
+	if old := s.items[key]; old != nil {
+		if !old.deleted {
+			s.size -= old.size
+		}
+		v.prev = old
+	}
+	s.items[key] = v
+	s.size += v.size
+	s.trim(key)
+}
+
+// remove deletes the live entry under key. While snapshots are open it
+// leaves a tombstone so that they still see the removed version. Callers
+// hold s.mu.
+func (s *Store[K, V]) remove(key K) {
+	if len(s.snapshots) > 0 {
+		s.put(key, &version[V]{deleted: true})
+		return
+	}
+
+	s.seq++
+	s.size -= s.items[key].size
+	delete(s.items, key)
+	delete(s.history, key)
+}
+
+// oldestSnapshot returns the sequence of the oldest open snapshot, or the
+// current sequence when there is none. Callers hold s.mu.
+func (s *Store[K, V]) oldestSnapshot() uint64 {
+	oldest := s.seq
+	for seq := range s.snapshots {
+		oldest = min(oldest, seq)
+	}
+	return oldest
+}
+
+// trim drops the versions of key that no open snapshot can see. Callers
+// hold s.mu.
+func (s *Store[K, V]) trim(key K) {
+	head := s.items[key]
+	if head == nil {
+		delete(s.history, key)
+		return
+	}
+
+	oldest := s.oldestSnapshot()
+	if v := head.at(oldest); v != nil {
+		v.prev = nil
+	}
+
+	switch {
+	case head.deleted && head.seq <= oldest:
+		delete(s.items, key)
+		delete(s.history, key)
+	case head.deleted || head.prev != nil:
+		s.history[key] = struct{}{}
+	default:
+		delete(s.history, key)
+	}
+}
+
+// collect trims every key that holds old versions. Callers hold s.mu.
+func (s *Store[K, V]) collect() {
+	for key := range s.history {
+		s.trim(key)
+	}
+}
+
+func (s *Store[K, V]) startCleanupRoutine(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		var expired []Entry[K, V]
+
+		s.mu.Lock()
+		for key, v := range s.items {
+			if !v.deleted && v.expired(now) {
+				expired = append(expired, entryOf(key, v))
+			}
+		}
+		for _, e := range expired {
+			v := s.items[e.Key]
+			s.remove(e.Key)
+			s.changed(OpExpire, e.Key, v)
+		}
+		s.collect()
+		s.mu.Unlock()
+
+		if s.onExpire != nil {
+			for _, e := range expired {
+				s.onExpire(e.Key, e.Value)
+			}
+		}
+	}
+}
+
+// Snapshot is a read-only view of a Store as of the moment it was opened.
+// Writes made after that are not visible through it, and entries are judged
+// expired by the time it was opened. A Snapshot must be closed so that the
+// versions it holds can be released.
+type Snapshot[K comparable, V any] struct {
+	s    *Store[K, V]
+	seq  uint64
+	at   time.Time
+	once sync.Once
+}
+
+// Snapshot opens a snapshot of the current contents of the store.
+func (s *Store[K, V]) Snapshot() *Snapshot[K, V] {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.snapshots[s.seq]++
+	return &Snapshot[K, V]{s: s, seq: s.seq, at: time.Now()}
+}
+
+// Seq returns the sequence number of the latest write visible in the
+// snapshot.
+func (sn *Snapshot[K, V]) Seq() uint64 {
+	return sn.seq
+}
+
+func (sn *Snapshot[K, V]) Get(key K) (V, bool) {
+	e, ok := sn.Lookup(key)
+	return e.Value, ok
+}
+
+func (sn *Snapshot[K, V]) Lookup(key K) (Entry[K, V], bool) {
+	sn.s.mu.RLock()
+	v := sn.s.items[key].at(sn.seq)
+	sn.s.mu.RUnlock()
+
+	if !v.live(sn.at) {
+		return Entry[K, V]{}, false
+	}
+	return entryOf(key, v), true
+}
+
+// Range calls fn for every entry live in the snapshot until fn returns
+// false. The store is only locked briefly for each entry.
+func (sn *Snapshot[K, V]) Range(fn func(Entry[K, V]) bool) {
+	sn.s.mu.RLock()
+	keys := make([]K, 0, len(sn.s.items))
+	for key := range sn.s.items {
+		keys = append(keys, key)
+	}
+	sn.s.mu.RUnlock()
+
+	for _, key := range keys {
+		e, ok := sn.Lookup(key)
+		if !ok {
+			continue
+		}
+		if !fn(e) {
+			return
+		}
+	}
+}
+
+// Close releases the snapshot and the versions only it could see.
+func (sn *Snapshot[K, V]) Close() {
+	sn.once.Do(func() {
+		s := sn.s
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.snapshots[sn.seq]--; s.snapshots[sn.seq] == 0 {
+			delete(s.snapshots, sn.seq)
+		}
+		s.collect()
+	})
+}
@@ -0,0 +1,280 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+// versions returns the number of versions kept for key, tombstones included.
+func (s *Store[K, V]) versions(key K) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := 0
+	for v := s.items[key]; v != nil; v = v.prev {
+		n++
+	}
+	return n
+}
+
+func TestSnapshotVisibility(t *testing.T) {
+	tests := []struct {
+		name string
+		// after runs once the snapshot is open.
+		after    func(s *Store[string, int])
+		snapshot int
+		snapOK   bool
+		current  int
+		curOK    bool
+	}{
+		{
+			name:     "unchanged",
+			after:    func(s *Store[string, int]) {},
+			snapshot: 1, snapOK: true,
+			current: 1, curOK: true,
+		},
+		{
+			name:     "overwritten",
+			after:    func(s *Store[string, int]) { s.Set("k", 2) },
+			snapshot: 1, snapOK: true,
+			current: 2, curOK: true,
+		},
+		{
+			name:     "overwritten twice",
+			after:    func(s *Store[string, int]) { s.Set("k", 2); s.Set("k", 3) },
+			snapshot: 1, snapOK: true,
+			current: 3, curOK: true,
+		},
+		{
+			name:     "deleted",
+			after:    func(s *Store[string, int]) { s.Delete("k") },
+			snapshot: 1, snapOK: true,
+			curOK: false,
+		},
+		{
+			name:     "deleted and set again",
+			after:    func(s *Store[string, int]) { s.Delete("k"); s.Set("k", 4) },
+			snapshot: 1, snapOK: true,
+			current: 4, curOK: true,
+		},
+		{
+			name: "updated",
+			after: func(s *Store[string, int]) {
+				s.Update("k", func(v int, _ bool) (int, error) { return v + 10, nil })
+			},
+			snapshot: 1, snapOK: true,
+			current: 11, curOK: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New[string, int]()
+			defer s.Close()
+			s.Set("k", 1)
+
+			sn := s.Snapshot()
+			defer sn.Close()
+			tt.after(s)
+
+			if v, ok := sn.Get("k"); v != tt.snapshot || ok != tt.snapOK {
+				t.Errorf("snapshot: got %d, %v, want %d, %v", v, ok, tt.snapshot, tt.snapOK)
+			}
+			if v, ok := s.Get("k"); v != tt.current || ok != tt.curOK {
+				t.Errorf("store: got %d, %v, want %d, %v", v, ok, tt.current, tt.curOK)
+			}
+		})
+	}
+}
+
+func TestSnapshotDoesNotSeeLaterKeys(t *testing.T) {
+	s := New[string, int]()
+	defer s.Close()
+	s.Set("a", 1)
+
+	sn := s.Snapshot()
+	defer sn.Close()
+	s.Set("b", 2)
+
+	if _, ok := sn.Get("b"); ok {
+		t.Error("snapshot sees a key set after it was opened")
+	}
+	var keys []string
+	sn.Range(func(e Entry[string, int]) bool {
+		keys = append(keys, e.Key)
+		return true
+	})
+	if len(keys) != 1 || keys[0] != "a" {
+		t.Errorf("snapshot ranges over %v, want [a]", keys)
+	}
+}
+
+func TestSnapshotExpiry(t *testing.T) {
+	s := New[string, int]()
+	defer s.Close()
+	s.SetWithTTL("k", 1, 50*time.Millisecond)
+
+	sn := s.Snapshot()
+	defer sn.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	if _, ok := s.Get("k"); ok {
+		t.Error("store returns an expired entry")
+	}
+	if v, ok := sn.Get("k"); !ok || v != 1 {
+		t.Errorf("snapshot: got %d, %v, want the entry live when it was opened", v, ok)
+	}
+}
+
+func TestTombstones(t *testing.T) {
+	s := New[string, int]()
+	defer s.Close()
+	s.Set("k", 1)
+
+	sn := s.Snapshot()
+	if !s.Delete("k") {
+		t.Fatal("Delete reported no live entry")
+	}
+	if s.Delete("k") {
+		t.Error("second Delete reported a live entry")
+	}
+	if n := s.Len(); n != 0 {
+		t.Errorf("Len counts the tombstone: %d", n)
+	}
+	if n := s.versions("k"); n != 2 {
+		t.Errorf("kept %d versions while a snapshot is open, want the value and its tombstone", n)
+	}
+
+	sn.Close()
+	if n := s.versions("k"); n != 0 {
+		t.Errorf("kept %d versions after the snapshot closed, want none", n)
+	}
+}
+
+func TestTrim(t *testing.T) {
+	tests := []struct {
+		name string
+		// snapshots are opened after the write with the same index. Every
+		// version since the oldest open snapshot is kept.
+		writes    []int
+		snapshots []int
+		open      int
+		closed    int
+	}{
+		{name: "no snapshots", writes: []int{1, 2, 3}, open: 1, closed: 1},
+		{name: "snapshot of the first", writes: []int{1, 2, 3}, snapshots: []int{0}, open: 3, closed: 1},
+		{name: "snapshot of the last", writes: []int{1, 2, 3}, snapshots: []int{2}, open: 1, closed: 1},
+		{name: "two snapshots", writes: []int{1, 2, 3, 4}, snapshots: []int{0, 2}, open: 4, closed: 1},
+		{name: "snapshot of the second", writes: []int{1, 2, 3}, snapshots: []int{1}, open: 2, closed: 1},
+		{name: "two snapshots of one version", writes: []int{1, 2}, snapshots: []int{0, 0}, open: 2, closed: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New[string, int]()
+			defer s.Close()
+
+			var snaps []*Snapshot[string, int]
+			for i, v := range tt.writes {
+				s.Set("k", v)
+				for _, at := range tt.snapshots {
+					if at == i {
+						snaps = append(snaps, s.Snapshot())
+					}
+				}
+			}
+
+			if n := s.versions("k"); n != tt.open {
+				t.Errorf("with snapshots open: %d versions, want %d", n, tt.open)
+			}
+			for i, sn := range snaps {
+				want := tt.writes[tt.snapshots[i]]
+				if v, _ := sn.Get("k"); v != want {
+					t.Errorf("snapshot %d: got %d, want %d", i, v, want)
+				}
+				sn.Close()
+			}
+			if n := s.versions("k"); n != tt.closed {
+				t.Errorf("with snapshots closed: %d versions, want %d", n, tt.closed)
+			}
+		})
+	}
+}
+
+func TestSize(t *testing.T) {
+	s := New(WithSizer[string, string](func(v string) int64 { return int64(len(v)) }))
+	defer s.Close()
+
+	s.Set("a", "xx")
+	s.Set("b", "yyy")
+	s.Set("a", "z")
+	if got := s.Size(); got != 4 {
+		t.Errorf("Size after overwrite: %d, want 4", got)
+	}
+
+	sn := s.Snapshot()
+	s.Delete("b")
+	sn.Close()
+	if got := s.Size(); got != 1 {
+		t.Errorf("Size after delete: %d, want 1", got)
+	}
+}
+
+func TestOnChange(t *testing.T) {
+	var ops []Op
+	s := New(WithOnChange(func(c Change[string, int]) { ops = append(ops, c.Op) }))
+	defer s.Close()
+
+	s.Set("k", 1)
+	s.Touch("k", time.Hour, nil)
+	s.Delete("k")
+	s.SetWithTTL("e", 1, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	s.Get("e")
+
+	want := []Op{OpSet, OpTouch, OpDelete, OpSet, OpExpire}
+	if len(ops) != len(want) {
+		t.Fatalf("got ops %v, want %v", ops, want)
+	}
+	for i := range want {
+		if ops[i] != want[i] {
+			t.Errorf("op %d: got %v, want %v", i, ops[i], want[i])
+		}
+	}
+}
+
+func TestOnExpire(t *testing.T) {
+	tests := []struct {
+		name string
+		// remove removes the expired entry "k".
+		remove func(s *Store[string, int])
+	}{
+		{"lookup", func(s *Store[string, int]) { s.Get("k") }},
+		{"delete", func(s *Store[string, int]) { s.Delete("k") }},
+		{"sweep", func(s *Store[string, int]) { time.Sleep(50 * time.Millisecond) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expired := make(chan string, 1)
+			s := New(
+				WithCleanupInterval[string, int](10*time.Millisecond),
+				WithOnExpire(func(key string, _ int) { expired <- key }),
+			)
+			defer s.Close()
+
+			s.SetWithTTL("k", 1, time.Millisecond)
+			time.Sleep(5 * time.Millisecond)
+			tt.remove(s)
+
+			select {
+			case key := <-expired:
+				if key != "k" {
+					t.Errorf("expired %q, want k", key)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("expiry callback not called")
+			}
+		})
+	}
+}
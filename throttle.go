@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Logins are throttled per account and per client address. After
+// loginFreeAttempts failures in a row every further attempt must wait twice
+// as long as the one before, up to loginMaxDelay, so that a two-factor code
+// cannot be guessed even once the password is known. The check is made
+// before the password is hashed, so failed logins cannot be used to keep the
+// server busy hashing either.
+
+const (
+	loginFreeAttempts = 5
+	loginMaxDelay     = 15 * time.Minute
+	// loginForget is how long failures are remembered after the last one.
+	loginForget = 2 * loginMaxDelay
+)
+
+type throttledError struct {
+	retry time.Duration
+}
+
+func (e *throttledError) Error() string {
+	return fmt.Sprintf("too many failed attempts, retry in %s", e.retry.Round(time.Second))
+}
+
+// writeThrottled writes a 429 response when err is a *throttledError and
+// reports whether it did.
+func writeThrottled(w http.ResponseWriter, err error) bool {
+	var throttled *throttledError
+	if !errors.As(err, &throttled) {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(throttled.retry.Seconds())+1))
+	http.Error(w, err.Error(), http.StatusTooManyRequests)
+	return true
+}
+
+type loginAttempts struct {
+	failures int
+	last     time.Time
+	next     time.Time
+}
+
+type loginThrottle struct {
+	mu   sync.Mutex
+	keys map[string]*loginAttempts
+}
+
+func newLoginThrottle() *loginThrottle {
+	return &loginThrottle{keys: make(map[string]*loginAttempts)}
+}
+
+// check returns a *throttledError when any of keys must still wait before
+// its next attempt.
+func (t *loginThrottle) check(now time.Time, keys ...string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var wait time.Duration
+	for _, key := range keys {
+		if a, ok := t.keys[key]; ok {
+			wait = max(wait, a.next.Sub(now))
+		}
+	}
+	if wait > 0 {
+		return &throttledError{wait}
+	}
+	return nil
+}
+
+// failed records a failed attempt for each of keys.
+func (t *loginThrottle) failed(now time.Time, keys ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, key := range keys {
+		a, ok := t.keys[key]
+		if !ok {
+			a = &loginAttempts{}
+			t.keys[key] = a
+		}
+		a.failures++
+		a.last = now
+		if n := a.failures - loginFreeAttempts; n >= 0 {
+			a.next = now.Add(min(time.Second<<min(n, 20), loginMaxDelay))
+		}
+	}
+}
+
+// succeeded forgets the failures of key.
+func (t *loginThrottle) succeeded(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.keys, key)
+}
+
+// sweep forgets the keys that have not failed for loginForget.
+func (t *loginThrottle) sweep(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, a := range t.keys {
+		if now.Sub(a.last) > loginForget {
+			delete(t.keys, key)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238, appendix B, SHA-1, truncated to six digits.
+	secret := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(secret, tt.unix/totpPeriod); got != tt.want {
+			t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name     string
+		step     int64
+		lastStep int64
+		want     bool
+	}{
+		{"current", step, 0, true},
+		{"one period early", step - 1, 0, true},
+		{"one period late", step + 1, 0, true},
+		{"two periods early", step - 2, 0, false},
+		{"two periods late", step + 2, 0, false},
+		{"replayed", step, step, false},
+		{"older than the last used", step - 1, step, false},
+		{"newer than the last used", step + 1, step, true},
+	}
+	for _, tt := range tests {
+		got, ok := verifyTOTP(secret, totpCode(secret, tt.step), now, tt.lastStep)
+		if ok != tt.want || (ok && got != tt.step) {
+			t.Errorf("%s: verifyTOTP = %d, %v; want %d, %v", tt.name, got, ok, tt.step, tt.want)
+		}
+	}
+	if _, ok := verifyTOTP(secret, "", now, 0); ok {
+		t.Error("empty code accepted")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes := newRecoveryCodes()
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("got %d codes and %d hashes", len(codes), len(hashes))
+	}
+	for _, code := range codes {
+		if !hashes[hashRecoveryCode(code)] {
+			t.Errorf("%s does not match its hash", code)
+		}
+	}
+	if a, b := hashRecoveryCode("ABCD-EFGH"), hashRecoveryCode(" abcd efgh"); a != b {
+		t.Error("recovery codes are not compared ignoring case, dashes and spaces")
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	th := newLoginThrottle()
+	start := time.Unix(0, 0)
+	now := start
+
+	for range loginFreeAttempts - 1 {
+		th.failed(now, "account:a", "addr:1")
+	}
+	if err := th.check(now, "account:a"); err != nil {
+		t.Fatalf("throttled before %d failures: %v", loginFreeAttempts, err)
+	}
+
+	th.failed(now, "account:a", "addr:1")
+	for i, wait := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		var throttled *throttledError
+		if err := th.check(now, "addr:2", "account:a"); !errors.As(err, &throttled) || throttled.retry != wait {
+			t.Fatalf("after %d failures: %v, want a wait of %s", loginFreeAttempts+i, err, wait)
+		}
+		if err := th.check(now.Add(wait), "account:a"); err != nil {
+			t.Fatalf("still throttled after %s: %v", wait, err)
+		}
+		now = now.Add(wait)
+		th.failed(now, "account:a")
+	}
+
+	for range 30 {
+		th.failed(now, "account:a")
+	}
+	if err := th.check(now.Add(loginMaxDelay), "account:a"); err != nil {
+		t.Errorf("waits beyond loginMaxDelay: %v", err)
+	}
+
+	th.succeeded("account:a")
+	if err := th.check(now, "account:a"); err != nil {
+		t.Errorf("throttled after success: %v", err)
+	}
+	if err := th.check(start, "addr:1"); err == nil {
+		t.Error("success forgot the failures of other keys")
+	}
+
+	th.sweep(now.Add(loginForget + time.Second))
+	if len(th.keys) != 0 {
+		t.Errorf("sweep kept %d keys", len(th.keys))
+	}
+}
+
+func TestLoginSecondFactor(t *testing.T) {
+	a := NewAccounts(time.Hour, nil)
+	if err := a.Register("alice", "", "correct horse battery"); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := a.StartEnrollment("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.ConfirmEnrollment("alice", "000000x"); !errors.Is(err, errInvalidTOTP) {
+		t.Fatalf("ConfirmEnrollment with a wrong code: %v", err)
+	}
+	step := time.Now().Unix() / totpPeriod
+	codes, err := a.ConfirmEnrollment("alice", totpCode(secret, step))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(code string) error {
+		r := httptest.NewRequest("POST", "/account/login", nil)
+		_, _, err := a.Login("alice", "correct horse battery", code, r)
+		return err
+	}
+
+	if err := login(""); !errors.Is(err, errTOTPRequired) {
+		t.Errorf("login without a code: %v", err)
+	}
+	// The code used to enroll cannot be used again.
+	if err := login(totpCode(secret, step)); !errors.Is(err, errInvalidTOTP) {
+		t.Errorf("login with a replayed code: %v", err)
+	}
+	if err := login(totpCode(secret, step+1)); err != nil {
+		t.Errorf("login with the next code: %v", err)
+	}
+	if err := login(codes[0]); err != nil {
+		t.Errorf("login with a recovery code: %v", err)
+	}
+	if err := login(codes[0]); !errors.Is(err, errInvalidTOTP) {
+		t.Errorf("login with a used recovery code: %v", err)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	a := NewAccounts(time.Hour, nil)
+	if err := a.Register("alice", "", "correct horse battery"); err != nil {
+		t.Fatal(err)
+	}
+	login := func(password, addr string) error {
+		r := httptest.NewRequest("POST", "/account/login", nil)
+		r.RemoteAddr = addr + ":1234"
+		_, _, err := a.Login("alice", password, "", r)
+		return err
+	}
+
+	for i := range loginFreeAttempts {
+		if err := login("wrong password", "192.0.2.1"); !errors.Is(err, errInvalidCredentials) {
+			t.Fatalf("attempt %d: %v", i+1, err)
+		}
+	}
+	// Locked by account, whatever the address and even with the password.
+	var throttled *throttledError
+	if err := login("correct horse battery", "198.51.100.1"); !errors.As(err, &throttled) {
+		t.Errorf("login after %d failures: %v, want it throttled", loginFreeAttempts, err)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"note-board/internal/dotenv"
+)
+
+var viewTemplate = template.Must(template.New("view").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.ID}} - note-board</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; }
+td, th { padding: 0.2em 1em; text-align: left; }
+.mask { color: #888; letter-spacing: 0.1em; }
+.ok { color: #2a7a2a; font-weight: bold; }
+.failed { color: #b02a2a; font-weight: bold; }
+.info { color: #666; }
+pre { background: #f6f6f6; padding: 0.5em; overflow-x: auto; }
+</style>
+</head>
+<body>
+<h1>{{.ID}}</h1>
+<p class="info">{{.Type}} clip, expires {{.Expires.Format "2006-01-02 15:04"}}.
+{{if .Signer}}Signed by {{.Signer}} ({{.Signature}}).{{else}}Not signed.{{end}}</p>
+{{with .Run}}<div class="run">
+<pre>$ {{.Command}}</pre>
+<p>{{if eq .ExitCode "0"}}<span class="ok">Succeeded</span>{{else}}<span class="failed">Failed with exit code {{.ExitCode}}</span>{{end}}
+after {{.Duration}}{{if .Started}}, started {{.Started}}{{end}}.<br>
+Ran on <b>{{.Host}}</b> in <code>{{.Dir}}</code>.{{if .Truncated}} Only the end of the output was kept.{{end}}</p>
+</div>{{end}}
+{{if .Meta}}<table>
+{{range $k, $v := .Meta}}<tr><th>{{$k}}</th><td>{{$v}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Burn}}<p>This clip is deleted when it is read. Fetch it with <code>GET /?id={{.ID}}</code>.</p>
+{{else if eq .Type "asciicast"}}<p><a href="/play?id={{.ID}}">Play the recording</a></p>
+{{else if eq .Type "env"}}<table>
+<tr><th>Variable</th><th>Value</th></tr>
+{{range .Env}}<tr><td><code>{{.Name}}</code></td><td class="mask">{{if .Value}}••••••••{{else}}(empty){{end}}</td></tr>
+{{end}}</table>
+<p class="info">Values are hidden. Use <code>note-board exec {{.ID}} -- command</code> to run a command with them.</p>
+{{else}}<pre>{{.Value}}</pre>
+{{end}}
+</body>
+</html>
+`))
+
+// viewClip renders the clip ?id= as a page for browsers. The values of env
+// clips are masked, and burn-after-read clips are not shown so that opening
+// the page does not destroy them.
+func (b *board) viewClip(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := b.clipID(w, r)
+	if !ok {
+		return
+	}
+
+	hc := &HookContext{Op: HookGet, ID: id, User: requestUser(r)}
+	if !runHooksBefore(w, hc, b.idRules) {
+		return
+	}
+	if !b.recheck(w, r, id, hc.ID) {
+		return
+	}
+	id = hc.ID
+
+	e, exists := b.store.Lookup(id)
+	if !exists {
+		http.Error(w, "not found or expired", http.StatusNotFound)
+		return
+	}
+	if !canRead(w, r, e.Value) {
+		return
+	}
+
+	data := map[string]any{
+		"ID":      id,
+		"Type":    e.Value.kind,
+		"Meta":    e.Value.meta,
+		"Expires": e.Expires.In(time.Local),
+		"Burn":    e.Value.burn,
+	}
+	if run, meta := runInfo(e.Value.meta); run != nil {
+		data["Run"], data["Meta"] = run, meta
+	}
+	if !e.Value.burn {
+		b.stats.RecordRead(hc.User, id)
+		hc.Value = e.Value.value
+		runAfterHooks(hc)
+		// Env values never reach the page, even when they do not parse.
+		if e.Value.kind == clipEnv {
+			vars, _ := dotenv.Parse(hc.Value)
+			data["Env"] = vars
+		} else {
+			data["Value"] = hc.Value
+		}
+	}
+	writeAnnotations(w, hc)
+	if sig := b.writeSignature(w, id, e.Value); e.Value.sig != nil {
+		data["Signer"], data["Signature"] = e.Value.sig.Signer, sig["status"]
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := viewTemplate.Execute(w, data); err != nil {
+		log.Printf("view: render %s: %v", id, err)
+	}
+}
+
+// runInfo returns the command run details recorded by "note-board run" in
+// the run.* labels, and the remaining labels.
+func runInfo(meta map[string]string) (map[string]any, map[string]string) {
+	if _, ok := meta["run.command"]; !ok {
+		return nil, meta
+	}
+
+	rest := make(map[string]string)
+	for k, v := range meta {
+		if !strings.HasPrefix(k, "run.") {
+			rest[k] = v
+		}
+	}
+	run := map[string]any{
+		"Command":   meta["run.command"],
+		"ExitCode":  meta["run.exit-code"],
+		"Duration":  meta["run.duration"],
+		"Host":      meta["run.host"],
+		"Dir":       meta["run.dir"],
+		"Truncated": meta["run.truncated"] == "true",
+	}
+	if t, err := time.Parse(time.RFC3339, meta["run.started"]); err == nil {
+		run["Started"] = t.In(time.Local).Format("2006-01-02 15:04:05")
+	}
+	return run, rest
+}
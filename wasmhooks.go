@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// WebAssembly hooks are loaded from every *.wasm file in NOTE_BOARD_HOOK_DIR.
+//
+// A module must export "memory" and "alloc(size i32) i32", and may export
+// "before(ptr i32, len i32) i64" and "after(ptr i32, len i32) i64". The host
+// writes the JSON encoded HookContext into memory returned by alloc and calls
+// the hook with its location. The hook returns 0 to leave the operation
+// unchanged, or ptr<<32|len of a JSON wasmHookResult.
+//
+// Modules only get WASI with no arguments, environment, files or network,
+// run with a memory cap of NOTE_BOARD_HOOK_MEMORY_PAGES 64KiB pages and are
+// interrupted after NOTE_BOARD_HOOK_TIMEOUT.
+
+type wasmHookResult struct {
+	Reject      string            `json:"reject"`
+	ID          *string           `json:"id"`
+	Value       *string           `json:"value"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+type wasmHook struct {
+	name     string
+	timeout  time.Duration
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+
+	mu  sync.Mutex
+	mod api.Module
+}
+
+func loadWasmHooks(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.wasm"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+
+	ctx := context.Background()
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(envInt("NOTE_BOARD_HOOK_MEMORY_PAGES", 256))).
+		WithCloseOnContextDone(true))
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		code, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		compiled, err := runtime.CompileModule(ctx, code)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		h := &wasmHook{
+			name:     strings.TrimSuffix(filepath.Base(path), ".wasm"),
+			timeout:  envDuration("NOTE_BOARD_HOOK_TIMEOUT", 100*time.Millisecond),
+			runtime:  runtime,
+			compiled: compiled,
+		}
+		if err := h.instantiate(); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		RegisterHook(h)
+		log.Printf("hooks: loaded %s", path)
+	}
+
+	return nil
+}
+
+// instantiate creates a fresh instance of the module. It is also used to
+// recover after a call was interrupted, which closes the instance.
+// Callers must hold h.mu or own h exclusively.
+func (h *wasmHook) instantiate() error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	mod, err := h.runtime.InstantiateModule(ctx, h.compiled, wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize"))
+	if err != nil {
+		return err
+	}
+	if mod.ExportedFunction("alloc") == nil || mod.Memory() == nil {
+		mod.Close(ctx)
+		return fmt.Errorf("module must export memory and alloc")
+	}
+
+	h.mod = mod
+	return nil
+}
+
+func (h *wasmHook) Name() string {
+	return h.name
+}
+
+func (h *wasmHook) call(fn string, hc *HookContext) (*wasmHookResult, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.mod == nil || h.mod.IsClosed() {
+		if err := h.instantiate(); err != nil {
+			return nil, err
+		}
+	}
+
+	f := h.mod.ExportedFunction(fn)
+	if f == nil {
+		return nil, nil
+	}
+
+	in, err := json.Marshal(hc)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	res, err := h.mod.ExportedFunction("alloc").Call(ctx, uint64(len(in)))
+	if err != nil {
+		return nil, err
+	}
+	ptr := uint32(res[0])
+	if !h.mod.Memory().Write(ptr, in) {
+		return nil, fmt.Errorf("alloc returned memory out of range")
+	}
+
+	res, err = f.Call(ctx, uint64(ptr), uint64(len(in)))
+	if err != nil {
+		return nil, err
+	}
+	if res[0] == 0 {
+		return nil, nil
+	}
+
+	out, ok := h.mod.Memory().Read(uint32(res[0]>>32), uint32(res[0]))
+	if !ok {
+		return nil, fmt.Errorf("%s returned memory out of range", fn)
+	}
+
+	var result wasmHookResult
+	if err := json.Unmarshal(out, &result); err != nil {
+		return nil, fmt.Errorf("%s returned invalid JSON: %w", fn, err)
+	}
+	return &result, nil
+}
+
+func (h *wasmHook) apply(hc *HookContext, result *wasmHookResult) {
+	if result.ID != nil {
+		hc.ID = *result.ID
+	}
+	if result.Value != nil {
+		hc.Value = *result.Value
+	}
+	for k, v := range result.Annotations {
+		hc.Annotate(k, v)
+	}
+}
+
+// Before fails closed: a module that traps or times out rejects the
+// operation.
+func (h *wasmHook) Before(hc *HookContext) error {
+	result, err := h.call("before", hc)
+	if err != nil {
+		return &HookError{Hook: h.name, Reason: fmt.Sprintf("hook failed: %v", err)}
+	}
+	if result == nil {
+		return nil
+	}
+	if result.Reject != "" {
+		return &HookError{Hook: h.name, Reason: result.Reject}
+	}
+
+	h.apply(hc, result)
+	return nil
+}
+
+func (h *wasmHook) After(hc *HookContext) {
+	result, err := h.call("after", hc)
+	if err != nil {
+		log.Printf("hooks: %s after %s %q: %v", h.name, hc.Op, hc.ID, err)
+		return
+	}
+	if result != nil {
+		h.apply(hc, result)
+	}
+}